@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+)
+
+// maxTelemetry is how many received messages the console keeps for the
+// telemetry command.
+const maxTelemetry = 200
+
+type received struct {
+	at       time.Time
+	msg      comms.Message
+	image    string  // file the payload was decoded to, if it was an image
+	progress float64 // completeness of a progressive frame, 0 otherwise
+}
+
+type command struct {
+	usage string
+	help  string
+	run   func(c *Console, args []string) error
+	// probeArg marks commands whose first argument is a probe ID, for
+	// tab completion.
+	probeArg bool
+}
+
+// Console is the mission-control command interpreter. It sends uplinks as
+// groundID and records everything the bus delivers to groundID.
+type Console struct {
+	client   *comms.Client
+	groundID string
+	outDir   string
+	editor   *lineEditor
+
+	progressive *comms.ProgressiveAssembler
+
+	mu        sync.Mutex
+	telemetry []received
+	probes    []string
+	captures  int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"help":      {usage: "help", help: "list commands", run: (*Console).cmdHelp},
+		"probes":    {usage: "probes", help: "list IDs subscribed on the bus", run: (*Console).cmdProbes},
+		"pending":   {usage: "pending", help: "list messages still queued on the bus", run: (*Console).cmdPending},
+		"send":      {usage: "send <id> <text>", help: "uplink a text payload, e.g. send Voyager-1 TAKE_PICTURE", run: (*Console).cmdSend, probeArg: true},
+		"sendhex":   {usage: "sendhex <id> <hex>", help: "uplink a binary payload given as hex", run: (*Console).cmdSendHex, probeArg: true},
+		"sendfile":  {usage: "sendfile <id> <path>", help: "uplink the contents of a file", run: (*Console).cmdSendFile, probeArg: true},
+		"software":  {usage: "software <id> <path>", help: "uplink flight software: .c files are compiled on board, anything else is machine code", run: (*Console).cmdSoftware, probeArg: true},
+		"telemetry": {usage: "telemetry [n]", help: "show the last n received messages (default 10)", run: (*Console).cmdTelemetry},
+		"history":   {usage: "history", help: "show command history; !n or !! repeats a command", run: (*Console).cmdHistory},
+		"quit":      {usage: "quit", help: "leave the console"},
+	}
+}
+
+// NewConsole subscribes to groundID and lists the probes on the bus. It
+// fails if the probes cannot be listed, which usually means the link is down.
+func NewConsole(client *comms.Client, groundID, outDir string) (*Console, error) {
+	c := &Console{
+		client:      client,
+		groundID:    groundID,
+		outDir:      outDir,
+		progressive: comms.NewProgressiveAssembler(),
+	}
+	c.editor = newLineEditor(groundID+"> ", c.Complete)
+	sub := client.Subscribe(groundID, c.receive)
+	if err := c.refreshProbes(); err != nil {
+		sub.Unsubscribe()
+		c.editor.Close()
+		return nil, fmt.Errorf("NewConsole: listing probes: %w", err)
+	}
+	return c, nil
+}
+
+// Run reads and executes commands until quit or end of input.
+func (c *Console) Run() {
+	defer c.editor.Close()
+	fmt.Printf("Connected as %s. Type 'help' for commands.\n", c.groundID)
+	for {
+		line, err := c.editor.ReadLine()
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line, err = c.expandHistory(line); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		c.editor.AddHistory(line)
+		if line == "quit" || line == "exit" {
+			return
+		}
+		if err := c.Execute(line); err != nil {
+			fmt.Println("error:", err)
+		}
+	}
+}
+
+// expandHistory replaces "!!" and "!n" with the matching history entry.
+func (c *Console) expandHistory(line string) (string, error) {
+	if !strings.HasPrefix(line, "!") {
+		return line, nil
+	}
+	history := c.editor.History()
+	if line == "!!" {
+		if len(history) == 0 {
+			return "", fmt.Errorf("history is empty")
+		}
+		return history[len(history)-1], nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(history) {
+		return "", fmt.Errorf("no history entry %s", line[1:])
+	}
+	return history[n-1], nil
+}
+
+// Execute runs a single command line.
+func (c *Console) Execute(line string) error {
+	fields := strings.Fields(line)
+	cmd, ok := commands[fields[0]]
+	if !ok || cmd.run == nil {
+		return fmt.Errorf("unknown command %q", fields[0])
+	}
+	return cmd.run(c, fields[1:])
+}
+
+// Complete returns the candidates for the last word of line: command names
+// first, then probe IDs for commands that take one.
+func (c *Console) Complete(line string) []string {
+	fields := strings.Fields(line)
+	if strings.HasSuffix(line, " ") {
+		fields = append(fields, "")
+	}
+	var words []string
+	switch len(fields) {
+	case 0, 1:
+		for name := range commands {
+			words = append(words, name)
+		}
+	case 2:
+		if !commands[fields[0]].probeArg {
+			return nil
+		}
+		c.mu.Lock()
+		words = append(words, c.probes...)
+		c.mu.Unlock()
+	default:
+		return nil
+	}
+	prefix := ""
+	if len(fields) > 0 {
+		prefix = fields[len(fields)-1]
+	}
+	var out []string
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			out = append(out, w)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (c *Console) refreshProbes() error {
+	ids, err := c.client.Subscribers()
+	if err != nil {
+		return err
+	}
+	var probes []string
+	for _, id := range ids {
+		if id != c.groundID && !strings.ContainsAny(id, "*?[") {
+			probes = append(probes, id)
+		}
+	}
+	c.mu.Lock()
+	c.probes = probes
+	c.mu.Unlock()
+	return nil
+}
+
+// receive records a downlinked message and decodes recognised images.
+// Progressive fragments update a per-frame preview file instead.
+func (c *Console) receive(msg comms.Message) {
+	r := received{at: time.Now(), msg: msg}
+	if comms.IsProgressiveFragment(msg.Payload) {
+		frame, err := c.progressive.Add(msg.SenderID, msg.Payload)
+		if err != nil {
+			c.editor.Printf("bad fragment from %s: %v\n", msg.SenderID, err)
+			return
+		}
+		r.image = filepath.Join(c.outDir, fmt.Sprintf("%s_frame%d.png", msg.SenderID, frame.FrameID))
+		if err := savePNG(frame.Image(), r.image); err != nil {
+			c.editor.Printf("failed to save image from %s: %v\n", msg.SenderID, err)
+			r.image = ""
+		}
+		r.progress = frame.Completeness()
+	} else if img := decodeImage(msg.Payload); img != nil {
+		c.mu.Lock()
+		c.captures++
+		n := c.captures
+		c.mu.Unlock()
+		r.image = filepath.Join(c.outDir, fmt.Sprintf("%s_%04d.png", msg.SenderID, n))
+		if err := savePNG(img, r.image); err != nil {
+			c.editor.Printf("failed to save image from %s: %v\n", msg.SenderID, err)
+			r.image = ""
+		}
+	}
+
+	c.mu.Lock()
+	c.telemetry = append(c.telemetry, r)
+	if len(c.telemetry) > maxTelemetry {
+		c.telemetry = c.telemetry[len(c.telemetry)-maxTelemetry:]
+	}
+	c.mu.Unlock()
+
+	c.editor.Printf("<< %s\n", describe(r))
+}
+
+// decodeImage returns the image carried by payload, or nil if it is not one.
+// Besides image containers it accepts the raw 128x128 RGB332 frames sent by
+// the gocpu camera.
+func decodeImage(payload []byte) image.Image {
+	if comms.IsImageContainer(payload) {
+		img, _, err := comms.DecodeImage(payload)
+		if err != nil {
+			return nil
+		}
+		return img
+	}
+	if len(payload) != 128*128 {
+		return nil
+	}
+	img, err := comms.DecodeRGB332(payload, 128, 128)
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+func savePNG(img image.Image, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, img)
+}
+
+func describe(r received) string {
+	head := fmt.Sprintf("%s %s -> %s", r.at.Format("15:04:05"), r.msg.SenderID, r.msg.TargetID)
+	if r.image != "" && r.progress > 0 {
+		return fmt.Sprintf("%s frame %.1f%% complete, saved to %s", head, r.progress, r.image)
+	}
+	if r.image != "" {
+		return fmt.Sprintf("%s image saved to %s", head, r.image)
+	}
+	return fmt.Sprintf("%s %d bytes: %s", head, len(r.msg.Payload), preview(r.msg.Payload))
+}
+
+// preview shows printable payloads as text and anything else as hex.
+func preview(payload []byte) string {
+	const max = 64
+	p := payload
+	if len(p) > max {
+		p = p[:max]
+	}
+	printable := true
+	for _, b := range p {
+		if (b < ' ' || b > '~') && b != '\n' && b != '\t' {
+			printable = false
+			break
+		}
+	}
+	s := hex.EncodeToString(p)
+	if printable {
+		s = strconv.Quote(string(p))
+	}
+	if len(payload) > max {
+		s += "..."
+	}
+	return s
+}
+
+func (c *Console) cmdHelp(args []string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-22s %s\n", commands[name].usage, commands[name].help)
+	}
+	return nil
+}
+
+func (c *Console) cmdProbes(args []string) error {
+	if err := c.refreshProbes(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.probes) == 0 {
+		fmt.Println("no probes on the bus")
+	}
+	for _, id := range c.probes {
+		fmt.Println(" ", id)
+	}
+	return nil
+}
+
+func (c *Console) cmdPending(args []string) error {
+	msgs, err := c.client.Pending()
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		fmt.Println("no pending messages")
+	}
+	for _, msg := range msgs {
+		fmt.Printf("  %s -> %s %d bytes\n", msg.SenderID, msg.TargetID, len(msg.Payload))
+	}
+	return nil
+}
+
+func (c *Console) uplink(target string, payload []byte) {
+	c.client.Send(c.groundID, target, payload)
+	fmt.Printf(">> %s: %d bytes queued\n", target, len(payload))
+}
+
+func (c *Console) cmdSend(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: %s", commands["send"].usage)
+	}
+	c.uplink(args[0], []byte(strings.Join(args[1:], " ")))
+	return nil
+}
+
+func (c *Console) cmdSendHex(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: %s", commands["sendhex"].usage)
+	}
+	payload, err := hex.DecodeString(strings.Join(args[1:], ""))
+	if err != nil {
+		return err
+	}
+	c.uplink(args[0], payload)
+	return nil
+}
+
+func (c *Console) cmdSendFile(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s", commands["sendfile"].usage)
+	}
+	payload, err := os.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	c.uplink(args[0], payload)
+	return nil
+}
+
+func (c *Console) cmdSoftware(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s", commands["software"].usage)
+	}
+	data, err := os.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	kind := comms.UplinkMachineCode
+	if strings.EqualFold(filepath.Ext(args[1]), ".c") {
+		kind = comms.UplinkSource
+	}
+	c.uplink(args[0], comms.EncodeUplink(kind, data))
+	return nil
+}
+
+func (c *Console) cmdTelemetry(args []string) error {
+	n := 10
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+			return fmt.Errorf("usage: %s", commands["telemetry"].usage)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.telemetry) == 0 {
+		fmt.Println("no telemetry received")
+	}
+	start := max(0, len(c.telemetry)-n)
+	for _, r := range c.telemetry[start:] {
+		fmt.Println(" ", describe(r))
+	}
+	return nil
+}
+
+func (c *Console) cmdHistory(args []string) error {
+	for i, line := range c.editor.History() {
+		fmt.Printf("  %3d  %s\n", i+1, line)
+	}
+	return nil
+}
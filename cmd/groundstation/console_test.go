@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConsole_ExpandHistory(t *testing.T) {
+	c := &Console{editor: &lineEditor{history: []string{"probes", "send Voyager-1 PING", "telemetry 5"}}}
+	for _, tc := range []struct {
+		line, want string
+		wantErr    bool
+	}{
+		{line: "probes", want: "probes"},
+		{line: "!!", want: "telemetry 5"},
+		{line: "!1", want: "probes"},
+		{line: "!2", want: "send Voyager-1 PING"},
+		{line: "!3", want: "telemetry 5"},
+		{line: "!0", wantErr: true},
+		{line: "!4", wantErr: true},
+		{line: "!x", wantErr: true},
+		{line: "!", wantErr: true},
+	} {
+		got, err := c.expandHistory(tc.line)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("expandHistory(%q) error = %v, wantErr %v", tc.line, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("expandHistory(%q) = %q, want %q", tc.line, got, tc.want)
+		}
+	}
+
+	empty := &Console{editor: &lineEditor{}}
+	if _, err := empty.expandHistory("!!"); err == nil {
+		t.Error("expandHistory(\"!!\") with no history succeeded")
+	}
+}
+
+func TestConsole_Complete(t *testing.T) {
+	c := &Console{probes: []string{"Voyager-1", "Voyager-2", "Pioneer-10"}}
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{"", []string{"help", "history", "pending", "probes", "quit", "send", "sendfile", "sendhex", "software", "telemetry"}},
+		{"s", []string{"send", "sendfile", "sendhex", "software"}},
+		{"sendh", []string{"sendhex"}},
+		{"te", []string{"telemetry"}},
+		{"x", nil},
+		{"send ", []string{"Pioneer-10", "Voyager-1", "Voyager-2"}},
+		{"send Voy", []string{"Voyager-1", "Voyager-2"}},
+		{"software P", []string{"Pioneer-10"}},
+		{"telemetry ", nil},
+		{"send Voyager-1 ", nil},
+	} {
+		if got := c.Complete(tc.line); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Complete(%q) = %q, want %q", tc.line, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// lineEditor reads command lines from the terminal with history (Up/Down)
+// and tab completion. When stdin is not a terminal it falls back to plain
+// line reading, which keeps the console scriptable.
+type lineEditor struct {
+	prompt   string
+	complete func(line string) []string
+
+	mu      sync.Mutex
+	raw     bool
+	in      *bufio.Reader
+	buf     []rune
+	history []string
+}
+
+func newLineEditor(prompt string, complete func(string) []string) *lineEditor {
+	e := &lineEditor{
+		prompt:   prompt,
+		complete: complete,
+		in:       bufio.NewReader(os.Stdin),
+	}
+	// cbreak delivers keys one at a time without echo, so we can react to
+	// Tab and the arrow keys. stty fails harmlessly when stdin is a pipe.
+	if err := stty("cbreak", "-echo"); err == nil {
+		e.raw = true
+	}
+	return e
+}
+
+func stty(args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// Close restores the terminal.
+func (e *lineEditor) Close() {
+	if e.raw {
+		stty("-cbreak", "echo")
+	}
+}
+
+// Printf prints a line without corrupting the line being edited.
+func (e *lineEditor) Printf(format string, args ...any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.raw {
+		fmt.Print("\r\033[K")
+	}
+	fmt.Printf(format, args...)
+	if e.raw {
+		fmt.Print(e.prompt + string(e.buf))
+	}
+}
+
+// AddHistory records a line so it can be recalled later.
+func (e *lineEditor) AddHistory(line string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+}
+
+// History returns the recorded lines, oldest first.
+func (e *lineEditor) History() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.history...)
+}
+
+// ReadLine returns the next line, or io.EOF on Ctrl-D or end of input.
+func (e *lineEditor) ReadLine() (string, error) {
+	if !e.raw {
+		fmt.Print(e.prompt)
+		line, err := e.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	e.mu.Lock()
+	e.buf = e.buf[:0]
+	fmt.Print(e.prompt)
+	pos := len(e.history)
+	e.mu.Unlock()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		e.mu.Lock()
+		switch r {
+		case '\r', '\n':
+			line := string(e.buf)
+			e.buf = e.buf[:0]
+			e.mu.Unlock()
+			fmt.Print("\r\n")
+			return line, nil
+		case 4: // Ctrl-D
+			if len(e.buf) == 0 {
+				e.mu.Unlock()
+				fmt.Print("\r\n")
+				return "", io.EOF
+			}
+		case 127, 8: // Backspace
+			if len(e.buf) > 0 {
+				e.buf = e.buf[:len(e.buf)-1]
+			}
+		case 21: // Ctrl-U
+			e.buf = e.buf[:0]
+		case '\t':
+			e.tab()
+		case 27: // ESC [ A / ESC [ B
+			e.mu.Unlock()
+			b1, _, _ := e.in.ReadRune()
+			b2, _, _ := e.in.ReadRune()
+			e.mu.Lock()
+			if b1 != '[' {
+				break
+			}
+			switch {
+			case b2 == 'A' && pos > 0:
+				pos--
+				e.buf = []rune(e.history[pos])
+			case b2 == 'B' && pos < len(e.history)-1:
+				pos++
+				e.buf = []rune(e.history[pos])
+			case b2 == 'B':
+				pos = len(e.history)
+				e.buf = e.buf[:0]
+			}
+		default:
+			if r >= ' ' {
+				e.buf = append(e.buf, r)
+			}
+		}
+		fmt.Print("\r\033[K" + e.prompt + string(e.buf))
+		e.mu.Unlock()
+	}
+}
+
+// tab completes the last word of the buffer, or lists the candidates when
+// there is more than one. Callers must hold e.mu.
+func (e *lineEditor) tab() {
+	line := string(e.buf)
+	candidates := e.complete(line)
+	if len(candidates) == 0 {
+		return
+	}
+	start := strings.LastIndex(line, " ") + 1
+	prefix := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		prefix += " "
+	}
+	if len(prefix) > len(line)-start {
+		e.buf = []rune(line[:start] + prefix)
+		return
+	}
+	if len(candidates) > 1 {
+		fmt.Print("\r\n" + strings.Join(candidates, "  ") + "\r\n")
+	}
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommonPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"send"}, "send"},
+		{[]string{"send", "sendhex", "sendfile"}, "send"},
+		{[]string{"Voyager-1", "Voyager-2"}, "Voyager-"},
+		{[]string{"probes", "pending"}, "p"},
+		{[]string{"help", "quit"}, ""},
+	} {
+		if got := commonPrefix(tc.words); got != tc.want {
+			t.Errorf("commonPrefix(%q) = %q, want %q", tc.words, got, tc.want)
+		}
+	}
+}
+
+func TestLineEditor_AddHistory(t *testing.T) {
+	e := &lineEditor{}
+	for _, line := range []string{"probes", "probes", "help", "probes"} {
+		e.AddHistory(line)
+	}
+	want := []string{"probes", "help", "probes"}
+	if got := e.History(); !reflect.DeepEqual(got, want) {
+		t.Errorf("History() = %q, want %q", got, want)
+	}
+}
+
+func TestLineEditor_ReadLine(t *testing.T) {
+	complete := (&Console{probes: []string{"Voyager-1", "Voyager-2"}}).Complete
+	history := []string{"probes", "send Voyager-1 PING"}
+
+	for _, tc := range []struct {
+		name  string
+		raw   bool
+		input string
+		want  []string
+	}{
+		{"plain", false, "probes\nhelp\r\nquit", []string{"probes", "help", "quit"}},
+		{"typed", true, "probes\r", []string{"probes"}},
+		{"backspace", true, "probz\x7fes\r", []string{"probes"}},
+		{"ctrl-u", true, "garbage\x15help\n", []string{"help"}},
+		{"control characters ignored", true, "he\x01lp\r", []string{"help"}},
+		{"up recalls newest", true, "\x1b[A\r", []string{"send Voyager-1 PING"}},
+		{"up twice", true, "\x1b[A\x1b[A\r", []string{"probes"}},
+		{"up stops at oldest", true, "\x1b[A\x1b[A\x1b[A\r", []string{"probes"}},
+		{"down past newest clears", true, "\x1b[A\x1b[B\r", []string{""}},
+		{"tab completes command", true, "tel\t\r", []string{"telemetry "}},
+		{"tab extends to common prefix", true, "send Voy\t1\r", []string{"send Voyager-1"}},
+		{"tab with no candidates", true, "x\t\r", []string{"x"}},
+		{"ctrl-d on non-empty line", true, "help\x04\r", []string{"help"}},
+		{"several lines", true, "help\rquit\r", []string{"help", "quit"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &lineEditor{
+				complete: complete,
+				raw:      tc.raw,
+				in:       bufio.NewReader(strings.NewReader(tc.input)),
+				history:  history,
+			}
+			var got []string
+			for {
+				line, err := e.ReadLine()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("ReadLine: %v", err)
+				}
+				got = append(got, line)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("lines = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	e := &lineEditor{raw: true, in: bufio.NewReader(strings.NewReader("\x04help\r"))}
+	if _, err := e.ReadLine(); err != io.EOF {
+		t.Errorf("Ctrl-D on an empty line: err = %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+)
+
+func main() {
+	connect := flag.String("connect", "tcp:localhost:7000", "simulation bus to connect to, as started with unknowngalaxy -listen")
+	groundID := flag.String("id", "Earth", "ID this ground station sends and receives as")
+	outDir := flag.String("out", ".", "directory for decoded images")
+	flag.Parse()
+
+	network, address, err := comms.ParseAddress(*connect)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	client, err := comms.Dial(network, address)
+	if err != nil {
+		fmt.Printf("Failed to connect to %s: %v\n", *connect, err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	console, err := NewConsole(client, *groundID, *outDir)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	go func() {
+		<-client.Done()
+		console.editor.Printf("Connection to %s closed.\n", *connect)
+	}()
+	console.Run()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/ground"
+)
+
+func main() {
+	archiveDir := flag.String("archive", "archive", "ground image archive, as written by unknowngalaxy -archive")
+	probe := flag.String("probe", "", "only use captures from this probe")
+	from := flag.Uint64("from", 0, "first simulation tick to include")
+	to := flag.Uint64("to", 0, "last simulation tick to include, 0 for no limit")
+	projection := flag.String("projection", "equirect", "panorama projection: equirect or cube")
+	width := flag.Int("width", 1024, "panorama width in pixels")
+	seams := flag.Int("seams", 10, "number of worst-agreeing tile pairs to report")
+	out := flag.String("out", "mosaic.png", "output PNG")
+	flag.Parse()
+
+	proj, err := ground.ParseProjection(*projection)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	archive, err := ground.OpenArchive(*archiveDir)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer archive.Close()
+	for _, err := range archive.BadLines() {
+		fmt.Printf("Skipped archive index entry: %v\n", err)
+	}
+
+	captures := archive.Query(ground.Query{ProbeID: *probe, From: *from, To: *to})
+	if len(captures) == 0 {
+		fmt.Println("No captures match.")
+		os.Exit(1)
+	}
+	tiles := make([]ground.Tile, 0, len(captures))
+	for _, c := range captures {
+		tile, err := ground.TileFromCapture(archive, c)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		tiles = append(tiles, tile)
+	}
+
+	res, err := ground.Mosaic(tiles, ground.MosaicOptions{Projection: proj, Width: *width})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Printf("Failed to create %s: %v\n", *out, err)
+		os.Exit(1)
+	}
+	if err := png.Encode(f, res.Image); err != nil {
+		fmt.Printf("Failed to encode %s: %v\n", *out, err)
+		os.Exit(1)
+	}
+	f.Close()
+
+	fmt.Printf("Stitched %d captures into %s, %.1f%% of the sky covered.\n", len(tiles), *out, 100*res.Coverage)
+	for i, s := range res.Seams {
+		if i == *seams {
+			break
+		}
+		fmt.Printf("  %s <-> %s: %d px overlap, mean difference %.1f\n", captures[s.A].ID, captures[s.B].ID, s.Pixels, s.MeanDiff)
+	}
+}
@@ -0,0 +1,227 @@
+// Command probedebug boots a single probe in an empty scene and runs its
+// guest software under the debugger. Breakpoints are set by address: the
+// compiler does not report which addresses a C source line compiled to.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/spacecraft"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+const help = `commands:
+  break <addr>          set a breakpoint at an address
+  delete <addr>         remove a breakpoint
+  watch <lo> [hi] [r|w|rw]
+                        stop on accesses to lo-hi, e.g. watch 0xFE30 0xFE3F w
+  unwatch               remove every watchpoint
+  list                  list breakpoints and watchpoints
+  step [n]              execute n instructions (default 1)
+  continue [n]          run until a stop, at most n instructions (default 1000000)
+  regs                  show registers
+  mem <addr> [n]        dump n bytes of RAM (default 64)
+  health                show probe health
+  quit`
+
+func main() {
+	osName := flag.String("os", "", fmt.Sprintf("embedded probe OS to boot, one of %v (default %s)", spacecraft.EmbeddedOS(), spacecraft.DefaultOS))
+	osFile := flag.String("os-file", "", "probe OS C source to compile and boot instead of an embedded one")
+	osBinary := flag.String("os-binary", "", "precompiled probe OS image to boot instead of an embedded one")
+	hardware := flag.String("hardware", "", "JSON hardware manifest for the probe (default: stock loadout)")
+	flag.Parse()
+
+	opts := spacecraft.ProbeOptions{OS: *osName, OSFile: *osFile}
+	if *osBinary != "" {
+		mc, err := os.ReadFile(*osBinary)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts.OSBinary = mc
+	}
+	if *hardware != "" {
+		hw, err := spacecraft.LoadHardwareManifest(*hardware)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts.Hardware = &hw
+	}
+
+	bus := comms.NewMessageBus()
+	bus.Subscribe(spacecraft.GroundStationID, func(m comms.Message) {
+		fmt.Printf("<< %s: %d bytes\n", m.SenderID, len(m.Payload))
+	})
+	scene := universe.NewLocalScene(0, 0, 0, 0, 0, 0)
+	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
+	probe, err := spacecraft.NewSpaceProbeWithOptions("Debug-1", pos, scene, bus, opts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	d := spacecraft.NewDebugger(probe)
+
+	fmt.Println("Type 'help' for commands.")
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("(probedebug) ")
+		if !in.Scan() {
+			return
+		}
+		fields := strings.Fields(in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "exit" {
+			return
+		}
+		if err := run(d, probe, bus, fields[0], fields[1:]); err != nil {
+			fmt.Println("error:", err)
+		}
+	}
+}
+
+func run(d *spacecraft.Debugger, probe *spacecraft.SpaceProbe, bus *comms.MessageBus, cmd string, args []string) error {
+	switch cmd {
+	case "help":
+		fmt.Println(help)
+	case "break", "b":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: break <addr>")
+		}
+		addr, err := parseAddr(args[0])
+		if err != nil {
+			return err
+		}
+		d.Break(addr)
+		fmt.Printf("Breakpoint at %04x\n", addr)
+	case "delete":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: delete <addr>")
+		}
+		addr, err := parseAddr(args[0])
+		if err != nil {
+			return err
+		}
+		d.Clear(addr)
+	case "watch":
+		if len(args) < 1 || len(args) > 3 {
+			return fmt.Errorf("usage: watch <lo> [hi] [r|w|rw]")
+		}
+		lo, err := parseAddr(args[0])
+		if err != nil {
+			return err
+		}
+		w := spacecraft.Watchpoint{Lo: lo, Hi: lo, Kind: spacecraft.WatchAccess}
+		for _, a := range args[1:] {
+			switch a {
+			case "r":
+				w.Kind = spacecraft.WatchRead
+			case "w":
+				w.Kind = spacecraft.WatchWrite
+			case "rw":
+				w.Kind = spacecraft.WatchAccess
+			default:
+				if w.Hi, err = parseAddr(a); err != nil {
+					return err
+				}
+			}
+		}
+		return d.Watch(w)
+	case "unwatch":
+		d.ClearWatchpoints()
+	case "list":
+		for _, a := range d.Breakpoints() {
+			fmt.Printf("break %04x\n", a)
+		}
+		for _, w := range d.Watchpoints() {
+			fmt.Printf("watch %04x-%04x %s\n", w.Lo, w.Hi, w.Kind)
+		}
+	case "step", "s":
+		n, err := count(args, 1)
+		if err != nil {
+			return err
+		}
+		var ev spacecraft.StopEvent
+		cycles := 0
+		for i := 0; i < n; i++ {
+			ev = d.Step()
+			cycles += ev.Cycles
+			if ev.Reason != spacecraft.StopStep {
+				break
+			}
+		}
+		ev.Cycles = cycles
+		bus.Tick()
+		fmt.Println(ev)
+	case "continue", "c":
+		n, err := count(args, 1000000)
+		if err != nil {
+			return err
+		}
+		ev := d.Continue(n)
+		bus.Tick()
+		fmt.Println(ev)
+	case "regs":
+		r := d.Registers()
+		fmt.Printf("PC %04x  SP %04x  FLAGS %04x  IE %v\n", r.PC, r.SP, r.Flags, r.IntEnabled)
+		for i, v := range r.R {
+			fmt.Printf("R%d %04x  ", i, v)
+		}
+		fmt.Println()
+	case "mem":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: mem <addr> [n]")
+		}
+		addr, err := parseAddr(args[0])
+		if err != nil {
+			return err
+		}
+		n, err := count(args[1:], 64)
+		if err != nil {
+			return err
+		}
+		dump(addr, d.Memory(addr, n))
+	case "health":
+		fmt.Println(probe.Health())
+	default:
+		return fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	return nil
+}
+
+// parseAddr parses a 16-bit address in decimal, or hex with a 0x prefix.
+func parseAddr(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	return uint16(v), nil
+}
+
+// count parses an optional positive count argument.
+func count(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("bad count %q", args[0])
+	}
+	return n, nil
+}
+
+func dump(addr uint16, data []byte) {
+	for i := 0; i < len(data); i += 16 {
+		end := min(i+16, len(data))
+		fmt.Printf("%04x  % x\n", int(addr)+i, data[i:end])
+	}
+}
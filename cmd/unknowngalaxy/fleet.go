@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/spacecraft"
+)
+
+// printFleetStatus writes a table of each probe's position, CPU state and
+// the messages queued on the bus to and from it.
+func printFleetStatus(out io.Writer, world *spacecraft.World) {
+	up := make(map[string]int)
+	down := make(map[string]int)
+	for _, m := range world.Bus.Pending() {
+		up[m.TargetID]++
+		down[m.SenderID]++
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "TICK %d\n", world.Tick)
+	fmt.Fprintln(tw, "PROBE\tSECTOR\tSYSTEM\tLOCAL\tCPU\tUPLINK\tDOWNLINK")
+	for _, sp := range world.Probes {
+		p := sp.Physical.Position
+		fmt.Fprintf(tw, "%s\t%d,%d,%d\t%d,%d,%d\t%.0f,%.0f,%.0f\t%s\t%d\t%d\n",
+			sp.Physical.ID,
+			p.SectorX, p.SectorY, p.SectorZ,
+			p.SystemX, p.SystemY, p.SystemZ,
+			p.LocalX, p.LocalY, p.LocalZ,
+			sp.State(), up[sp.Physical.ID], down[sp.Physical.ID])
+	}
+	tw.Flush()
+}
@@ -1,22 +1,36 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"image"
-	"image/color"
 	"image/png"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/smasonuk/si3d/pkg/si3d"
 	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/ground"
+	"github.com/smasonuk/unknowngalaxy/pkg/replay"
+	"github.com/smasonuk/unknowngalaxy/pkg/scenario"
 	"github.com/smasonuk/unknowngalaxy/pkg/spacecraft"
 	"github.com/smasonuk/unknowngalaxy/pkg/universe"
 )
 
-const probeID = "Voyager-1"
+var progressive = comms.NewProgressiveAssembler()
+
+// Ground archive state. world.Tick counts simulation ticks and probes maps
+// probe IDs to their physical state, so captures can be catalogued with where
+// the probe was and where it was looking. runID tells this run's captures
+// from those of earlier runs archived in the same directory.
+var (
+	archive *ground.Archive
+	world   *spacecraft.World
+	probes  = map[string]*universe.Probe{}
+	runID   = time.Now().UTC().Format("20060102T150405.000000000Z")
+)
 
 func saveImageToFile(img image.Image, filename string) {
 	f, err := os.Create(filename)
@@ -30,42 +44,209 @@ func saveImageToFile(img image.Image, filename string) {
 	}
 }
 
+// archiveImage stores a received image in the ground archive, placed where
+// info says it was captured. Frames sent without capture info are placed
+// where the probe is on receipt.
+func archiveImage(senderID, format string, img image.Image, info *comms.CaptureInfo) {
+	c := ground.Capture{ProbeID: senderID, SimTime: world.Tick, Format: format, RunID: runID}
+	if info != nil {
+		c.Place(*info)
+	} else if p, ok := probes[senderID]; ok {
+		c.Position = *p.Position
+		c.Pointing = p.LookAt
+	}
+	stored, err := archive.Store(c, img)
+	if err != nil {
+		fmt.Printf("EARTH: Failed to archive image from %s: %v\n", senderID, err)
+		return
+	}
+	fmt.Printf("EARTH: Received image from %s, archived as %s.\n", senderID, stored.Path)
+}
+
 func handleEarthMessage(msg comms.Message) {
-	if len(msg.Payload) == 16384 {
-		img, err := comms.DecodeRGB332(msg.Payload, 128, 128)
+	var img image.Image
+	var info *comms.CaptureInfo
+	format := comms.FormatRGB332.String()
+	switch {
+	case comms.IsProgressiveFragment(msg.Payload):
+		frame, err := progressive.Add(msg.SenderID, msg.Payload)
+		if err != nil {
+			fmt.Printf("EARTH: Bad image fragment from %s: %v\n", msg.SenderID, err)
+			return
+		}
+		if frame == nil {
+			fmt.Printf("EARTH: %s aborted a progressive frame.\n", msg.SenderID)
+			return
+		}
+		filename := fmt.Sprintf("%s_capture_%d.png", msg.SenderID, frame.FrameID)
+		saveImageToFile(frame.Image(), filename)
+		fmt.Printf("EARTH: Frame %d from %s is %.1f%% complete, saved to %s.\n", frame.FrameID, msg.SenderID, frame.Completeness(), filename)
+		if frame.Complete() {
+			archiveImage(msg.SenderID, format, frame.Image(), frame.Capture)
+		}
+		return
+	case comms.IsImageContainer(msg.Payload):
+		decoded, hdr, err := comms.DecodeImage(msg.Payload)
 		if err != nil {
 			fmt.Printf("EARTH: Failed to decode image from %s: %v\n", msg.SenderID, err)
 			return
 		}
-		filename := fmt.Sprintf("%s_capture.png", msg.SenderID)
-		saveImageToFile(img, filename)
-		fmt.Printf("EARTH: Received image from %s, saved to disk.\n", msg.SenderID)
+		fmt.Printf("EARTH: %dx%d %s image from %s.\n", hdr.Width, hdr.Height, hdr.Format, msg.SenderID)
+		img = decoded
+		format = hdr.Format.String()
+		info = hdr.Capture
+	case len(msg.Payload) == 16384:
+		decoded, err := comms.DecodeRGB332(msg.Payload, 128, 128)
+		if err != nil {
+			fmt.Printf("EARTH: Failed to decode image from %s: %v\n", msg.SenderID, err)
+			return
+		}
+		img = decoded
+	default:
+		return
 	}
+	archiveImage(msg.SenderID, format, img, info)
 }
 
 func main() {
-	// Scene
-	mountains := si3d.NewSubdividedPlaneHeightMapPerlin(
-		10000, 10000,
-		color.RGBA{R: 153, G: 196, B: 210, A: 255},
-		35, 800, 800, 42,
-	)
-	// mountains.SetDrawLinesOnly(false)
-	mountains.SetDontDrawOutlines(false)
-
-	scene := universe.NewLocalScene(10000, 25000, 35000, 0, 0, 0)
-	scene.AddEntity(&si3d.Entity{Model: mountains, X: 0, Y: 0, Z: 0})
+	listen := flag.String("listen", "", "serve the message bus to remote ground stations, e.g. tcp:localhost:7000 or unix:/tmp/unknowngalaxy.sock")
+	archiveDir := flag.String("archive", "archive", "directory in which received images are archived")
+	scenarioFile := flag.String("scenario", "", "JSON scenario to run (default: Voyager-1 over the mountains); with -load it supplies the relays, ground stations and objectives")
+	osName := flag.String("os", "", fmt.Sprintf("embedded OS to boot on every probe, one of %v", spacecraft.EmbeddedOS()))
+	osFile := flag.String("os-file", "", "probe OS C source to compile and boot on every probe instead of the scenario's")
+	osBinary := flag.String("os-binary", "", "precompiled probe OS image to boot on every probe instead of the scenario's")
+	hardware := flag.String("hardware", "", "JSON hardware manifest for every probe instead of the scenario's")
+	parallel := flag.Int("parallel", 0, "tick probes on this many goroutines (0: one at a time)")
+	status := flag.Duration("status", 10*time.Second, "how often to print the fleet status table, 0 for never")
+	load := flag.String("load", "", "resume the simulation from a save game instead of starting the scenario")
+	save := flag.String("save", "", "save the simulation to this file on shutdown")
+	record := flag.String("record", "", "record the run's inputs to this file for replay")
+	replayFile := flag.String("replay", "", "replay a recording, report whether it reproduces and exit")
+	flag.Parse()
+
+	if *load != "" && (*osName != "" || *osFile != "" || *osBinary != "" || *hardware != "") {
+		fmt.Println("-os, -os-file, -os-binary and -hardware cannot be used with -load: loaded probes keep the software and hardware they were saved with")
+		os.Exit(1)
+	}
+
+	var err error
+	scen := scenario.Default()
+	if *scenarioFile != "" {
+		if scen, err = scenario.Load(*scenarioFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	for i := range scen.Probes {
+		p := &scen.Probes[i]
+		if *osName != "" || *osFile != "" || *osBinary != "" {
+			p.OS, p.OSFile, p.OSBinary = *osName, *osFile, *osBinary
+		}
+		if *hardware != "" {
+			p.Hardware, p.HardwareFile = nil, *hardware
+		}
+	}
+
+	if *replayFile != "" {
+		w, err := replay.ReplayFile(*replayFile, comms.NewMessageBus(), nil)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Replay of %s reproduced the run up to tick %d.\n", *replayFile, w.Tick)
+		return
+	}
+
+	archive, err = ground.OpenArchive(*archiveDir)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer archive.Close()
+	for _, err := range archive.BadLines() {
+		fmt.Printf("EARTH: Skipped archive index entry: %v\n", err)
+	}
 
 	// Message bus
 	bus := comms.NewMessageBus()
-	bus.Subscribe("Earth", handleEarthMessage)
+	stations := make(map[string]bool)
+	for _, g := range scen.GroundStations {
+		bus.Subscribe(g.ID, handleEarthMessage)
+		stations[g.ID] = true
+	}
+
+	if *load != "" {
+		g, err := universe.Load(*load)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if world, err = spacecraft.LoadWorld(g, bus); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, p := range world.Probes {
+			if !stations[p.GroundStation()] {
+				fmt.Printf("%s: probe %s downlinks to %s, which is not a ground station of the scenario\n", *load, p.Physical.ID, p.GroundStation())
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("Loaded %s at tick %d with %d probes.\n", *load, world.Tick, len(world.Probes))
+	} else {
+		if world, err = scen.Build(bus); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if scen.Name != "" {
+			fmt.Printf("Scenario: %s\n", scen.Name)
+		}
+	}
+	scen.Connect(world)
+	world.Workers = *parallel
+	objectives := scen.NewTracker(runID, world.Tick)
+	for _, p := range world.Probes {
+		probes[p.Physical.ID] = p.Physical
+	}
 
-	// Probe
-	startPos := universe.NewGalacticPosition(10000, 25000, 35000, 0, 0, 0, 0, -200.0, -400.0)
-	probe := spacecraft.NewSpaceProbe(probeID, startPos, scene, bus)
+	// Inputs from outside the world go through uplink, so that a recording
+	// sees them.
+	step := func(cycles int) error {
+		world.Step(cycles)
+		return nil
+	}
+	var uplink comms.Bus = bus
+	if *record != "" {
+		f, err := os.Create(*record)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		rec, err := replay.NewRecorder(f, world)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		step, uplink = rec.Step, rec
+		fmt.Printf("Recording to %s\n", *record)
+	}
 
-	lookAtTarget := si3d.NewVector3(0, -200.0, 0)
-	probe.Physical.PointCamera(lookAtTarget)
+	if *listen != "" {
+		network, address, err := comms.ParseAddress(*listen)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		l, err := net.Listen(network, address)
+		if err != nil {
+			fmt.Printf("Failed to listen on %s: %v\n", *listen, err)
+			os.Exit(1)
+		}
+		srv := comms.NewServer(uplink)
+		go srv.Serve(l)
+		defer srv.Close()
+		fmt.Printf("Ground station link listening on %s\n", *listen)
+	}
 
 	// Graceful shutdown on SIGINT / SIGTERM
 	stop := make(chan os.Signal, 1)
@@ -74,16 +255,61 @@ func main() {
 	ticker := time.NewTicker(time.Millisecond * 16) // ~60 Hz
 	defer ticker.Stop()
 
-	fmt.Println("Simulation running. Press Ctrl+C to stop.")
+	var statusC <-chan time.Time
+	if *status > 0 {
+		statusTicker := time.NewTicker(*status)
+		defer statusTicker.Stop()
+		statusC = statusTicker.C
+	}
+
+	fmt.Printf("Simulation of %d probes running. Press Ctrl+C to stop.\n", len(world.Probes))
 
+	// A probe that stops running is reported once; it stays on the bus so an
+	// uplink can revive it.
+	states := make([]spacecraft.CPUState, len(world.Probes))
+	for i, p := range world.Probes {
+		states[i] = p.State()
+	}
 	for {
 		select {
 		case <-stop:
 			fmt.Println("Shutting down.")
+			if *save != "" {
+				if err := saveWorld(*save, world); err != nil {
+					fmt.Println(err)
+				} else {
+					fmt.Printf("Saved to %s.\n", *save)
+				}
+			}
 			return
+		case <-statusC:
+			printFleetStatus(os.Stdout, world)
 		case <-ticker.C:
-			bus.Tick()
-			probe.Tick(1000)
+			if err := step(1000); err != nil {
+				fmt.Println(err)
+				return
+			}
+			for _, d := range objectives.Update(world, archive) {
+				fmt.Printf("EARTH: Objective %q %s at tick %d.\n", d.Objective.Name, d.Status, d.Tick)
+			}
+			for i, probe := range world.Probes {
+				if s := probe.State(); s != states[i] {
+					states[i] = s
+					if s == spacecraft.CPURunning {
+						fmt.Printf("EARTH: %s is running again.\n", probe.Physical.ID)
+					} else {
+						fmt.Printf("EARTH: %s is not running.\n%s\n", probe.Physical.ID, probe.Health())
+					}
+				}
+			}
 		}
 	}
 }
+
+func saveWorld(path string, world *spacecraft.World) error {
+	g, err := world.SaveGame()
+	if err != nil {
+		return err
+	}
+	return universe.Save(path, g)
+}
@@ -0,0 +1,221 @@
+package comms
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what AsyncBus.Send does when a subscriber's mailbox
+// is full.
+type OverflowPolicy int
+
+const (
+	// Block makes Send wait until the subscriber has room, the subscription
+	// is cancelled or the bus context is done.
+	Block OverflowPolicy = iota
+	// DropOldest discards the oldest queued message to make room.
+	DropOldest
+	// DropNewest discards the message being sent.
+	DropNewest
+)
+
+// DefaultMailboxSize is used when AsyncOptions.MailboxSize is not set.
+const DefaultMailboxSize = 64
+
+// ErrBusClosed is returned by Close when the bus was already shut down.
+var ErrBusClosed = errors.New("comms: bus closed")
+
+// AsyncOptions configures an AsyncBus.
+type AsyncOptions struct {
+	MailboxSize int
+	Policy      OverflowPolicy
+}
+
+// AsyncBus delivers messages concurrently. Every subscription gets its own
+// goroutine and a bounded mailbox, so a slow receiver only holds up its own
+// traffic. Unlike MessageBus there is no Tick: messages are delivered as soon
+// as the receiver is free, and relays and routes are not supported.
+type AsyncBus struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	opts   AsyncOptions
+
+	mu      sync.Mutex
+	subs    []*asyncSub
+	closed  bool
+	sending sync.RWMutex // read-held by each Send until it has delivered
+	wg      sync.WaitGroup
+	dropped atomic.Uint64
+}
+
+type asyncSub struct {
+	*Subscription
+	mailbox chan Message
+	quit    chan struct{}
+}
+
+// NewAsyncBus creates an AsyncBus whose receivers stop when ctx is cancelled.
+func NewAsyncBus(ctx context.Context, opts AsyncOptions) *AsyncBus {
+	if opts.MailboxSize <= 0 {
+		opts.MailboxSize = DefaultMailboxSize
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &AsyncBus{ctx: ctx, cancel: cancel, opts: opts}
+}
+
+// Subscribe registers a ReceiverFunc for the given ID or topic pattern, with
+// the same matching rules as MessageBus.Subscribe. The receiver runs on its
+// own goroutine.
+func (b *AsyncBus) Subscribe(id string, receiver ReceiverFunc) *Subscription {
+	s := &asyncSub{
+		Subscription: newSubscription(id, receiver),
+		mailbox:      make(chan Message, b.opts.MailboxSize),
+		quit:         make(chan struct{}),
+	}
+	s.cancel = func() { b.unsubscribe(s) }
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.quit)
+		return s.Subscription
+	}
+	b.subs = append(b.subs, s)
+	b.wg.Add(1)
+	go b.run(s)
+	return s.Subscription
+}
+
+func (b *AsyncBus) unsubscribe(s *asyncSub) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			close(s.quit)
+			return
+		}
+	}
+}
+
+// run delivers mailbox messages until the subscription is cancelled, in which
+// case queued messages are still drained, or the bus context is done.
+func (b *AsyncBus) run(s *asyncSub) {
+	defer b.wg.Done()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case msg := <-s.mailbox:
+			s.receiver(msg)
+		case <-s.quit:
+			for {
+				select {
+				case <-b.ctx.Done():
+					return
+				case msg := <-s.mailbox:
+					s.receiver(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Send delivers a copy of the payload to every matching subscriber's mailbox,
+// applying the overflow policy to full mailboxes. Sends after Close are
+// discarded and counted as dropped.
+func (b *AsyncBus) Send(senderID string, targetID string, payload []byte) {
+	b.sending.RLock()
+	defer b.sending.RUnlock()
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		b.dropped.Add(1)
+		return
+	}
+	var targets []*asyncSub
+	for _, s := range b.subs {
+		if s.matches(senderID, targetID) {
+			targets = append(targets, s)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, s := range targets {
+		p := make([]byte, len(payload))
+		copy(p, payload)
+		b.deliver(s, Message{SenderID: senderID, TargetID: targetID, Payload: p})
+	}
+}
+
+func (b *AsyncBus) deliver(s *asyncSub, msg Message) {
+	switch b.opts.Policy {
+	case DropNewest:
+		select {
+		case s.mailbox <- msg:
+		default:
+			b.dropped.Add(1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.mailbox <- msg:
+				return
+			default:
+			}
+			select {
+			case <-s.mailbox:
+				b.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.mailbox <- msg:
+		case <-s.quit:
+			b.dropped.Add(1)
+		case <-b.ctx.Done():
+			b.dropped.Add(1)
+		}
+	}
+}
+
+// Dropped returns how many messages were discarded because of full mailboxes
+// or shutdown.
+func (b *AsyncBus) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Close stops accepting messages, lets every receiver drain its mailbox and
+// waits for the receivers to return. Cancelling the context passed to
+// NewAsyncBus aborts the drain. Messages a receiver did not get, including
+// those of Sends that raced Close, are counted as dropped.
+func (b *AsyncBus) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBusClosed
+	}
+	b.closed = true
+	subs := b.subs
+	b.subs = nil
+	for _, s := range subs {
+		close(s.quit)
+	}
+	b.mu.Unlock()
+
+	// Wait for Sends that got past the closed check, so that nothing is
+	// queued once the receivers have stopped.
+	b.sending.Lock()
+	b.sending.Unlock()
+	b.wg.Wait()
+	for _, s := range subs {
+		b.dropped.Add(uint64(len(s.mailbox)))
+	}
+	b.cancel()
+	return nil
+}
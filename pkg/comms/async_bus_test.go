@@ -0,0 +1,142 @@
+package comms
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAsyncBus_DeliversAndDrainsOnClose(t *testing.T) {
+	bus := NewAsyncBus(context.Background(), AsyncOptions{MailboxSize: 8})
+
+	var mu sync.Mutex
+	var got []byte
+	bus.Subscribe("Earth", func(msg Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, msg.Payload...)
+	})
+
+	for i := byte(0); i < 5; i++ {
+		bus.Send("Probe1", "Earth", []byte{i})
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if string(got) != "\x00\x01\x02\x03\x04" {
+		t.Errorf("received %v, want 0..4 in order", got)
+	}
+	if err := bus.Close(); err != ErrBusClosed {
+		t.Errorf("second Close = %v, want ErrBusClosed", err)
+	}
+}
+
+func TestAsyncBus_SendRacingCloseIsCounted(t *testing.T) {
+	for _, policy := range []OverflowPolicy{Block, DropOldest, DropNewest} {
+		bus := NewAsyncBus(context.Background(), AsyncOptions{MailboxSize: 4, Policy: policy})
+		var delivered atomic.Uint64
+		bus.Subscribe("Earth", func(Message) { delivered.Add(1) })
+
+		const senders, each = 8, 200
+		var wg sync.WaitGroup
+		for i := 0; i < senders; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < each; j++ {
+					bus.Send("Probe1", "Earth", []byte{1})
+				}
+			}()
+		}
+		time.Sleep(time.Millisecond)
+		bus.Close()
+		wg.Wait()
+		if got := delivered.Load() + bus.Dropped(); got != senders*each {
+			t.Errorf("policy %d: %d delivered + %d dropped, want %d sent", policy, delivered.Load(), bus.Dropped(), senders*each)
+		}
+	}
+}
+
+func TestAsyncBus_SlowReceiverDoesNotStallOthers(t *testing.T) {
+	bus := NewAsyncBus(context.Background(), AsyncOptions{MailboxSize: 1, Policy: DropNewest})
+	defer bus.Close()
+
+	release := make(chan struct{})
+	bus.Subscribe("Slow", func(msg Message) { <-release })
+
+	fast := make(chan struct{}, 1)
+	bus.Subscribe("Fast", func(msg Message) { fast <- struct{}{} })
+
+	for i := 0; i < 5; i++ {
+		bus.Send("Earth", "Slow", []byte{byte(i)})
+	}
+	bus.Send("Earth", "Fast", nil)
+
+	select {
+	case <-fast:
+	case <-time.After(time.Second):
+		t.Fatal("fast receiver stalled behind slow receiver")
+	}
+	close(release)
+
+	// One message is being handled and one fits the mailbox; the rest drop.
+	if d := bus.Dropped(); d < 3 {
+		t.Errorf("Dropped = %d, want at least 3", d)
+	}
+}
+
+func TestAsyncBus_DropOldestKeepsLatest(t *testing.T) {
+	bus := NewAsyncBus(context.Background(), AsyncOptions{MailboxSize: 2, Policy: DropOldest})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var got []byte
+	bus.Subscribe("Earth", func(msg Message) {
+		if msg.Payload[0] == 0 {
+			close(started)
+			<-release
+		}
+		got = append(got, msg.Payload[0])
+	})
+
+	bus.Send("Probe1", "Earth", []byte{0})
+	<-started
+	for i := byte(1); i <= 4; i++ {
+		bus.Send("Probe1", "Earth", []byte{i})
+	}
+	close(release)
+	bus.Close()
+
+	if string(got) != "\x00\x03\x04" {
+		t.Errorf("received %v, want [0 3 4]", got)
+	}
+}
+
+func TestAsyncBus_ContextCancelStopsReceivers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	bus := NewAsyncBus(ctx, AsyncOptions{MailboxSize: 1})
+
+	bus.Subscribe("Earth", func(msg Message) {})
+	bus.Send("Probe1", "Earth", []byte{1})
+	cancel()
+
+	// A blocked send must give up once the context is done.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			bus.Send("Probe1", "Earth", []byte{1})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Send blocked after context cancellation")
+	}
+	bus.Close()
+}
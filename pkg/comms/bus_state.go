@@ -0,0 +1,91 @@
+package comms
+
+// InFlight is a queued message and its progress across the relay network.
+type InFlight struct {
+	Message Message
+	At      string // node currently holding the message
+	Next    string // node the message is travelling to
+	ReadyAt uint64 // first tick on which the current hop may forward
+	Sent    int    // payload bytes already forwarded on the current hop
+	Hops    int
+}
+
+// BusState is the part of a MessageBus that changes as it runs: its clock
+// and queue. Subscribers, relays and routes are configuration and are not
+// included.
+type BusState struct {
+	Now   uint64
+	Queue []InFlight
+}
+
+// State returns a copy of the bus clock and queue.
+func (b *MessageBus) State() BusState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BusState{Now: b.now, Queue: make([]InFlight, 0, len(b.queue))}
+	for _, t := range b.queue {
+		m := t.msg
+		m.Payload = append([]byte(nil), m.Payload...)
+		m.Route = append([]string(nil), m.Route...)
+		s.Queue = append(s.Queue, InFlight{Message: m, At: t.at, Next: t.next, ReadyAt: t.readyAt, Sent: t.sent, Hops: t.hops})
+	}
+	return s
+}
+
+// SetState replaces the bus clock and queue, for example with a State saved
+// from another bus.
+func (b *MessageBus) SetState(s BusState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.now = s.Now
+	b.queue = make([]*transit, 0, len(s.Queue))
+	for _, f := range s.Queue {
+		m := f.Message
+		m.Payload = append([]byte(nil), m.Payload...)
+		m.Route = append([]string(nil), m.Route...)
+		b.queue = append(b.queue, &transit{msg: m, at: f.At, next: f.Next, readyAt: f.ReadyAt, sent: f.Sent, hops: f.Hops})
+	}
+}
+
+// Network is a bus's relay configuration: its relays, routing tables and
+// whether routes are traced.
+type Network struct {
+	Relays map[string]Link
+	Routes map[string]map[string]string // node to target to next hop
+	Trace  bool
+}
+
+// Network returns a copy of the bus's relay configuration.
+func (b *MessageBus) Network() Network {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := Network{Relays: make(map[string]Link), Routes: make(map[string]map[string]string), Trace: b.trace}
+	for id, l := range b.relays {
+		n.Relays[id] = l
+	}
+	for from, table := range b.routes {
+		n.Routes[from] = make(map[string]string)
+		for target, via := range table {
+			n.Routes[from][target] = via
+		}
+	}
+	return n
+}
+
+// SetNetwork replaces the bus's relay configuration.
+func (b *MessageBus) SetNetwork(n Network) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.relays = make(map[string]Link)
+	b.routes = make(map[string]map[string]string)
+	b.trace = n.Trace
+	for id, l := range n.Relays {
+		b.relays[id] = l
+	}
+	for from, table := range n.Routes {
+		b.routes[from] = make(map[string]string)
+		for target, via := range table {
+			b.routes[from][target] = via
+		}
+	}
+}
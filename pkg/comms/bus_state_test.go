@@ -0,0 +1,56 @@
+package comms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newRelayBus() *MessageBus {
+	bus := NewMessageBus()
+	bus.SetRouteTracing(true)
+	bus.AddRelay("DSN", Link{Delay: 1})
+	bus.AddRelay("Relay-2", Link{Delay: 2})
+	bus.AddRoute("Earth", "FarProbe", "DSN")
+	bus.AddRoute("DSN", "FarProbe", "Relay-2")
+	return bus
+}
+
+func TestMessageBus_StateResumesInFlight(t *testing.T) {
+	bus := newRelayBus()
+	bus.Send("Earth", "FarProbe", []byte("hello"))
+	bus.Tick()
+	bus.Tick()
+
+	// Resume on a second bus with the same relays, as after a restart.
+	state := bus.State()
+	if len(state.Queue) != 1 || state.Now != 2 {
+		t.Fatalf("state = %+v", state)
+	}
+	resumed := NewMessageBus()
+	resumed.SetNetwork(bus.Network())
+	resumed.SetState(state)
+	var got *Message
+	resumed.Subscribe("FarProbe", func(msg Message) {
+		got = &msg
+	})
+
+	// Two of the four ticks the route takes have passed.
+	resumed.Tick()
+	if got != nil {
+		t.Fatal("delivered early")
+	}
+	resumed.Tick()
+	if got == nil {
+		t.Fatal("FarProbe did not receive the resumed message")
+	}
+	if want := []string{"DSN", "Relay-2"}; !reflect.DeepEqual(got.Route, want) {
+		t.Errorf("route = %v, want %v", got.Route, want)
+	}
+	if len(bus.State().Queue) != 1 {
+		t.Error("resuming changed the original bus")
+	}
+	resumed.AddRoute("Earth", "FarProbe", "Relay-2")
+	if bus.Network().Routes["Earth"]["FarProbe"] != "DSN" {
+		t.Error("the resumed bus shares routing tables with the original")
+	}
+}
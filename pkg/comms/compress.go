@@ -0,0 +1,80 @@
+package comms
+
+import "fmt"
+
+// RLE is a PackBits-style run-length encoding, chosen because the decoder is
+// a dozen lines of code and would run comfortably in a probe guest. Each
+// chunk starts with a control byte n:
+//
+//	n < 128:  copy the next n+1 bytes literally (1-128 bytes)
+//	n >= 128: repeat the next byte n-126 times (2-129 bytes)
+//
+// Starfield frames are mostly runs of near-black pixels, which this shrinks
+// to two bytes per 129 pixels.
+const (
+	rleMaxLiteral = 128
+	rleMaxRun     = 129
+)
+
+// EncodeRLE compresses src.
+func EncodeRLE(src []byte) []byte {
+	out := make([]byte, 0, len(src)/4+2)
+	for i := 0; i < len(src); {
+		run := 1
+		for i+run < len(src) && src[i+run] == src[i] && run < rleMaxRun {
+			run++
+		}
+		if run >= 2 {
+			out = append(out, byte(run+126), src[i])
+			i += run
+			continue
+		}
+
+		// Collect literals up to the next run of two or more.
+		start := i
+		for i < len(src) && i-start < rleMaxLiteral {
+			if i+1 < len(src) && src[i+1] == src[i] {
+				break
+			}
+			i++
+		}
+		out = append(out, byte(i-start-1))
+		out = append(out, src[start:i]...)
+	}
+	return out
+}
+
+// DecodeRLE expands data produced by EncodeRLE. It fails rather than produce
+// more than maxLen bytes, and allocates no more than data can expand to, so a
+// corrupt frame or size cannot exhaust memory.
+func DecodeRLE(data []byte, maxLen int) ([]byte, error) {
+	out := make([]byte, 0, min(maxLen, rleMaxRun*len(data)))
+	for i := 0; i < len(data); {
+		n := int(data[i])
+		i++
+		if n < 128 {
+			count := n + 1
+			if i+count > len(data) {
+				return nil, fmt.Errorf("DecodeRLE: literal of %d bytes truncated at offset %d", count, i)
+			}
+			if len(out)+count > maxLen {
+				return nil, fmt.Errorf("DecodeRLE: output exceeds %d bytes", maxLen)
+			}
+			out = append(out, data[i:i+count]...)
+			i += count
+			continue
+		}
+		count := n - 126
+		if i >= len(data) {
+			return nil, fmt.Errorf("DecodeRLE: run truncated at offset %d", i)
+		}
+		if len(out)+count > maxLen {
+			return nil, fmt.Errorf("DecodeRLE: output exceeds %d bytes", maxLen)
+		}
+		for j := 0; j < count; j++ {
+			out = append(out, data[i])
+		}
+		i++
+	}
+	return out, nil
+}
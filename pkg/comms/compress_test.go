@@ -0,0 +1,115 @@
+package comms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+func TestRLE_RoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	noise := make([]byte, 1000)
+	r.Read(noise)
+
+	cases := map[string][]byte{
+		"empty":        {},
+		"single":       {7},
+		"pair":         {7, 7},
+		"long run":     bytes.Repeat([]byte{0}, 1000),
+		"noise":        noise,
+		"max literal":  noise[:128],
+		"mixed":        append(append([]byte{1, 2, 3}, bytes.Repeat([]byte{9}, 300)...), 4, 5, 5, 6),
+		"alternating":  bytes.Repeat([]byte{1, 2}, 200),
+		"run boundary": bytes.Repeat([]byte{3}, rleMaxRun+1),
+	}
+	for name, src := range cases {
+		enc := EncodeRLE(src)
+		dec, err := DecodeRLE(enc, len(src))
+		if err != nil {
+			t.Errorf("%s: DecodeRLE: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(dec, src) {
+			t.Errorf("%s: round trip mismatch", name)
+		}
+	}
+}
+
+func TestRLE_CompressesRuns(t *testing.T) {
+	frame := make([]byte, 128*128)
+	frame[500] = 0xFF
+	enc := EncodeRLE(frame)
+	if len(enc) > 300 {
+		t.Errorf("mostly black frame compressed to %d bytes, want under 300", len(enc))
+	}
+}
+
+func TestDecodeRLE_RejectsCorruptData(t *testing.T) {
+	if _, err := DecodeRLE([]byte{5, 1, 2}, 100); err == nil {
+		t.Error("truncated literal decoded without error")
+	}
+	if _, err := DecodeRLE([]byte{200}, 100); err == nil {
+		t.Error("truncated run decoded without error")
+	}
+	if _, err := DecodeRLE([]byte{255, 0}, 10); err == nil {
+		t.Error("oversized output decoded without error")
+	}
+}
+
+func TestDecodeImage_CorruptSizeDoesNotAllocate(t *testing.T) {
+	frame, err := EncodeImage(testPattern(4, 4), FormatRGB565, EncodeOptions{Compression: CompressionRLE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint16(frame[7:], 0xFFFF)
+	binary.LittleEndian.PutUint16(frame[9:], 0xFFFF)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, _, err := DecodeImage(frame); err == nil {
+		t.Error("frame with a corrupt size decoded without error")
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("decoding allocated %d bytes", n)
+	}
+}
+
+func TestImageContainer_RLE(t *testing.T) {
+	src := testPattern(32, 32)
+	for y := 16; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			src.Set(x, y, color.Black)
+		}
+	}
+	plain, err := EncodeImage(src, FormatRGB332, EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := EncodeImage(src, FormatRGB332, EncodeOptions{Compression: CompressionRLE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packed) >= len(plain) {
+		t.Errorf("RLE container is %d bytes, uncompressed %d", len(packed), len(plain))
+	}
+
+	a, _, err := DecodeImage(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, hdr, err := DecodeImage(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Compression != CompressionRLE {
+		t.Errorf("compression = %d, want RLE", hdr.Compression)
+	}
+	if !bytes.Equal(a.(*image.RGBA).Pix, b.(*image.RGBA).Pix) {
+		t.Error("RLE container decodes to different pixels")
+	}
+}
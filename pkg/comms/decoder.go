@@ -6,6 +6,21 @@ import (
 	"image/color"
 )
 
+// rgb332LUT expands every RGB332 byte to its RGBA value, so decoding is a
+// table lookup per pixel.
+var rgb332LUT = func() (lut [256][4]uint8) {
+	for i := range lut {
+		b := uint16(i)
+		lut[i] = [4]uint8{
+			uint8((b >> 5) * 255 / 7),
+			uint8(((b >> 2) & 0x07) * 255 / 7),
+			uint8((b & 0x03) * 255 / 3),
+			255,
+		}
+	}
+	return lut
+}()
+
 // DecodeRGB332 converts a flat RGB332 byte slice into an *image.RGBA.
 // Each byte encodes one pixel: bits [7:5]=R3, [4:2]=G3, [1:0]=B2.
 func DecodeRGB332(data []byte, width, height int) (*image.RGBA, error) {
@@ -14,13 +29,96 @@ func DecodeRGB332(data []byte, width, height int) (*image.RGBA, error) {
 	}
 
 	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	decodeRGB332Pix(img.Pix, data)
+	return img, nil
+}
+
+// DecodeRGB332Into decodes data into dst, reusing its pixel buffer. It is
+// meant for reprocessing large batches of same-sized frames.
+func DecodeRGB332Into(dst *image.RGBA, data []byte) error {
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	if len(data) != w*h {
+		return fmt.Errorf("DecodeRGB332Into: data length %d != %d×%d (%d)", len(data), w, h, w*h)
+	}
+	if dst.Stride == 4*w {
+		decodeRGB332Pix(dst.Pix[:4*w*h], data)
+		return nil
+	}
+	for y := 0; y < h; y++ {
+		decodeRGB332Pix(dst.Pix[y*dst.Stride:y*dst.Stride+4*w], data[y*w:(y+1)*w])
+	}
+	return nil
+}
+
+func decodeRGB332Pix(pix []uint8, data []byte) {
 	for i, b := range data {
-		r := uint8(uint16(b>>5) * 255 / 7)
-		g := uint8(uint16((b>>2)&0x07) * 255 / 7)
-		blue := uint8(uint16(b&0x03) * 255 / 3)
-		x := i % width
-		y := i / width
-		img.Set(x, y, color.RGBA{R: r, G: g, B: blue, A: 255})
+		copy(pix[4*i:4*i+4], rgb332LUT[b][:])
 	}
-	return img, nil
+}
+
+// bayer4 is the 4x4 ordered dithering matrix, in sixteenths.
+var bayer4 = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// quantize maps an 8-bit channel to the nearest of levels+1 evenly spaced
+// levels. bias, in 1/16ths of a level in [-8, 7], shifts the rounding point
+// for ordered dithering.
+func quantize(v uint8, levels, bias int) uint8 {
+	q := (int(v)*levels*16 + 255*(8+bias)) / (255 * 16)
+	return uint8(min(max(q, 0), levels))
+}
+
+// EncodeRGB332 packs img into one RGB332 byte per pixel, rounding each channel
+// to the nearest representable level. With dither set, a 4x4 ordered
+// (Bayer) dither spreads the quantisation error, which keeps smooth gradients
+// such as nebula glow from banding.
+func EncodeRGB332(img image.Image, dither bool) []byte {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := make([]byte, w*h)
+	rgba, fast := img.(*image.RGBA)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, bl uint8
+			if fast {
+				o := rgba.PixOffset(b.Min.X+x, b.Min.Y+y)
+				r, g, bl = rgba.Pix[o], rgba.Pix[o+1], rgba.Pix[o+2]
+			} else {
+				c := color.RGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA)
+				r, g, bl = c.R, c.G, c.B
+			}
+			bias := 0
+			if dither {
+				bias = bayer4[y%4][x%4] - 8
+			}
+			out[y*w+x] = quantize(r, 7, bias)<<5 | quantize(g, 7, bias)<<2 | quantize(bl, 3, bias)
+		}
+	}
+	return out
+}
+
+// RGB332Color returns the colour an RGB332 byte decodes to.
+func RGB332Color(b byte) color.RGBA {
+	v := rgb332LUT[b]
+	return color.RGBA{R: v[0], G: v[1], B: v[2], A: v[3]}
+}
+
+// RGB332Index returns the RGB332 byte nearest to c.
+func RGB332Index(c color.Color) byte {
+	v := color.RGBAModel.Convert(c).(color.RGBA)
+	return quantize(v.R, 7, 0)<<5 | quantize(v.G, 7, 0)<<2 | quantize(v.B, 3, 0)
+}
+
+// RGB332Palette returns the 256 RGB332 colours, indexed by their byte value,
+// so an *image.Paletted using it has RGB332 bytes as its Pix.
+func RGB332Palette() color.Palette {
+	p := make(color.Palette, 256)
+	for i := range p {
+		p[i] = RGB332Color(byte(i))
+	}
+	return p
 }
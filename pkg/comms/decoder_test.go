@@ -0,0 +1,131 @@
+package comms
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// decodeRGB332Reference is the original per-pixel decoder, kept to check the
+// lookup table against.
+func decodeRGB332Reference(b byte) color.RGBA {
+	r3 := (b >> 5) & 0x07
+	g3 := (b >> 2) & 0x07
+	b2 := b & 0x03
+	return color.RGBA{
+		R: uint8(uint16(r3) * 255 / 7),
+		G: uint8(uint16(g3) * 255 / 7),
+		B: uint8(uint16(b2) * 255 / 3),
+		A: 255,
+	}
+}
+
+func TestDecodeRGB332_MatchesReference(t *testing.T) {
+	data := make([]byte, 256)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	img, err := DecodeRGB332(data, 16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range data {
+		got := img.RGBAAt(i%16, i/16)
+		if want := decodeRGB332Reference(byte(i)); got != want {
+			t.Fatalf("byte %#02x decoded to %v, want %v", i, got, want)
+		}
+	}
+
+	if _, err := DecodeRGB332(data, 16, 15); err == nil {
+		t.Fatal("expected a length mismatch error")
+	}
+}
+
+func TestDecodeRGB332Into_SubImage(t *testing.T) {
+	dst := image.NewRGBA(image.Rect(0, 0, 8, 8)).SubImage(image.Rect(2, 2, 6, 6)).(*image.RGBA)
+	data := []byte{0xE0, 0x1C, 0x03, 0xFF, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if err := DecodeRGB332Into(dst, data); err != nil {
+		t.Fatal(err)
+	}
+	if got := dst.RGBAAt(2, 2); got != (color.RGBA{255, 0, 0, 255}) {
+		t.Fatalf("first pixel = %v, want red", got)
+	}
+	if got := dst.RGBAAt(5, 2); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Fatalf("fourth pixel = %v, want white", got)
+	}
+	if got := dst.RGBAAt(2, 3); got != (color.RGBA{0, 0, 0, 255}) {
+		t.Fatalf("second row = %v, want black", got)
+	}
+	if err := DecodeRGB332Into(dst, data[:15]); err == nil {
+		t.Fatal("expected a length mismatch error")
+	}
+}
+
+func TestEncodeRGB332_RoundTripsPalette(t *testing.T) {
+	pal := RGB332Palette()
+	img := image.NewPaletted(image.Rect(0, 0, 16, 16), pal)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	got := EncodeRGB332(img, false)
+	for i, b := range got {
+		if b != byte(i) {
+			t.Fatalf("pixel %d encoded to %#02x, want %#02x", i, b, i)
+		}
+		if RGB332Index(pal[i]) != byte(i) {
+			t.Fatalf("RGB332Index(%v) = %#02x, want %#02x", pal[i], RGB332Index(pal[i]), i)
+		}
+	}
+}
+
+func TestEncodeRGB332_DitherPreservesGradient(t *testing.T) {
+	// A horizontal grey ramp. Without dithering each 8-pixel block collapses
+	// to a level; with it the block average should track the ramp closely.
+	const w, h = 256, 8
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(x), uint8(x), 255})
+		}
+	}
+
+	blockError := func(data []byte) float64 {
+		var total float64
+		for bx := 0; bx < w; bx += 8 {
+			var sum, want float64
+			for y := 0; y < h; y++ {
+				for x := bx; x < bx+8; x++ {
+					sum += float64(RGB332Color(data[y*w+x]).R)
+					want += float64(x)
+				}
+			}
+			d := (sum - want) / (8 * h)
+			total += d * d
+		}
+		return total
+	}
+
+	plain := blockError(EncodeRGB332(img, false))
+	dithered := blockError(EncodeRGB332(img, true))
+	if dithered >= plain/2 {
+		t.Fatalf("dithered block error %.1f not well below undithered %.1f", dithered, plain)
+	}
+}
+
+func BenchmarkDecodeRGB332(b *testing.B) {
+	data := EncodeRGB332(testPattern(128, 128), false)
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		if _, err := DecodeRGB332(data, 128, 128); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeRGB332(b *testing.B) {
+	img := testPattern(128, 128)
+	b.SetBytes(128 * 128)
+	for b.Loop() {
+		EncodeRGB332(img, true)
+	}
+}
@@ -0,0 +1,390 @@
+package comms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"math"
+	"strings"
+)
+
+// ImageFormat identifies the pixel encoding inside an image container.
+type ImageFormat uint8
+
+const (
+	FormatRGB332  ImageFormat = 1 // 1 byte/pixel, R3 G3 B2
+	FormatGray8   ImageFormat = 2 // 1 byte/pixel luminance
+	FormatRGB565  ImageFormat = 3 // 2 bytes/pixel little-endian, R5 G6 B5
+	FormatIndexed ImageFormat = 4 // 1 byte/pixel index into the header palette
+	FormatMono1   ImageFormat = 5 // 1 bit/pixel, MSB first, rows padded to a byte
+)
+
+var formatNames = map[ImageFormat]string{
+	FormatRGB332:  "rgb332",
+	FormatGray8:   "gray8",
+	FormatRGB565:  "rgb565",
+	FormatIndexed: "indexed",
+	FormatMono1:   "mono1",
+}
+
+func (f ImageFormat) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("format(%d)", uint8(f))
+}
+
+// ParseImageFormat looks a format up by its String name, case-insensitively.
+func ParseImageFormat(name string) (ImageFormat, error) {
+	for f, n := range formatNames {
+		if strings.EqualFold(n, name) {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("ParseImageFormat: unknown format %q", name)
+}
+
+// Compression identifies how the pixel data of a container is compressed.
+type Compression uint8
+
+const (
+	CompressionNone Compression = 0
+	CompressionRLE  Compression = 1 // see EncodeRLE
+)
+
+// Container layout, all integers little-endian:
+//
+//	[Magic "UGIM"][Version: uint8][Format: uint8][Compression: uint8]
+//	[Width: uint16][Height: uint16][PaletteLen: uint16][Palette: PaletteLen×RGB]
+//	[HasCapture: uint8][Capture: captureInfoLen bytes if HasCapture is 1]
+//	[Data...]
+//
+// PaletteLen is zero for every format except FormatIndexed.
+const (
+	imageMagic     = "UGIM"
+	imageVersion   = 1
+	imageHeaderLen = 4 + 1 + 1 + 1 + 2 + 2 + 2
+)
+
+// CaptureInfo records when and where a frame was taken, and with what
+// camera, so the ground can place it without knowing how long it spent in
+// transit. Positions and pointing are in the fields of
+// universe.GalacticPosition and si3d.Vector3, which this package cannot
+// import.
+type CaptureInfo struct {
+	Tick     uint64     // simulation tick the frame was captured on
+	Sector   [3]int64   // X, Y, Z
+	System   [3]int64   // X, Y, Z
+	Local    [3]float64 // X, Y, Z
+	Pointing [3]float64 // camera look-at target, local coordinates
+
+	// FOV is the horizontal field of view of the frame in degrees. Render is
+	// the size of the square frame the camera rendered and cropped the
+	// frame's centre from. Both are as universe.CameraSpec.Layout returns
+	// them, or zero if not known.
+	FOV     float64
+	Render  uint16
+	Profile uint8 // camera profile, an index into universe.CameraProfiles
+}
+
+// Capture info layout: [Tick: uint64][Sector: 3×int64][System: 3×int64]
+// [Local: 3×float64][Pointing: 3×float64][FOV: float64][Render: uint16]
+// [Profile: uint8], float64s as IEEE 754 bits.
+const captureInfoLen = 8 + 4*3*8 + 8 + 2 + 1
+
+func appendCaptureInfo(out []byte, c CaptureInfo) []byte {
+	out = binary.LittleEndian.AppendUint64(out, c.Tick)
+	for _, v := range c.Sector {
+		out = binary.LittleEndian.AppendUint64(out, uint64(v))
+	}
+	for _, v := range c.System {
+		out = binary.LittleEndian.AppendUint64(out, uint64(v))
+	}
+	for _, v := range c.Local {
+		out = binary.LittleEndian.AppendUint64(out, math.Float64bits(v))
+	}
+	for _, v := range c.Pointing {
+		out = binary.LittleEndian.AppendUint64(out, math.Float64bits(v))
+	}
+	out = binary.LittleEndian.AppendUint64(out, math.Float64bits(c.FOV))
+	out = binary.LittleEndian.AppendUint16(out, c.Render)
+	return append(out, c.Profile)
+}
+
+// parseCaptureInfo decodes captureInfoLen bytes written by appendCaptureInfo.
+func parseCaptureInfo(data []byte) CaptureInfo {
+	next := func() uint64 {
+		v := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		return v
+	}
+	c := CaptureInfo{Tick: next()}
+	for i := range c.Sector {
+		c.Sector[i] = int64(next())
+	}
+	for i := range c.System {
+		c.System[i] = int64(next())
+	}
+	for i := range c.Local {
+		c.Local[i] = math.Float64frombits(next())
+	}
+	for i := range c.Pointing {
+		c.Pointing[i] = math.Float64frombits(next())
+	}
+	c.FOV = math.Float64frombits(next())
+	c.Render = binary.LittleEndian.Uint16(data)
+	c.Profile = data[2]
+	return c
+}
+
+// ImageHeader describes an image container.
+type ImageHeader struct {
+	Width, Height int
+	Format        ImageFormat
+	Compression   Compression
+	Palette       color.Palette // FormatIndexed only
+	Capture       *CaptureInfo  // nil if the sender did not record it
+}
+
+// EncodeOptions tunes EncodeImage.
+type EncodeOptions struct {
+	// Palette is used by FormatIndexed. If nil, the image's own palette is
+	// used when it is an *image.Paletted, otherwise palette.Plan9.
+	Palette     color.Palette
+	Compression Compression
+	Capture     *CaptureInfo // recorded in the header if set
+}
+
+// IsImageContainer reports whether data starts with an image container header.
+func IsImageContainer(data []byte) bool {
+	return len(data) >= imageHeaderLen && string(data[:4]) == imageMagic
+}
+
+// PixelDataSize returns the uncompressed size of the pixel data for a
+// width×height image in format f.
+func PixelDataSize(f ImageFormat, width, height int) (int, error) {
+	switch f {
+	case FormatRGB332, FormatGray8, FormatIndexed:
+		return width * height, nil
+	case FormatRGB565:
+		return 2 * width * height, nil
+	case FormatMono1:
+		return (width + 7) / 8 * height, nil
+	}
+	return 0, fmt.Errorf("PixelDataSize: unknown format %d", f)
+}
+
+// EncodeImage packs img into a self-describing container in the given format.
+func EncodeImage(img image.Image, f ImageFormat, opts EncodeOptions) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > 0xFFFF || h > 0xFFFF {
+		return nil, fmt.Errorf("EncodeImage: %dx%d exceeds container limits", w, h)
+	}
+
+	var pal color.Palette
+	if f == FormatIndexed {
+		pal = opts.Palette
+		if pal == nil {
+			if p, ok := img.(*image.Paletted); ok {
+				pal = p.Palette
+			} else {
+				pal = palette.Plan9
+			}
+		}
+		if len(pal) == 0 || len(pal) > 256 {
+			return nil, fmt.Errorf("EncodeImage: palette has %d colours, want 1-256", len(pal))
+		}
+	}
+
+	size, err := PixelDataSize(f, w, h)
+	if err != nil {
+		return nil, err
+	}
+	if f == FormatRGB332 {
+		hdr := ImageHeader{Width: w, Height: h, Format: f, Compression: opts.Compression, Capture: opts.Capture}
+		return appendContainer(hdr, EncodeRGB332(img, false))
+	}
+	data := make([]byte, size)
+	rowBytes := (w + 7) / 8
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA)
+			i := y*w + x
+			switch f {
+			case FormatGray8:
+				data[i] = color.GrayModel.Convert(c).(color.Gray).Y
+			case FormatRGB565:
+				v := uint16(c.R>>3)<<11 | uint16(c.G>>2)<<5 | uint16(c.B>>3)
+				binary.LittleEndian.PutUint16(data[2*i:], v)
+			case FormatIndexed:
+				data[i] = uint8(pal.Index(c))
+			case FormatMono1:
+				if color.GrayModel.Convert(c).(color.Gray).Y >= 128 {
+					data[y*rowBytes+x/8] |= 0x80 >> (x % 8)
+				}
+			}
+		}
+	}
+
+	hdr := ImageHeader{Width: w, Height: h, Format: f, Compression: opts.Compression, Palette: pal, Capture: opts.Capture}
+	return appendContainer(hdr, data)
+}
+
+func appendContainer(hdr ImageHeader, pixels []byte) ([]byte, error) {
+	data, err := compress(hdr.Compression, pixels)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, imageHeaderLen+3*len(hdr.Palette)+1+captureInfoLen+len(data))
+	out = append(out, imageMagic...)
+	out = append(out, imageVersion, byte(hdr.Format), byte(hdr.Compression))
+	out = binary.LittleEndian.AppendUint16(out, uint16(hdr.Width))
+	out = binary.LittleEndian.AppendUint16(out, uint16(hdr.Height))
+	out = binary.LittleEndian.AppendUint16(out, uint16(len(hdr.Palette)))
+	for _, pc := range hdr.Palette {
+		c := color.RGBAModel.Convert(pc).(color.RGBA)
+		out = append(out, c.R, c.G, c.B)
+	}
+	if hdr.Capture != nil {
+		out = appendCaptureInfo(append(out, 1), *hdr.Capture)
+	} else {
+		out = append(out, 0)
+	}
+	return append(out, data...), nil
+}
+
+// DecodeImageHeader parses the container header and returns it together with
+// the (possibly compressed) pixel data that follows.
+func DecodeImageHeader(data []byte) (ImageHeader, []byte, error) {
+	if !IsImageContainer(data) {
+		return ImageHeader{}, nil, fmt.Errorf("DecodeImageHeader: not an image container")
+	}
+	if version := data[4]; version != imageVersion {
+		return ImageHeader{}, nil, fmt.Errorf("DecodeImageHeader: unsupported version %d", version)
+	}
+	hdr := ImageHeader{
+		Format:      ImageFormat(data[5]),
+		Compression: Compression(data[6]),
+		Width:       int(binary.LittleEndian.Uint16(data[7:])),
+		Height:      int(binary.LittleEndian.Uint16(data[9:])),
+	}
+	n := int(binary.LittleEndian.Uint16(data[11:]))
+	rest := data[imageHeaderLen:]
+	if len(rest) < 3*n {
+		return ImageHeader{}, nil, fmt.Errorf("DecodeImageHeader: truncated palette")
+	}
+	if n > 0 {
+		hdr.Palette = make(color.Palette, n)
+		for i := range hdr.Palette {
+			hdr.Palette[i] = color.RGBA{R: rest[3*i], G: rest[3*i+1], B: rest[3*i+2], A: 255}
+		}
+	}
+	rest = rest[3*n:]
+	if len(rest) < 1 {
+		return ImageHeader{}, nil, fmt.Errorf("DecodeImageHeader: truncated header")
+	}
+	switch rest[0] {
+	case 0:
+		rest = rest[1:]
+	case 1:
+		if len(rest) < 1+captureInfoLen {
+			return ImageHeader{}, nil, fmt.Errorf("DecodeImageHeader: truncated capture info")
+		}
+		c := parseCaptureInfo(rest[1:])
+		hdr.Capture = &c
+		rest = rest[1+captureInfoLen:]
+	default:
+		return ImageHeader{}, nil, fmt.Errorf("DecodeImageHeader: bad capture flag %d", rest[0])
+	}
+	return hdr, rest, nil
+}
+
+// DecodeImage unpacks a container produced by EncodeImage. Colour formats
+// decode to *image.RGBA, FormatGray8 to *image.Gray and the palette formats to
+// *image.Paletted.
+func DecodeImage(data []byte) (image.Image, ImageHeader, error) {
+	hdr, body, err := DecodeImageHeader(data)
+	if err != nil {
+		return nil, hdr, err
+	}
+	size, err := PixelDataSize(hdr.Format, hdr.Width, hdr.Height)
+	if err != nil {
+		return nil, hdr, err
+	}
+	pixels, err := decompress(hdr.Compression, body, size)
+	if err != nil {
+		return nil, hdr, err
+	}
+	if len(pixels) != size {
+		return nil, hdr, fmt.Errorf("DecodeImage: pixel data is %d bytes, want %d", len(pixels), size)
+	}
+
+	w, h := hdr.Width, hdr.Height
+	rect := image.Rect(0, 0, w, h)
+	switch hdr.Format {
+	case FormatRGB332:
+		img, err := DecodeRGB332(pixels, w, h)
+		return img, hdr, err
+	case FormatGray8:
+		return &image.Gray{Pix: pixels, Stride: w, Rect: rect}, hdr, nil
+	case FormatRGB565:
+		img := image.NewRGBA(rect)
+		for i := 0; i < w*h; i++ {
+			v := binary.LittleEndian.Uint16(pixels[2*i:])
+			r, g, b := uint8(v>>11), uint8(v>>5)&0x3F, uint8(v)&0x1F
+			img.Pix[4*i] = r<<3 | r>>2
+			img.Pix[4*i+1] = g<<2 | g>>4
+			img.Pix[4*i+2] = b<<3 | b>>2
+			img.Pix[4*i+3] = 255
+		}
+		return img, hdr, nil
+	case FormatIndexed:
+		if len(hdr.Palette) == 0 {
+			return nil, hdr, fmt.Errorf("DecodeImage: indexed image without palette")
+		}
+		for _, p := range pixels {
+			if int(p) >= len(hdr.Palette) {
+				return nil, hdr, fmt.Errorf("DecodeImage: index %d outside %d-colour palette", p, len(hdr.Palette))
+			}
+		}
+		return &image.Paletted{Pix: pixels, Stride: w, Rect: rect, Palette: hdr.Palette}, hdr, nil
+	case FormatMono1:
+		img := image.NewPaletted(rect, color.Palette{color.Black, color.White})
+		rowBytes := (w + 7) / 8
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if pixels[y*rowBytes+x/8]&(0x80>>(x%8)) != 0 {
+					img.Pix[y*w+x] = 1
+				}
+			}
+		}
+		return img, hdr, nil
+	}
+	return nil, hdr, fmt.Errorf("DecodeImage: unknown format %d", hdr.Format)
+}
+
+// compress applies c to pixel data.
+func compress(c Compression, pixels []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return pixels, nil
+	case CompressionRLE:
+		return EncodeRLE(pixels), nil
+	}
+	return nil, fmt.Errorf("compress: unknown compression %d", c)
+}
+
+// decompress reverses compress. size is the expected uncompressed length.
+func decompress(c Compression, data []byte, size int) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return bytes.Clone(data), nil
+	case CompressionRLE:
+		return DecodeRLE(data, size)
+	}
+	return nil, fmt.Errorf("decompress: unknown compression %d", c)
+}
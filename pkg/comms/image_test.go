@@ -0,0 +1,149 @@
+package comms
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testPattern returns a small image with a gradient and some saturated pixels.
+func testPattern(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: uint8((x + y) * 8), A: 255})
+		}
+	}
+	img.Set(0, 0, color.RGBA{255, 255, 255, 255})
+	img.Set(1, 0, color.RGBA{0, 0, 0, 255})
+	return img
+}
+
+func TestImageContainer_RoundTrip(t *testing.T) {
+	src := testPattern(13, 7)
+
+	// maxErr is the largest per-channel error each format may introduce.
+	cases := []struct {
+		format ImageFormat
+		maxErr int
+	}{
+		{FormatRGB332, 85},
+		{FormatGray8, 255},
+		{FormatRGB565, 8},
+		{FormatIndexed, 255},
+		{FormatMono1, 255},
+	}
+	for _, tc := range cases {
+		t.Run(tc.format.String(), func(t *testing.T) {
+			data, err := EncodeImage(src, tc.format, EncodeOptions{})
+			if err != nil {
+				t.Fatalf("EncodeImage: %v", err)
+			}
+			if !IsImageContainer(data) {
+				t.Fatal("encoded data is not recognised as a container")
+			}
+			img, hdr, err := DecodeImage(data)
+			if err != nil {
+				t.Fatalf("DecodeImage: %v", err)
+			}
+			if hdr.Width != 13 || hdr.Height != 7 || hdr.Format != tc.format {
+				t.Errorf("header = %+v", hdr)
+			}
+			if img.Bounds() != src.Bounds() {
+				t.Fatalf("bounds = %v, want %v", img.Bounds(), src.Bounds())
+			}
+			for y := 0; y < 7; y++ {
+				for x := 0; x < 13; x++ {
+					want := src.RGBAAt(x, y)
+					got := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+					if d := maxChannelDiff(want, got); d > tc.maxErr {
+						t.Fatalf("pixel (%d,%d) = %v, want %v (diff %d)", x, y, got, want, d)
+					}
+				}
+			}
+			// White and black survive every format exactly.
+			if got := color.RGBAModel.Convert(img.At(0, 0)).(color.RGBA); got != (color.RGBA{255, 255, 255, 255}) {
+				t.Errorf("white decoded as %v", got)
+			}
+			if got := color.RGBAModel.Convert(img.At(1, 0)).(color.RGBA); got != (color.RGBA{0, 0, 0, 255}) {
+				t.Errorf("black decoded as %v", got)
+			}
+		})
+	}
+}
+
+func TestImageContainer_IndexedPalette(t *testing.T) {
+	pal := color.Palette{color.RGBA{10, 20, 30, 255}, color.RGBA{200, 100, 50, 255}}
+	src := image.NewPaletted(image.Rect(0, 0, 3, 1), pal)
+	src.Pix = []uint8{0, 1, 1}
+
+	data, err := EncodeImage(src, FormatIndexed, EncodeOptions{})
+	if err != nil {
+		t.Fatalf("EncodeImage: %v", err)
+	}
+	img, hdr, err := DecodeImage(data)
+	if err != nil {
+		t.Fatalf("DecodeImage: %v", err)
+	}
+	if len(hdr.Palette) != 2 {
+		t.Fatalf("palette has %d entries, want 2", len(hdr.Palette))
+	}
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("decoded %T, want *image.Paletted", img)
+	}
+	if p.Pix[0] != 0 || p.Pix[1] != 1 || p.Pix[2] != 1 {
+		t.Errorf("indices = %v, want [0 1 1]", p.Pix)
+	}
+}
+
+func TestDecodeImage_RejectsBadData(t *testing.T) {
+	if _, _, err := DecodeImage(make([]byte, 16384)); err == nil {
+		t.Error("raw RGB332 frame decoded as a container")
+	}
+	data, _ := EncodeImage(testPattern(4, 4), FormatRGB332, EncodeOptions{})
+	if _, _, err := DecodeImage(data[:len(data)-1]); err == nil {
+		t.Error("truncated container decoded without error")
+	}
+}
+
+func maxChannelDiff(a, b color.RGBA) int {
+	d := 0
+	for _, pair := range [][2]uint8{{a.R, b.R}, {a.G, b.G}, {a.B, b.B}} {
+		v := int(pair[0]) - int(pair[1])
+		if v < 0 {
+			v = -v
+		}
+		d = max(d, v)
+	}
+	return d
+}
+
+func TestImageContainer_CaptureInfo(t *testing.T) {
+	info := CaptureInfo{
+		Tick:     42,
+		Sector:   [3]int64{10000, 25000, -35000},
+		System:   [3]int64{1, 2, 3},
+		Local:    [3]float64{0.5, -200, 1e9},
+		Pointing: [3]float64{0, -200, 0},
+		FOV:      15,
+		Render:   1100,
+		Profile:  2,
+	}
+	data, err := EncodeImage(testPattern(5, 3), FormatGray8, EncodeOptions{Capture: &info})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, hdr, err := DecodeImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Capture == nil || *hdr.Capture != info {
+		t.Errorf("capture info = %+v, want %+v", hdr.Capture, info)
+	}
+
+	data, _ = EncodeImage(testPattern(5, 3), FormatGray8, EncodeOptions{})
+	if _, hdr, err := DecodeImage(data); err != nil || hdr.Capture != nil {
+		t.Errorf("container without capture info: capture %+v, err %v", hdr.Capture, err)
+	}
+}
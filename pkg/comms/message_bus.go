@@ -2,6 +2,8 @@ package comms
 
 import (
 	"fmt"
+	"path"
+	"strings"
 	"sync"
 )
 
@@ -10,29 +12,115 @@ type Message struct {
 	SenderID string
 	TargetID string
 	Payload  []byte
+	// Route lists the relays the message passed through, in order. It is only
+	// populated when route tracing is enabled on the bus.
+	Route []string
 }
 
 // ReceiverFunc is called by Tick when a message arrives for the registered ID.
 type ReceiverFunc func(msg Message)
 
+// Broadcast is the target ID that delivers a message to every subscriber
+// registered under a plain (non-pattern) ID, except the sender.
+const Broadcast = "*"
+
+// Subscription is the handle returned by Subscribe.
+type Subscription struct {
+	id       string
+	pattern  bool
+	receiver ReceiverFunc
+	cancel   func()
+	once     sync.Once
+}
+
+func newSubscription(id string, receiver ReceiverFunc) *Subscription {
+	return &Subscription{
+		id:       id,
+		pattern:  strings.ContainsAny(id, "*?["),
+		receiver: receiver,
+	}
+}
+
+// ID returns the ID or topic pattern the subscription was registered under.
+func (s *Subscription) ID() string { return s.id }
+
+// Unsubscribe stops further deliveries to the subscription. It is safe to call
+// more than once.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(s.cancel)
+}
+
+// matches reports whether a message for targetID sent by senderID should be
+// delivered to s.
+func (s *Subscription) matches(senderID, targetID string) bool {
+	if targetID == Broadcast {
+		return !s.pattern && s.id != senderID
+	}
+	if !s.pattern {
+		return s.id == targetID
+	}
+	ok, _ := path.Match(s.id, targetID)
+	return ok
+}
+
+// transit tracks a message while it travels across one or more hops.
+type transit struct {
+	msg     Message
+	at      string // node currently holding the message
+	next    string // node the message is travelling to
+	readyAt uint64 // first tick on which the current hop may forward
+	sent    int    // payload bytes already forwarded on the current hop
+	hops    int
+}
+
+// Bus is the interface shared by the deterministic MessageBus and the
+// concurrent AsyncBus.
+type Bus interface {
+	Subscribe(id string, receiver ReceiverFunc) *Subscription
+	Send(senderID string, targetID string, payload []byte)
+}
+
 // MessageBus queues outbound messages and delivers them synchronously on Tick.
 type MessageBus struct {
 	mu          sync.Mutex
-	subscribers map[string]ReceiverFunc
-	queue       []Message
+	subscribers []*Subscription
+	queue       []*transit
+
+	relays map[string]Link
+	routes map[string]map[string]string
+	trace  bool
+	now    uint64
 }
 
 func NewMessageBus() *MessageBus {
 	return &MessageBus{
-		subscribers: make(map[string]ReceiverFunc),
+		relays: make(map[string]Link),
+		routes: make(map[string]map[string]string),
 	}
 }
 
-// Subscribe registers a ReceiverFunc for the given ID.
-func (b *MessageBus) Subscribe(id string, receiver ReceiverFunc) {
+// Subscribe registers a ReceiverFunc for the given ID. Several receivers may
+// share an ID; each gets its own copy of the message, in subscription order.
+// An ID containing path.Match wildcards, such as "telemetry/*", subscribes to
+// every target it matches.
+func (b *MessageBus) Subscribe(id string, receiver ReceiverFunc) *Subscription {
+	sub := newSubscription(id, receiver)
+	sub.cancel = func() { b.unsubscribe(sub) }
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, sub)
+	return sub
+}
+
+func (b *MessageBus) unsubscribe(s *Subscription) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.subscribers[id] = receiver
+	for i, sub := range b.subscribers {
+		if sub == s {
+			b.subscribers = append(b.subscribers[:i:i], b.subscribers[i+1:]...)
+			return
+		}
+	}
 }
 
 // Send enqueues a message. The payload is copied defensively.
@@ -42,24 +130,142 @@ func (b *MessageBus) Send(senderID string, targetID string, payload []byte) {
 	copy(p, payload)
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.queue = append(b.queue, Message{SenderID: senderID, TargetID: targetID, Payload: p})
+	b.queue = append(b.queue, &transit{
+		msg:     Message{SenderID: senderID, TargetID: targetID, Payload: p},
+		at:      senderID,
+		next:    b.nextHop(senderID, targetID),
+		readyAt: b.now + 1,
+	})
+}
+
+// Subscribers returns the distinct IDs and patterns with a subscription, in
+// the order they were first subscribed.
+func (b *MessageBus) Subscribers() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	seen := make(map[string]bool)
+	var ids []string
+	for _, sub := range b.subscribers {
+		if !seen[sub.id] {
+			seen[sub.id] = true
+			ids = append(ids, sub.id)
+		}
+	}
+	return ids
+}
+
+// Pending returns copies of the messages that are queued or travelling
+// through relays.
+func (b *MessageBus) Pending() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	msgs := make([]Message, 0, len(b.queue))
+	for _, t := range b.queue {
+		m := t.msg
+		m.Payload = append([]byte(nil), t.msg.Payload...)
+		m.Route = append([]string(nil), t.msg.Route...)
+		msgs = append(msgs, m)
+	}
+	return msgs
 }
 
-// Tick drains the queue and delivers each message to its target subscriber.
-// Messages with no registered target are silently dropped.
+// SendMulti enqueues one copy of the message for each target.
+func (b *MessageBus) SendMulti(senderID string, targetIDs []string, payload []byte) {
+	for _, targetID := range targetIDs {
+		b.Send(senderID, targetID, payload)
+	}
+}
+
+// Tick advances the bus by one step. Messages whose current hop is ready are
+// forwarded; those reaching their target are delivered to every matching
+// subscriber. Messages with no matching subscriber are silently dropped.
 // TODO: dont silently drop, write to log
 func (b *MessageBus) Tick() {
 	b.mu.Lock()
+	b.now++
 	pending := b.queue
 	b.queue = nil
+	budgets := make(map[string]int)
+	var arrived []Message
+
+	// pending grows while we iterate: a message reaching a relay with no
+	// delay is forwarded again within the same tick.
+	for i := 0; i < len(pending); i++ {
+		t := pending[i]
+		if t.readyAt > b.now {
+			b.queue = append(b.queue, t)
+			continue
+		}
+		if !b.spend(budgets, t) {
+			b.queue = append(b.queue, t)
+			continue
+		}
+
+		if t.next == t.msg.TargetID {
+			arrived = append(arrived, t.msg)
+			continue
+		}
+		link, ok := b.relays[t.next]
+		if !ok {
+			fmt.Printf("MessageBus: dropping %s->%s, next hop %q is not a relay\n", t.msg.SenderID, t.msg.TargetID, t.next)
+			continue
+		}
+		t.hops++
+		if t.hops > MaxHops {
+			fmt.Printf("MessageBus: dropping %s->%s after %d hops\n", t.msg.SenderID, t.msg.TargetID, MaxHops)
+			continue
+		}
+		if b.trace {
+			t.msg.Route = append(t.msg.Route, t.next)
+		}
+		t.at = t.next
+		t.next = b.nextHop(t.at, t.msg.TargetID)
+		t.readyAt = b.now + uint64(link.Delay)
+		pending = append(pending, t)
+	}
 	b.mu.Unlock()
 
-	for _, msg := range pending {
-		b.mu.Lock()
-		receiver, ok := b.subscribers[msg.TargetID]
-		b.mu.Unlock()
-		if ok {
-			receiver(msg)
+	for _, msg := range arrived {
+		for _, receiver := range b.receivers(msg) {
+			m := msg
+			m.Payload = append([]byte(nil), msg.Payload...)
+			receiver(m)
+		}
+	}
+}
+
+// receivers returns the receivers subscribed to msg at the time of the call.
+func (b *MessageBus) receivers(msg Message) []ReceiverFunc {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []ReceiverFunc
+	for _, sub := range b.subscribers {
+		if sub.matches(msg.SenderID, msg.TargetID) {
+			out = append(out, sub.receiver)
 		}
 	}
+	return out
+}
+
+// spend charges the remaining payload of t against the bandwidth budget of
+// the relay holding it. It reports whether the whole payload got through.
+// Callers must hold b.mu.
+func (b *MessageBus) spend(budgets map[string]int, t *transit) bool {
+	link, ok := b.relays[t.at]
+	if !ok || link.Bandwidth <= 0 {
+		return true
+	}
+	left, seen := budgets[t.at]
+	if !seen {
+		left = link.Bandwidth
+	}
+	need := len(t.msg.Payload) - t.sent
+	if need > left {
+		t.sent += left
+		budgets[t.at] = 0
+		return false
+	}
+	budgets[t.at] = left - need
+	t.sent = 0
+	return true
 }
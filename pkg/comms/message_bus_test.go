@@ -39,3 +39,59 @@ func TestMessageBus(t *testing.T) {
 		t.Errorf("expected payload %v, got %v", payload, probe1Got.Payload)
 	}
 }
+
+func TestMessageBus_MultipleSubscribers(t *testing.T) {
+	bus := NewMessageBus()
+
+	var handled, logged int
+	bus.Subscribe("Earth", func(msg Message) { handled++ })
+	tap := bus.Subscribe("Earth", func(msg Message) { logged++ })
+
+	bus.Send("Probe1", "Earth", []byte{1})
+	bus.Tick()
+	if handled != 1 || logged != 1 {
+		t.Fatalf("handled=%d logged=%d, want 1 and 1", handled, logged)
+	}
+
+	tap.Unsubscribe()
+	tap.Unsubscribe()
+	bus.Send("Probe1", "Earth", []byte{2})
+	bus.Tick()
+	if handled != 2 || logged != 1 {
+		t.Errorf("after Unsubscribe handled=%d logged=%d, want 2 and 1", handled, logged)
+	}
+}
+
+func TestMessageBus_Broadcast(t *testing.T) {
+	bus := NewMessageBus()
+
+	got := map[string]int{}
+	for _, id := range []string{"Earth", "Probe1", "Probe2"} {
+		id := id
+		bus.Subscribe(id, func(msg Message) { got[id]++ })
+	}
+
+	bus.Send("Earth", Broadcast, []byte("all stop"))
+	bus.Tick()
+
+	if got["Earth"] != 0 {
+		t.Errorf("sender received its own broadcast")
+	}
+	if got["Probe1"] != 1 || got["Probe2"] != 1 {
+		t.Errorf("broadcast deliveries = %v, want one per probe", got)
+	}
+}
+
+func TestMessageBus_TopicPattern(t *testing.T) {
+	bus := NewMessageBus()
+
+	var topics []string
+	bus.Subscribe("telemetry/*", func(msg Message) { topics = append(topics, msg.TargetID) })
+
+	bus.SendMulti("Probe1", []string{"telemetry/power", "telemetry/imaging", "Earth"}, []byte{0})
+	bus.Tick()
+
+	if len(topics) != 2 || topics[0] != "telemetry/power" || topics[1] != "telemetry/imaging" {
+		t.Errorf("topic deliveries = %v, want [telemetry/power telemetry/imaging]", topics)
+	}
+}
@@ -0,0 +1,330 @@
+package comms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"slices"
+	"sync"
+)
+
+// Progressive transmission splits an RGB332 frame into Adam7 interlace passes.
+// The first pass carries one pixel in 64, so the ground gets a coarse preview
+// after ~1.5% of the data and can abort a bad pointing early.
+//
+// Fragment layout, all integers little-endian:
+//
+//	[Magic "UGPF"][FrameID: uint16][Pass: uint8][Width: uint16][Height: uint16]
+//	[Offset: uint32][Pixels...]
+//
+// Offset is the index of the first pixel within the pass; pixels within a
+// pass run left to right, top to bottom. A fragment with pass infoPass
+// carries the frame's CaptureInfo instead of pixels, and one with pass
+// abortPass and no pixels tells the ground that the rest of the frame will
+// not come.
+const (
+	fragmentMagic     = "UGPF"
+	fragmentHeaderLen = 4 + 2 + 1 + 2 + 2 + 4
+	infoPass          = 0xFE
+	abortPass         = 0xFF
+)
+
+// MaxProgressivePixels bounds the frame size a fragment may declare, so a
+// corrupt header cannot make the assembler allocate unbounded memory. It is
+// the largest frame a camera can produce, universe.MaxRenderSize squared.
+const MaxProgressivePixels = 2048 * 2048
+
+// DefaultMaxPartialFrames is how many unfinished frames a
+// ProgressiveAssembler keeps before forgetting the least recently updated.
+const DefaultMaxPartialFrames = 16
+
+// DefaultMaxPartialPixels is how many pixels the unfinished frames of a
+// ProgressiveAssembler may hold between them, room for two of the largest.
+// Each pixel costs two bytes of assembler memory.
+const DefaultMaxPartialPixels = 2 * MaxProgressivePixels
+
+// adam7 lists x start, y start, x step and y step for each pass.
+var adam7 = [7][4]int{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+// passSize returns the number of pixels of a width×height frame in the given
+// pass.
+func passSize(pass, width, height int) int {
+	p := adam7[pass]
+	if width <= p[0] || height <= p[1] {
+		return 0
+	}
+	return ((width - p[0] + p[2] - 1) / p[2]) * ((height - p[1] + p[3] - 1) / p[3])
+}
+
+// passPixels returns the pixel indices of a width×height frame covered by
+// the given pass, in transmission order.
+func passPixels(pass, width, height int) []int {
+	p := adam7[pass]
+	var idx []int
+	for y := p[1]; y < height; y += p[3] {
+		for x := p[0]; x < width; x += p[2] {
+			idx = append(idx, y*width+x)
+		}
+	}
+	return idx
+}
+
+// IsProgressiveFragment reports whether data is a progressive image fragment.
+func IsProgressiveFragment(data []byte) bool {
+	return len(data) >= fragmentHeaderLen && string(data[:4]) == fragmentMagic
+}
+
+// EncodeProgressive splits img into fragments whose size does not exceed
+// maxFragment bytes, coarsest pass first.
+func EncodeProgressive(img image.Image, frameID uint16, maxFragment int) ([][]byte, error) {
+	if maxFragment <= fragmentHeaderLen {
+		return nil, fmt.Errorf("EncodeProgressive: fragment size %d leaves no room for pixels", maxFragment)
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > 0xFFFF || h > 0xFFFF {
+		return nil, fmt.Errorf("EncodeProgressive: %dx%d exceeds fragment limits", w, h)
+	}
+	pixels := EncodeRGB332(img, false)
+
+	perFragment := maxFragment - fragmentHeaderLen
+	var fragments [][]byte
+	for pass := range adam7 {
+		idx := passPixels(pass, w, h)
+		for off := 0; off < len(idx); off += perFragment {
+			end := min(off+perFragment, len(idx))
+			frag := make([]byte, 0, fragmentHeaderLen+end-off)
+			frag = append(frag, fragmentMagic...)
+			frag = binary.LittleEndian.AppendUint16(frag, frameID)
+			frag = append(frag, byte(pass))
+			frag = binary.LittleEndian.AppendUint16(frag, uint16(w))
+			frag = binary.LittleEndian.AppendUint16(frag, uint16(h))
+			frag = binary.LittleEndian.AppendUint32(frag, uint32(off))
+			for _, i := range idx[off:end] {
+				frag = append(frag, pixels[i])
+			}
+			fragments = append(fragments, frag)
+		}
+	}
+	return fragments, nil
+}
+
+// EncodeProgressiveInfo returns the fragment that records when and where a
+// width×height frame was captured. Senders put it before the frame's pixels.
+func EncodeProgressiveInfo(frameID uint16, width, height int, c CaptureInfo) []byte {
+	frag := make([]byte, 0, fragmentHeaderLen+captureInfoLen)
+	frag = append(frag, fragmentMagic...)
+	frag = binary.LittleEndian.AppendUint16(frag, frameID)
+	frag = append(frag, infoPass)
+	frag = binary.LittleEndian.AppendUint16(frag, uint16(width))
+	frag = binary.LittleEndian.AppendUint16(frag, uint16(height))
+	frag = binary.LittleEndian.AppendUint32(frag, 0)
+	return appendCaptureInfo(frag, c)
+}
+
+// EncodeProgressiveAbort returns the fragment that abandons a frame whose
+// remaining fragments will not be sent.
+func EncodeProgressiveAbort(frameID uint16) []byte {
+	frag := make([]byte, 0, fragmentHeaderLen)
+	frag = append(frag, fragmentMagic...)
+	frag = binary.LittleEndian.AppendUint16(frag, frameID)
+	frag = append(frag, abortPass)
+	return append(frag, make([]byte, fragmentHeaderLen-len(frag))...)
+}
+
+// ProgressiveFrame is a partially received progressive image.
+type ProgressiveFrame struct {
+	SenderID string
+	FrameID  uint16
+	Width    int
+	Height   int
+	Capture  *CaptureInfo // nil until its info fragment arrives
+
+	pixels   []byte
+	known    []bool
+	received int
+	used     uint64 // when the frame was last added to, in Adds
+}
+
+// Completeness returns the percentage of pixels received so far.
+func (f *ProgressiveFrame) Completeness() float64 {
+	if len(f.pixels) == 0 {
+		return 100
+	}
+	return 100 * float64(f.received) / float64(len(f.pixels))
+}
+
+// Complete reports whether every pixel has been received.
+func (f *ProgressiveFrame) Complete() bool {
+	return f.received == len(f.pixels)
+}
+
+// previewBlocks are the Adam7 grid cell sizes, finest first. A missing pixel
+// takes the value of the top-left corner of the finest cell containing it
+// that has been received.
+var previewBlocks = [][2]int{{1, 1}, {1, 2}, {2, 2}, {2, 4}, {4, 4}, {4, 8}, {8, 8}}
+
+// Image renders the frame received so far. Missing pixels are filled from the
+// nearest coarser pass, which gives a blocky preview that sharpens as passes
+// arrive. Pixels with no received neighbour are black.
+func (f *ProgressiveFrame) Image() *image.RGBA {
+	out := make([]byte, len(f.pixels))
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			for _, blk := range previewBlocks {
+				i := (y-y%blk[1])*f.Width + (x - x%blk[0])
+				if f.known[i] {
+					out[y*f.Width+x] = f.pixels[i]
+					break
+				}
+			}
+		}
+	}
+	img, _ := DecodeRGB332(out, f.Width, f.Height)
+	return img
+}
+
+// ProgressiveAssembler collects fragments into frames, keyed by sender and
+// frame ID. It keeps at most MaxPartialFrames unfinished frames holding at
+// most MaxPartialPixels pixels, forgetting the least recently updated beyond
+// that. It is safe for concurrent use.
+type ProgressiveAssembler struct {
+	MaxPartialFrames int // DefaultMaxPartialFrames if 0
+	MaxPartialPixels int // DefaultMaxPartialPixels if 0
+
+	mu     sync.Mutex
+	frames map[string]*ProgressiveFrame
+	pixels int // held by frames
+	adds   uint64
+}
+
+func NewProgressiveAssembler() *ProgressiveAssembler {
+	return &ProgressiveAssembler{frames: make(map[string]*ProgressiveFrame)}
+}
+
+// Partial returns the number of unfinished frames the assembler holds.
+func (a *ProgressiveAssembler) Partial() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.frames)
+}
+
+// Add merges a fragment into its frame and returns a snapshot of the frame.
+// Completed frames are forgotten by the assembler, and so are aborted ones:
+// for an abort fragment Add returns a nil frame. A fragment that does not fit
+// the frame size its header declares, or the size of the frame's earlier
+// fragments, is rejected.
+func (a *ProgressiveAssembler) Add(senderID string, fragment []byte) (*ProgressiveFrame, error) {
+	if !IsProgressiveFragment(fragment) {
+		return nil, fmt.Errorf("ProgressiveAssembler.Add: not a progressive fragment")
+	}
+	frameID := binary.LittleEndian.Uint16(fragment[4:])
+	pass := int(fragment[6])
+	w := int(binary.LittleEndian.Uint16(fragment[7:]))
+	h := int(binary.LittleEndian.Uint16(fragment[9:]))
+	off := int(binary.LittleEndian.Uint32(fragment[11:]))
+	data := fragment[fragmentHeaderLen:]
+	key := fmt.Sprintf("%s/%d", senderID, frameID)
+	if pass == abortPass {
+		a.mu.Lock()
+		a.forget(key)
+		a.mu.Unlock()
+		return nil, nil
+	}
+	if w*h > MaxProgressivePixels {
+		return nil, fmt.Errorf("ProgressiveAssembler.Add: %dx%d frame is too large", w, h)
+	}
+	switch {
+	case pass == infoPass:
+		if len(data) != captureInfoLen {
+			return nil, fmt.Errorf("ProgressiveAssembler.Add: info fragment has %d bytes, want %d", len(data), captureInfoLen)
+		}
+	case pass >= len(adam7):
+		return nil, fmt.Errorf("ProgressiveAssembler.Add: bad pass %d", pass)
+	case off+len(data) > passSize(pass, w, h):
+		return nil, fmt.Errorf("ProgressiveAssembler.Add: fragment overruns pass %d", pass)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.adds++
+	f, ok := a.frames[key]
+	if ok && (f.Width != w || f.Height != h) {
+		return nil, fmt.Errorf("ProgressiveAssembler.Add: %dx%d fragment for a %dx%d frame", w, h, f.Width, f.Height)
+	}
+	if !ok {
+		a.evict(w * h)
+		f = &ProgressiveFrame{
+			SenderID: senderID,
+			FrameID:  frameID,
+			Width:    w,
+			Height:   h,
+			pixels:   make([]byte, w*h),
+			known:    make([]bool, w*h),
+		}
+		a.frames[key] = f
+		a.pixels += w * h
+	}
+
+	f.used = a.adds
+	if pass == infoPass {
+		c := parseCaptureInfo(data)
+		f.Capture = &c
+	} else {
+		idx := passPixels(pass, w, h)
+		for j, v := range data {
+			i := idx[off+j]
+			f.pixels[i] = v
+			if !f.known[i] {
+				f.known[i] = true
+				f.received++
+			}
+		}
+	}
+	if f.Complete() {
+		a.forget(key)
+	}
+	snap := *f
+	snap.pixels = bytes.Clone(f.pixels)
+	snap.known = slices.Clone(f.known)
+	return &snap, nil
+}
+
+// forget drops a frame. a.mu must be held.
+func (a *ProgressiveAssembler) forget(key string) {
+	if f, ok := a.frames[key]; ok {
+		a.pixels -= len(f.pixels)
+		delete(a.frames, key)
+	}
+}
+
+// evict forgets least recently updated frames until there is room for one
+// more of the given number of pixels. a.mu must be held.
+func (a *ProgressiveAssembler) evict(pixels int) {
+	limit := a.MaxPartialFrames
+	if limit <= 0 {
+		limit = DefaultMaxPartialFrames
+	}
+	pixelLimit := a.MaxPartialPixels
+	if pixelLimit <= 0 {
+		pixelLimit = DefaultMaxPartialPixels
+	}
+	for len(a.frames) > 0 && (len(a.frames) >= limit || a.pixels+pixels > pixelLimit) {
+		var oldest string
+		for key, f := range a.frames {
+			if oldest == "" || f.used < a.frames[oldest].used {
+				oldest = key
+			}
+		}
+		a.forget(oldest)
+	}
+}
@@ -0,0 +1,193 @@
+package comms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestProgressive_RoundTrip(t *testing.T) {
+	src := testPattern(37, 21)
+	frags, err := EncodeProgressive(src, 7, 64)
+	if err != nil {
+		t.Fatalf("EncodeProgressive: %v", err)
+	}
+	for _, f := range frags {
+		if len(f) > 64 {
+			t.Fatalf("fragment of %d bytes exceeds limit", len(f))
+		}
+	}
+
+	asm := NewProgressiveAssembler()
+	var last *ProgressiveFrame
+	prev := -1.0
+	for i, frag := range frags {
+		if !IsProgressiveFragment(frag) {
+			t.Fatalf("fragment %d not recognised", i)
+		}
+		f, err := asm.Add("Probe1", frag)
+		if err != nil {
+			t.Fatalf("Add fragment %d: %v", i, err)
+		}
+		if c := f.Completeness(); c <= prev {
+			t.Errorf("completeness did not grow: %.1f after %.1f", c, prev)
+		}
+		prev = f.Completeness()
+		last = f
+	}
+	if !last.Complete() || last.Completeness() != 100 {
+		t.Fatalf("frame incomplete after all fragments: %.1f%%", last.Completeness())
+	}
+
+	container, _ := EncodeImage(src, FormatRGB332, EncodeOptions{})
+	want, _, _ := DecodeImage(container)
+	if !bytes.Equal(last.Image().Pix, want.(*image.RGBA).Pix) {
+		t.Error("assembled frame differs from the source image")
+	}
+}
+
+func TestProgressive_FirstPassPreview(t *testing.T) {
+	src := testPattern(16, 16)
+	frags, err := EncodeProgressive(src, 1, 1024)
+	if err != nil {
+		t.Fatalf("EncodeProgressive: %v", err)
+	}
+
+	asm := NewProgressiveAssembler()
+	f, err := asm.Add("Probe1", frags[0])
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	// Pass 1 of a 16x16 frame is the four pixels on the 8x8 grid.
+	if got, want := f.Completeness(), 100*4.0/256; got != want {
+		t.Errorf("completeness = %.3f, want %.3f", got, want)
+	}
+	preview := f.Image()
+	if preview.RGBAAt(5, 6) != preview.RGBAAt(0, 0) {
+		t.Error("pixel in the first 8x8 block was not filled from its corner")
+	}
+	if preview.RGBAAt(12, 9) != preview.RGBAAt(8, 8) {
+		t.Error("pixel in the last 8x8 block was not filled from its corner")
+	}
+}
+
+func TestProgressive_RejectsOverrun(t *testing.T) {
+	frags, _ := EncodeProgressive(testPattern(8, 8), 1, 1024)
+	bad := append(frags[len(frags)-1], 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	if _, err := NewProgressiveAssembler().Add("Probe1", bad); err == nil {
+		t.Error("fragment overrunning its pass was accepted")
+	}
+}
+
+func TestProgressive_AbortForgetsFrame(t *testing.T) {
+	frags, _ := EncodeProgressive(testPattern(8, 8), 3, 16)
+	asm := NewProgressiveAssembler()
+	if _, err := asm.Add("Probe1", frags[0]); err != nil {
+		t.Fatal(err)
+	}
+	f, err := asm.Add("Probe1", EncodeProgressiveAbort(3))
+	if err != nil || f != nil {
+		t.Fatalf("abort: got %v, %v; want nil, nil", f, err)
+	}
+	if n := asm.Partial(); n != 0 {
+		t.Errorf("%d partial frames after the abort, want 0", n)
+	}
+}
+
+func TestProgressive_EvictsLeastRecentlyUpdated(t *testing.T) {
+	asm := &ProgressiveAssembler{MaxPartialFrames: 2, frames: make(map[string]*ProgressiveFrame)}
+	first := func(id uint16) []byte {
+		frags, _ := EncodeProgressive(testPattern(8, 8), id, 16)
+		return frags[0]
+	}
+	for _, id := range []uint16{1, 2, 1, 3} {
+		if _, err := asm.Add("Probe1", first(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := asm.Partial(); n != 2 {
+		t.Fatalf("%d partial frames, want 2", n)
+	}
+	if _, ok := asm.frames["Probe1/2"]; ok {
+		t.Error("frame 2 was least recently updated but was kept")
+	}
+}
+
+func TestProgressive_EvictsToPixelLimit(t *testing.T) {
+	asm := &ProgressiveAssembler{MaxPartialPixels: 150, frames: make(map[string]*ProgressiveFrame)}
+	first := func(id uint16) []byte {
+		frags, _ := EncodeProgressive(testPattern(8, 8), id, 16)
+		return frags[0]
+	}
+	for _, id := range []uint16{1, 2, 3} {
+		if _, err := asm.Add("Probe1", first(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := asm.Partial(); n != 2 {
+		t.Fatalf("%d partial frames, want 2", n)
+	}
+	if _, ok := asm.frames["Probe1/1"]; ok {
+		t.Error("frame 1 was kept past the pixel limit")
+	}
+	if asm.pixels != 128 {
+		t.Errorf("assembler accounts for %d pixels, want 128", asm.pixels)
+	}
+
+	if _, err := asm.Add("Probe1", EncodeProgressiveAbort(2)); err != nil {
+		t.Fatal(err)
+	}
+	if asm.pixels != 64 {
+		t.Errorf("after abort, assembler accounts for %d pixels, want 64", asm.pixels)
+	}
+}
+
+func TestProgressive_RejectsBadSizes(t *testing.T) {
+	frags, _ := EncodeProgressive(testPattern(8, 8), 1, 16)
+	asm := NewProgressiveAssembler()
+	if _, err := asm.Add("Probe1", frags[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	resized := bytes.Clone(frags[1])
+	binary.LittleEndian.PutUint16(resized[7:], 16)
+	if _, err := asm.Add("Probe1", resized); err == nil {
+		t.Error("fragment with a different frame size was accepted")
+	}
+
+	huge := bytes.Clone(frags[0])
+	binary.LittleEndian.PutUint16(huge[7:], 0xFFFF)
+	binary.LittleEndian.PutUint16(huge[9:], 0xFFFF)
+	if _, err := asm.Add("Probe2", huge); err == nil {
+		t.Error("fragment declaring a huge frame was accepted")
+	}
+
+	wide := bytes.Clone(frags[0])
+	binary.LittleEndian.PutUint16(wide[7:], 2049)
+	binary.LittleEndian.PutUint16(wide[9:], 2048)
+	if _, err := asm.Add("Probe2", wide); err == nil {
+		t.Error("fragment declaring a frame larger than any camera's was accepted")
+	}
+}
+
+func TestProgressive_CaptureInfo(t *testing.T) {
+	info := CaptureInfo{Tick: 7, Sector: [3]int64{1, 2, 3}, Pointing: [3]float64{0, 0, -1}, FOV: 15, Render: 1100, Profile: 1}
+	frags, _ := EncodeProgressive(testPattern(8, 8), 2, 64)
+	frags = append([][]byte{EncodeProgressiveInfo(2, 8, 8, info)}, frags...)
+
+	asm := NewProgressiveAssembler()
+	var f *ProgressiveFrame
+	for _, frag := range frags {
+		var err error
+		if f, err = asm.Add("Probe1", frag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !f.Complete() {
+		t.Fatal("frame did not complete")
+	}
+	if f.Capture == nil || *f.Capture != info {
+		t.Errorf("capture info = %+v, want %+v", f.Capture, info)
+	}
+}
@@ -0,0 +1,55 @@
+package comms
+
+// MaxHops bounds how many relays a single message may pass through, so a
+// misconfigured routing table cannot loop a message forever.
+const MaxHops = 32
+
+// Link describes the cost of forwarding a message through a relay.
+type Link struct {
+	// Delay is the number of ticks a message waits at the relay before it is
+	// forwarded to the next hop.
+	Delay int
+	// Bandwidth is the number of payload bytes the relay forwards per tick.
+	// Larger messages take several ticks to get through. Zero means unlimited.
+	Bandwidth int
+}
+
+// AddRelay registers id as a relay node, such as a deep-space relay or another
+// probe, that forwards messages with the given link characteristics. A relay
+// may also be a normal subscriber; messages addressed to it are delivered.
+func (b *MessageBus) AddRelay(id string, link Link) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.relays[id] = link
+}
+
+// AddRoute adds an entry to from's routing table: messages for targetID held
+// by from are forwarded to via. Without an entry, nodes send directly to the
+// target.
+func (b *MessageBus) AddRoute(from, targetID, via string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	table, ok := b.routes[from]
+	if !ok {
+		table = make(map[string]string)
+		b.routes[from] = table
+	}
+	table[targetID] = via
+}
+
+// SetRouteTracing controls whether delivered messages carry the list of
+// relays they travelled through in Message.Route.
+func (b *MessageBus) SetRouteTracing(enabled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trace = enabled
+}
+
+// nextHop looks up where node should send a message for targetID.
+// Callers must hold b.mu.
+func (b *MessageBus) nextHop(node, targetID string) string {
+	if via, ok := b.routes[node][targetID]; ok {
+		return via
+	}
+	return targetID
+}
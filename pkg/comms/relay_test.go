@@ -0,0 +1,81 @@
+package comms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMessageBus_MultiHopRoute(t *testing.T) {
+	bus := NewMessageBus()
+	bus.SetRouteTracing(true)
+	bus.AddRelay("DSN", Link{Delay: 1})
+	bus.AddRelay("Relay-2", Link{Delay: 2})
+	bus.AddRoute("Earth", "FarProbe", "DSN")
+	bus.AddRoute("DSN", "FarProbe", "Relay-2")
+
+	var got *Message
+	bus.Subscribe("FarProbe", func(msg Message) {
+		got = &msg
+	})
+
+	bus.Send("Earth", "FarProbe", []byte("hello"))
+
+	// Tick 1 reaches DSN, +1 tick to leave DSN, +2 ticks to leave Relay-2.
+	ticks := 0
+	for got == nil && ticks < 10 {
+		bus.Tick()
+		ticks++
+	}
+	if got == nil {
+		t.Fatal("FarProbe never received the message")
+	}
+	if ticks != 4 {
+		t.Errorf("delivered after %d ticks, want 4", ticks)
+	}
+	if want := []string{"DSN", "Relay-2"}; !reflect.DeepEqual(got.Route, want) {
+		t.Errorf("route = %v, want %v", got.Route, want)
+	}
+	if string(got.Payload) != "hello" {
+		t.Errorf("payload = %q, want %q", got.Payload, "hello")
+	}
+}
+
+func TestMessageBus_RelayBandwidth(t *testing.T) {
+	bus := NewMessageBus()
+	bus.AddRelay("DSN", Link{Bandwidth: 4})
+	bus.AddRoute("Earth", "Probe1", "DSN")
+
+	delivered := 0
+	bus.Subscribe("Probe1", func(msg Message) {
+		delivered++
+	})
+
+	// 10 bytes at 4 bytes/tick takes three ticks to leave the relay.
+	bus.Send("Earth", "Probe1", make([]byte, 10))
+
+	ticks := 0
+	for delivered == 0 && ticks < 10 {
+		bus.Tick()
+		ticks++
+	}
+	if ticks != 3 {
+		t.Errorf("delivered after %d ticks, want 3", ticks)
+	}
+}
+
+func TestMessageBus_RoutingLoopDropped(t *testing.T) {
+	bus := NewMessageBus()
+	bus.AddRelay("A", Link{})
+	bus.AddRelay("B", Link{})
+	bus.AddRoute("Earth", "Probe1", "A")
+	bus.AddRoute("A", "Probe1", "B")
+	bus.AddRoute("B", "Probe1", "A")
+
+	bus.Subscribe("Probe1", func(msg Message) {
+		t.Error("looping message should not be delivered")
+	})
+
+	bus.Send("Earth", "Probe1", []byte{1})
+	bus.Tick()
+	bus.Tick()
+}
@@ -0,0 +1,698 @@
+package comms
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Wire protocol
+//
+// Every frame is [Length: uint32 BE][Kind: uint8][Body], where Length counts
+// the kind byte and the body. Strings inside a body are [Len: uint8][Bytes],
+// so IDs longer than MaxIDLen cannot cross the link and are rejected.
+//
+//	frameSubscribe    body: [ID]                            client -> server
+//	frameUnsubscribe  body: [ID]                            client -> server
+//	frameMessage      body: [Sender][Target][Payload...]    client -> server
+//	frameDeliver      body: [ID][Sender][Target][Payload...] server -> client
+//	frameQuery        body: [Query]                         client -> server
+//	frameAck          body: reply, empty except for queries server -> client
+//	frameError        body: error text, in place of an ack  server -> client
+//
+// frameDeliver carries the subscribed ID the message matched, so a client
+// with several subscriptions can route it without re-matching broadcasts.
+// Acks and errors answer requests in the order the requests were sent.
+const (
+	frameSubscribe   byte = 1
+	frameUnsubscribe byte = 2
+	frameMessage     byte = 3
+	frameDeliver     byte = 4
+	frameAck         byte = 5
+	frameQuery       byte = 6
+	frameError       byte = 7
+)
+
+// Queries a client may make with frameQuery. They are answered when the
+// server's bus implements Inspector.
+const (
+	querySubscribers = "subscribers"
+	queryPending     = "pending"
+)
+
+// Inspector is implemented by buses that can report their state to remote
+// tooling.
+type Inspector interface {
+	// Subscribers returns the distinct IDs and patterns with a subscription.
+	Subscribers() []string
+	// Pending returns copies of the messages still queued on the bus.
+	Pending() []Message
+}
+
+// MaxFrameSize bounds a single frame so a corrupt length cannot make a peer
+// allocate unbounded memory.
+const MaxFrameSize = 16 << 20
+
+// outboxSize is how many frames may queue for a slow connection before
+// further messages to it are dropped.
+const outboxSize = 256
+
+var errFrameTooLarge = errors.New("comms: frame too large")
+
+// MaxIDLen is the longest bus ID a Client can subscribe to or send with.
+const MaxIDLen = 255
+
+// ErrIDTooLong reports a bus ID longer than MaxIDLen.
+var ErrIDTooLong = errors.New("comms: ID longer than 255 bytes")
+
+func makeFrame(kind byte, body []byte) ([]byte, error) {
+	if len(body)+1 > MaxFrameSize {
+		return nil, errFrameTooLarge
+	}
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)+1))
+	frame[4] = kind
+	return append(frame, body...), nil
+}
+
+func writeFrame(w io.Writer, kind byte, body []byte) error {
+	frame, err := makeFrame(kind, body)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n == 0 || n > MaxFrameSize {
+		return 0, nil, errFrameTooLarge
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, nil, err
+	}
+	return buf[0], buf[1:], nil
+}
+
+func appendString(b []byte, s string) ([]byte, error) {
+	if len(s) > MaxIDLen {
+		return nil, ErrIDTooLong
+	}
+	b = append(b, byte(len(s)))
+	return append(b, s...), nil
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return "", nil, fmt.Errorf("comms: truncated string in frame")
+	}
+	n := int(b[0])
+	return string(b[1 : 1+n]), b[1+n:], nil
+}
+
+func encodeMessage(msg Message) ([]byte, error) {
+	body, err := appendString(nil, msg.SenderID)
+	if err != nil {
+		return nil, err
+	}
+	if body, err = appendString(body, msg.TargetID); err != nil {
+		return nil, err
+	}
+	return append(body, msg.Payload...), nil
+}
+
+func decodeMessage(body []byte) (Message, error) {
+	sender, rest, err := readString(body)
+	if err != nil {
+		return Message{}, err
+	}
+	target, rest, err := readString(rest)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{SenderID: sender, TargetID: target, Payload: append([]byte(nil), rest...)}, nil
+}
+
+// ParseAddress splits a "network:address" string such as "tcp:localhost:7000"
+// or "unix:/tmp/unknowngalaxy.sock" into its parts for net.Listen and Dial.
+func ParseAddress(spec string) (network, address string, err error) {
+	network, address, ok := strings.Cut(spec, ":")
+	if !ok || address == "" {
+		return "", "", fmt.Errorf("comms: address %q is not network:address", spec)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return network, address, nil
+	}
+	return "", "", fmt.Errorf("comms: unsupported network %q", network)
+}
+
+// Server bridges a Bus to remote clients over any stream listener, such as
+// TCP or a Unix socket. Clients subscribe to IDs on the bus and send messages
+// into it; several clients may subscribe to the same ID.
+type Server struct {
+	bus Bus
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[*serverConn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+type serverConn struct {
+	conn   net.Conn
+	outbox chan []byte
+	done   chan struct{}
+	subs   map[string]*Subscription
+}
+
+func NewServer(bus Bus) *Server {
+	return &Server{bus: bus, conns: make(map[*serverConn]struct{})}
+}
+
+// Serve accepts connections on l until l fails or the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		sc := &serverConn{
+			conn:   conn,
+			outbox: make(chan []byte, outboxSize),
+			done:   make(chan struct{}),
+			subs:   make(map[string]*Subscription),
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[sc] = struct{}{}
+		s.wg.Add(2)
+		s.mu.Unlock()
+		go s.writeLoop(sc)
+		go s.readLoop(sc)
+	}
+}
+
+// Close stops all listeners, disconnects every client and waits for the
+// connection goroutines to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for sc := range s.conns {
+		sc.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) writeLoop(sc *serverConn) {
+	defer s.wg.Done()
+	w := bufio.NewWriter(sc.conn)
+	for {
+		select {
+		case <-sc.done:
+			return
+		case frame := <-sc.outbox:
+			_, err := w.Write(frame)
+			if err == nil && len(sc.outbox) == 0 {
+				err = w.Flush()
+			}
+			if err != nil {
+				sc.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) readLoop(sc *serverConn) {
+	defer s.wg.Done()
+	defer func() {
+		for _, sub := range sc.subs {
+			sub.Unsubscribe()
+		}
+		s.mu.Lock()
+		delete(s.conns, sc)
+		s.mu.Unlock()
+		sc.conn.Close()
+		close(sc.done)
+	}()
+
+	r := bufio.NewReader(sc.conn)
+	for {
+		kind, body, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		switch kind {
+		case frameSubscribe:
+			id := string(body)
+			prefix, err := appendString(nil, id)
+			if err != nil {
+				sc.enqueue(frameError, []byte(err.Error()), true)
+				continue
+			}
+			if _, ok := sc.subs[id]; !ok {
+				sc.subs[id] = s.bus.Subscribe(id, func(msg Message) {
+					m, err := encodeMessage(msg)
+					if err != nil {
+						fmt.Printf("comms: dropping message for %s: %v\n", sc.conn.RemoteAddr(), err)
+						return
+					}
+					sc.enqueue(frameDeliver, append(prefix[:len(prefix):len(prefix)], m...), false)
+				})
+			}
+			sc.enqueue(frameAck, nil, true)
+		case frameUnsubscribe:
+			id := string(body)
+			if sub, ok := sc.subs[id]; ok {
+				sub.Unsubscribe()
+				delete(sc.subs, id)
+			}
+			sc.enqueue(frameAck, nil, true)
+		case frameMessage:
+			msg, err := decodeMessage(body)
+			if err != nil {
+				fmt.Printf("comms: bad frame from %s: %v\n", sc.conn.RemoteAddr(), err)
+				return
+			}
+			s.bus.Send(msg.SenderID, msg.TargetID, msg.Payload)
+		case frameQuery:
+			reply, err := s.answer(string(body))
+			if err != nil {
+				sc.enqueue(frameError, []byte(err.Error()), true)
+				continue
+			}
+			sc.enqueue(frameAck, reply, true)
+		default:
+			fmt.Printf("comms: unknown frame kind %d from %s\n", kind, sc.conn.RemoteAddr())
+			return
+		}
+	}
+}
+
+// answer encodes the reply to a frameQuery. Unknown queries, or a bus that is
+// not an Inspector, get an empty reply. A bus ID too long to encode fails the
+// query.
+func (s *Server) answer(query string) ([]byte, error) {
+	inspector, ok := s.bus.(Inspector)
+	if !ok {
+		return nil, nil
+	}
+	var reply []byte
+	var err error
+	switch query {
+	case querySubscribers:
+		for _, id := range inspector.Subscribers() {
+			if reply, err = appendString(reply, id); err != nil {
+				return nil, err
+			}
+		}
+	case queryPending:
+		for _, msg := range inspector.Pending() {
+			m, err := encodeMessage(msg)
+			if err != nil {
+				return nil, err
+			}
+			reply = binary.BigEndian.AppendUint32(reply, uint32(len(m)))
+			reply = append(reply, m...)
+		}
+	}
+	return reply, nil
+}
+
+// enqueue queues a frame for the connection. Unless wait is set it never
+// blocks the bus: frames for a client that cannot keep up are dropped.
+func (sc *serverConn) enqueue(kind byte, body []byte, wait bool) {
+	frame, err := makeFrame(kind, body)
+	if err != nil && kind == frameAck {
+		// The client is waiting for the ack, so it must get an answer.
+		frame, err = makeFrame(frameError, []byte(err.Error()))
+	}
+	if err != nil {
+		fmt.Printf("comms: dropping frame for %s: %v\n", sc.conn.RemoteAddr(), err)
+		return
+	}
+	if wait {
+		select {
+		case <-sc.done:
+		case sc.outbox <- frame:
+		}
+		return
+	}
+	select {
+	case <-sc.done:
+	case sc.outbox <- frame:
+	default:
+		fmt.Printf("comms: client %s too slow, dropping frame\n", sc.conn.RemoteAddr())
+	}
+}
+
+// Client is a remote participant on a Server's bus. It implements Bus, so a
+// ground station in another process can use it in place of a local bus.
+//
+// Receivers run on a goroutine of their own, one message at a time in the
+// order they arrive, so they may call the Client, even to wait for a query.
+// Errors the Bus methods cannot return, such as an ID longer than MaxIDLen
+// or a subscription the server refused, go to the error handler.
+type Client struct {
+	conn net.Conn
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	mu      sync.Mutex
+	subs    []*Subscription
+	acks    []chan ack
+	err     error
+	onError func(error)
+	inbox   []delivery // received, not yet handed to receivers
+	wake    chan struct{}
+	closed  chan struct{}
+}
+
+// delivery is a received message and the receivers it is for.
+type delivery struct {
+	msg       Message
+	receivers []ReceiverFunc
+}
+
+// ack is the server's answer to a request.
+type ack struct {
+	reply []byte
+	err   error
+}
+
+// Dial connects to a Server, e.g. Dial("tcp", "localhost:7000") or
+// Dial("unix", "/tmp/unknowngalaxy.sock").
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:   conn,
+		w:      bufio.NewWriter(conn),
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	go c.readLoop()
+	go c.dispatch()
+	return c, nil
+}
+
+// SetErrorHandler sets the function that is given the errors Subscribe,
+// Unsubscribe and Send cannot return. By default they are logged.
+func (c *Client) SetErrorHandler(handler func(error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onError = handler
+}
+
+// report passes err to the error handler.
+func (c *Client) report(err error) {
+	c.mu.Lock()
+	handler := c.onError
+	c.mu.Unlock()
+	if handler == nil {
+		fmt.Printf("comms: %v\n", err)
+		return
+	}
+	handler(err)
+}
+
+// Subscribe registers a local receiver and asks the server to forward
+// messages for id. It returns once the server has registered the ID. If the
+// ID is too long or the server refuses it, the error is reported and the
+// subscription receives nothing.
+func (c *Client) Subscribe(id string, receiver ReceiverFunc) *Subscription {
+	sub := newSubscription(id, receiver)
+	sub.cancel = func() {
+		if c.forget(sub) == 0 {
+			if _, err := c.request(frameUnsubscribe, []byte(id)); err != nil {
+				c.report(fmt.Errorf("Client.Unsubscribe: %w", err))
+			}
+		}
+	}
+	if len(id) > MaxIDLen {
+		c.report(fmt.Errorf("Client.Subscribe: %w", ErrIDTooLong))
+		return sub
+	}
+
+	c.mu.Lock()
+	c.subs = append(c.subs, sub)
+	c.mu.Unlock()
+	if _, err := c.request(frameSubscribe, []byte(id)); err != nil {
+		c.forget(sub)
+		c.report(fmt.Errorf("Client.Subscribe: %s: %w", id, err))
+	}
+	return sub
+}
+
+// forget removes sub and returns how many subscriptions to its ID remain.
+func (c *Client) forget(sub *Subscription) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	remaining := 0
+	for i := 0; i < len(c.subs); i++ {
+		if c.subs[i] == sub {
+			c.subs = append(c.subs[:i:i], c.subs[i+1:]...)
+			i--
+		} else if c.subs[i].id == sub.id {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+// Send forwards a message to the server's bus. A message with an ID too long
+// to encode is reported and not sent.
+func (c *Client) Send(senderID string, targetID string, payload []byte) {
+	body, err := encodeMessage(Message{SenderID: senderID, TargetID: targetID, Payload: payload})
+	if err != nil {
+		c.report(fmt.Errorf("Client.Send: %w", err))
+		return
+	}
+	c.write(frameMessage, body)
+}
+
+// Err returns the first error the connection failed with, if any.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Done is closed when the connection to the server ends.
+func (c *Client) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Close disconnects from the server.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	<-c.closed
+	return err
+}
+
+// Subscribers asks the server which IDs have subscriptions on its bus.
+func (c *Client) Subscribers() ([]string, error) {
+	reply, err := c.request(frameQuery, []byte(querySubscribers))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for len(reply) > 0 {
+		var id string
+		if id, reply, err = readString(reply); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Pending asks the server for the messages still queued on its bus.
+func (c *Client) Pending() ([]Message, error) {
+	reply, err := c.request(frameQuery, []byte(queryPending))
+	if err != nil {
+		return nil, err
+	}
+	var msgs []Message
+	for len(reply) > 0 {
+		if len(reply) < 4 || len(reply) < 4+int(binary.BigEndian.Uint32(reply)) {
+			return nil, fmt.Errorf("comms: truncated pending reply")
+		}
+		n := int(binary.BigEndian.Uint32(reply))
+		msg, err := decodeMessage(reply[4 : 4+n])
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+		reply = reply[4+n:]
+	}
+	return msgs, nil
+}
+
+// request sends a frame the server acknowledges and waits for the reply.
+// Acks arrive in the order requests were written, so the ack is queued and
+// the frame written under the same lock.
+func (c *Client) request(kind byte, body []byte) ([]byte, error) {
+	done := make(chan ack, 1)
+	c.wmu.Lock()
+	c.mu.Lock()
+	c.acks = append(c.acks, done)
+	c.mu.Unlock()
+	c.writeLocked(kind, body)
+	c.wmu.Unlock()
+	select {
+	case a := <-done:
+		return a.reply, a.err
+	case <-c.closed:
+		if err := c.Err(); err != nil {
+			return nil, err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+func (c *Client) write(kind byte, body []byte) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.writeLocked(kind, body)
+}
+
+// writeLocked writes a frame; c.wmu must be held.
+func (c *Client) writeLocked(kind byte, body []byte) {
+	err := writeFrame(c.w, kind, body)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if err != nil {
+		c.fail(err)
+		c.conn.Close()
+	}
+}
+
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *Client) readLoop() {
+	defer close(c.closed)
+	r := bufio.NewReader(c.conn)
+	for {
+		kind, body, err := readFrame(r)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				c.fail(err)
+			}
+			return
+		}
+		switch kind {
+		case frameAck, frameError:
+			a := ack{reply: body}
+			if kind == frameError {
+				a = ack{err: fmt.Errorf("comms: server: %s", body)}
+			}
+			c.mu.Lock()
+			if len(c.acks) > 0 {
+				c.acks[0] <- a
+				c.acks = c.acks[1:]
+			}
+			c.mu.Unlock()
+		case frameDeliver:
+			id, rest, err := readString(body)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			msg, err := decodeMessage(rest)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			c.mu.Lock()
+			d := delivery{msg: msg}
+			for _, sub := range c.subs {
+				if sub.id == id {
+					d.receivers = append(d.receivers, sub.receiver)
+				}
+			}
+			c.inbox = append(c.inbox, d)
+			c.mu.Unlock()
+			select {
+			case c.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// dispatch hands received messages to their receivers, off the read loop so
+// that a receiver waiting for an ack does not stop the ack being read. It
+// returns once the connection has ended and the inbox is empty.
+func (c *Client) dispatch() {
+	for {
+		c.mu.Lock()
+		inbox := c.inbox
+		c.inbox = nil
+		c.mu.Unlock()
+		for _, d := range inbox {
+			for _, receiver := range d.receivers {
+				receiver(d.msg)
+			}
+		}
+		if len(inbox) > 0 {
+			continue
+		}
+		select {
+		case <-c.wake:
+		case <-c.closed:
+			c.mu.Lock()
+			done := len(c.inbox) == 0
+			c.mu.Unlock()
+			if done {
+				return
+			}
+		}
+	}
+}
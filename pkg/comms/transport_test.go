@@ -0,0 +1,314 @@
+package comms
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer serves bus on a fresh localhost listener and returns its address.
+func startServer(t *testing.T, bus Bus, network, address string) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := NewServer(bus)
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return srv, l.Addr().String()
+}
+
+// tickUntil ticks bus until done reports true or a deadline passes.
+func tickUntil(t *testing.T, bus *MessageBus, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for delivery")
+		}
+		bus.Tick()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTransport_TCPRoundTrip(t *testing.T) {
+	bus := NewMessageBus()
+	_, addr := startServer(t, bus, "tcp", "127.0.0.1:0")
+
+	probeGot := make(chan Message, 1)
+	bus.Subscribe("Probe1", func(msg Message) { probeGot <- msg })
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	earthGot := make(chan Message, 1)
+	client.Subscribe("Earth", func(msg Message) { earthGot <- msg })
+
+	// Downlink: simulation -> remote ground station.
+	bus.Send("Probe1", "Earth", []byte("telemetry"))
+	bus.Tick()
+	select {
+	case msg := <-earthGot:
+		if msg.SenderID != "Probe1" || string(msg.Payload) != "telemetry" {
+			t.Errorf("ground station got %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ground station never received downlink")
+	}
+
+	// Uplink: remote ground station -> simulation.
+	client.Send("Earth", "Probe1", []byte("TAKE_PICTURE"))
+	var uplink Message
+	tickUntil(t, bus, func() bool {
+		select {
+		case uplink = <-probeGot:
+			return true
+		default:
+			return false
+		}
+	})
+	if uplink.SenderID != "Earth" || string(uplink.Payload) != "TAKE_PICTURE" {
+		t.Errorf("probe got %+v", uplink)
+	}
+}
+
+func TestTransport_SeveralOperatorsShareID(t *testing.T) {
+	bus := NewMessageBus()
+	sock := filepath.Join(t.TempDir(), "bus.sock")
+	_, addr := startServer(t, bus, "unix", sock)
+
+	var got []chan Message
+	for i := 0; i < 2; i++ {
+		client, err := Dial("unix", addr)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer client.Close()
+		ch := make(chan Message, 1)
+		client.Subscribe("Earth", func(msg Message) { ch <- msg })
+		got = append(got, ch)
+	}
+
+	bus.Send("Probe1", "Earth", []byte{42})
+	bus.Tick()
+
+	for i, ch := range got {
+		select {
+		case msg := <-ch:
+			if len(msg.Payload) != 1 || msg.Payload[0] != 42 {
+				t.Errorf("operator %d got payload %v", i, msg.Payload)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("operator %d never received the message", i)
+		}
+	}
+}
+
+func TestTransport_UnsubscribeStopsForwarding(t *testing.T) {
+	bus := NewMessageBus()
+	_, addr := startServer(t, bus, "tcp", "127.0.0.1:0")
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	ch := make(chan Message, 4)
+	sub := client.Subscribe("Earth", func(msg Message) { ch <- msg })
+	sub.Unsubscribe()
+
+	// The local handler proves the bus ran the delivery.
+	local := make(chan struct{}, 1)
+	bus.Subscribe("Earth", func(msg Message) { local <- struct{}{} })
+	bus.Send("Probe1", "Earth", []byte{1})
+	bus.Tick()
+	<-local
+
+	select {
+	case msg := <-ch:
+		t.Errorf("received %+v after Unsubscribe", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestTransport_Queries(t *testing.T) {
+	bus := NewMessageBus()
+	_, addr := startServer(t, bus, "tcp", "127.0.0.1:0")
+	bus.Subscribe("Probe1", func(msg Message) {})
+	bus.Subscribe("Probe2", func(msg Message) {})
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	client.Subscribe("Earth", func(msg Message) {})
+
+	ids, err := client.Subscribers()
+	if err != nil {
+		t.Fatalf("Subscribers: %v", err)
+	}
+	if len(ids) != 3 || ids[0] != "Probe1" || ids[1] != "Probe2" || ids[2] != "Earth" {
+		t.Errorf("Subscribers = %v, want [Probe1 Probe2 Earth]", ids)
+	}
+
+	bus.Send("Earth", "Probe2", []byte("queued"))
+	pending, err := client.Pending()
+	if err != nil {
+		t.Fatalf("Pending: %v", err)
+	}
+	if len(pending) != 1 || pending[0].TargetID != "Probe2" || string(pending[0].Payload) != "queued" {
+		t.Errorf("Pending = %+v, want one queued message for Probe2", pending)
+	}
+}
+
+func TestTransport_ConcurrentQueriesGetTheirOwnReplies(t *testing.T) {
+	bus := NewMessageBus()
+	_, addr := startServer(t, bus, "tcp", "127.0.0.1:0")
+	bus.Subscribe("Probe1", func(msg Message) {})
+	bus.Send("Earth", "Probe1", []byte("queued"))
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				ids, err := client.Subscribers()
+				if err != nil || len(ids) != 1 || ids[0] != "Probe1" {
+					errs <- fmt.Errorf("Subscribers = %v, %v", ids, err)
+				}
+				return
+			}
+			pending, err := client.Pending()
+			if err != nil || len(pending) != 1 || pending[0].TargetID != "Probe1" {
+				errs <- fmt.Errorf("Pending = %+v, %v", pending, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestTransport_OversizedReplyIsAnError(t *testing.T) {
+	bus := NewMessageBus()
+	_, addr := startServer(t, bus, "tcp", "127.0.0.1:0")
+	bus.Send("Earth", "Probe1", make([]byte, MaxFrameSize))
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Pending()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected an error for a reply larger than MaxFrameSize")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Pending did not return")
+	}
+
+	// The connection is still usable.
+	if _, err := client.Subscribers(); err != nil {
+		t.Errorf("Subscribers after the error: %v", err)
+	}
+}
+
+func TestTransport_LongIDsAreRejected(t *testing.T) {
+	bus := NewMessageBus()
+	_, addr := startServer(t, bus, "tcp", "127.0.0.1:0")
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	var mu sync.Mutex
+	var errs []error
+	client.SetErrorHandler(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	})
+
+	long := strings.Repeat("x", MaxIDLen+1)
+	client.Subscribe(long, func(Message) {})
+	client.Send("Earth", long, []byte("lost"))
+	mu.Lock()
+	if len(errs) != 2 || !errors.Is(errs[0], ErrIDTooLong) || !errors.Is(errs[1], ErrIDTooLong) {
+		t.Errorf("errors = %v, want two ErrIDTooLong", errs)
+	}
+	mu.Unlock()
+	if ids, err := client.Subscribers(); err != nil || len(ids) != 0 {
+		t.Errorf("Subscribers = %v, %v; want none", ids, err)
+	}
+
+	// A local subscriber the link cannot name fails the query instead of
+	// being truncated.
+	bus.Subscribe(long, func(Message) {})
+	if _, err := client.Subscribers(); err == nil {
+		t.Error("expected an error listing an ID longer than MaxIDLen")
+	}
+}
+
+func TestTransport_ReceiverMayCallClient(t *testing.T) {
+	bus := NewMessageBus()
+	_, addr := startServer(t, bus, "tcp", "127.0.0.1:0")
+	acked := make(chan Message, 1)
+	bus.Subscribe("Probe1", func(msg Message) { acked <- msg })
+
+	client, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	client.Subscribe("Earth", func(msg Message) {
+		// Waiting for a query reply inside a receiver must not deadlock.
+		if _, err := client.Subscribers(); err != nil {
+			t.Errorf("Subscribers in a receiver: %v", err)
+		}
+		client.Send("Earth", msg.SenderID, []byte("ack"))
+	})
+
+	bus.Send("Probe1", "Earth", []byte("telemetry"))
+	deadline := time.After(2 * time.Second)
+	for {
+		bus.Tick()
+		select {
+		case msg := <-acked:
+			if string(msg.Payload) != "ack" {
+				t.Errorf("Probe1 got %q, want ack", msg.Payload)
+			}
+			return
+		case <-deadline:
+			t.Fatal("receiver calling the client deadlocked")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
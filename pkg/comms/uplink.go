@@ -0,0 +1,91 @@
+package comms
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// UplinkKind says what a flight software uplink carries.
+type UplinkKind uint8
+
+const (
+	UplinkSource      UplinkKind = 1 // C source, compiled on board
+	UplinkMachineCode UplinkKind = 2 // precompiled machine code
+)
+
+func (k UplinkKind) String() string {
+	switch k {
+	case UplinkSource:
+		return "source"
+	case UplinkMachineCode:
+		return "machine code"
+	}
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+// Uplink layout, all integers little-endian:
+//
+//	[Magic "UGUP"][Kind: uint8][CRC32: uint32][Length: uint32][Data...]
+//
+// CRC32 is the IEEE checksum of Data. The probe recomputes it over the image
+// it staged, so corruption anywhere between Earth and probe storage is caught
+// before the probe reboots into the new software.
+const (
+	uplinkMagic     = "UGUP"
+	uplinkHeaderLen = 4 + 1 + 4 + 4
+)
+
+// UplinkHeader describes a flight software uplink.
+type UplinkHeader struct {
+	Kind   UplinkKind
+	CRC32  uint32
+	Length int
+}
+
+// IsUplink reports whether data starts with an uplink header.
+func IsUplink(data []byte) bool {
+	return len(data) >= uplinkHeaderLen && string(data[:4]) == uplinkMagic
+}
+
+// EncodeUplink packs flight software for transmission to a probe.
+func EncodeUplink(kind UplinkKind, data []byte) []byte {
+	out := make([]byte, 0, uplinkHeaderLen+len(data))
+	out = append(out, uplinkMagic...)
+	out = append(out, byte(kind))
+	out = binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(data))
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+	return append(out, data...)
+}
+
+// DecodeUplink parses an uplink header and returns it with the payload that
+// follows. The checksum is not verified; see UplinkHeader.Verify.
+func DecodeUplink(data []byte) (UplinkHeader, []byte, error) {
+	if !IsUplink(data) {
+		return UplinkHeader{}, nil, fmt.Errorf("DecodeUplink: not an uplink")
+	}
+	hdr := UplinkHeader{
+		Kind:   UplinkKind(data[4]),
+		CRC32:  binary.LittleEndian.Uint32(data[5:]),
+		Length: int(binary.LittleEndian.Uint32(data[9:])),
+	}
+	if hdr.Kind != UplinkSource && hdr.Kind != UplinkMachineCode {
+		return hdr, nil, fmt.Errorf("DecodeUplink: unknown kind %d", hdr.Kind)
+	}
+	body := data[uplinkHeaderLen:]
+	if len(body) != hdr.Length {
+		return hdr, nil, fmt.Errorf("DecodeUplink: payload is %d bytes, header says %d", len(body), hdr.Length)
+	}
+	return hdr, body, nil
+}
+
+// Verify checks data against the header's length and checksum.
+func (h UplinkHeader) Verify(data []byte) error {
+	if len(data) != h.Length {
+		return fmt.Errorf("UplinkHeader.Verify: %d bytes, want %d", len(data), h.Length)
+	}
+	if sum := crc32.ChecksumIEEE(data); sum != h.CRC32 {
+		return fmt.Errorf("UplinkHeader.Verify: checksum %08x, want %08x", sum, h.CRC32)
+	}
+	return nil
+}
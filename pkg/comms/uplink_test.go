@@ -0,0 +1,48 @@
+package comms
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUplink_RoundTrip(t *testing.T) {
+	src := []byte("int main() { return 0; }")
+	packet := EncodeUplink(UplinkSource, src)
+	if !IsUplink(packet) {
+		t.Fatal("IsUplink = false for an encoded uplink")
+	}
+	hdr, body, err := DecodeUplink(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Kind != UplinkSource || !bytes.Equal(body, src) {
+		t.Fatalf("decoded %v %q", hdr.Kind, body)
+	}
+	if err := hdr.Verify(body); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := bytes.Clone(body)
+	corrupt[3] ^= 0x40
+	if err := hdr.Verify(corrupt); err == nil {
+		t.Error("expected a checksum error for a corrupted image")
+	}
+	if err := hdr.Verify(body[:10]); err == nil {
+		t.Error("expected a length error for a truncated image")
+	}
+}
+
+func TestDecodeUplink_RejectsBadPackets(t *testing.T) {
+	packet := EncodeUplink(UplinkMachineCode, []byte{1, 2, 3, 4})
+	if _, _, err := DecodeUplink(packet[:len(packet)-1]); err == nil {
+		t.Error("expected an error for a truncated packet")
+	}
+	bad := bytes.Clone(packet)
+	bad[4] = 9
+	if _, _, err := DecodeUplink(bad); err == nil {
+		t.Error("expected an error for an unknown kind")
+	}
+	if _, _, err := DecodeUplink([]byte("TAKE_PICTURE")); err == nil {
+		t.Error("expected an error for a plain command")
+	}
+}
@@ -0,0 +1,413 @@
+package ground
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+// Archive layout:
+//
+//	<root>/index.tsv
+//	<root>/<probe>/<simtime>_<n>.png
+//
+// The index is a plain tab-separated file with one capture per line, so it
+// can be appended to safely and read with cut, awk or a spreadsheet. String
+// fields are Go-quoted so IDs containing tabs cannot break a line.
+const (
+	indexFile   = "index.tsv"
+	indexHeader = "# unknowngalaxy capture index v1"
+	indexFields = 23
+)
+
+// Capture describes one archived image.
+type Capture struct {
+	ID            string // unique within the archive
+	ProbeID       string
+	SimTime       uint64 // simulation tick the frame was captured on
+	Position      universe.GalacticPosition
+	Pointing      si3d.Vector3 // camera look-at target, local coordinates
+	Format        string
+	Width, Height int
+	// FOV is the horizontal field of view in degrees and Render the size of
+	// the square frame the image was cropped from, zero if not known; see
+	// comms.CaptureInfo. Profile names the camera profile.
+	FOV     float64
+	Render  int
+	Profile string
+	Path    string // PNG file, relative to the archive root
+	RunID   string // simulation run that received the frame, if known
+}
+
+// Place sets the capture's tick, position, pointing and camera from the info
+// its frame was sent with.
+func (c *Capture) Place(info comms.CaptureInfo) {
+	c.SimTime = info.Tick
+	c.Position = universe.GalacticPosition{
+		SectorX: info.Sector[0], SectorY: info.Sector[1], SectorZ: info.Sector[2],
+		SystemX: info.System[0], SystemY: info.System[1], SystemZ: info.System[2],
+		LocalX: info.Local[0], LocalY: info.Local[1], LocalZ: info.Local[2],
+	}
+	c.Pointing = si3d.NewVector3(info.Pointing[0], info.Pointing[1], info.Pointing[2])
+	c.FOV, c.Render = info.FOV, int(info.Render)
+	c.Profile = ""
+	if p, err := universe.CameraProfileAt(int(info.Profile)); err == nil {
+		c.Profile = p.Name
+	}
+}
+
+// Region is an axis-aligned box of galactic space, inclusive at both ends.
+// Positions are expected to be normalized.
+type Region struct {
+	Min, Max universe.GalacticPosition
+}
+
+// Contains reports whether p lies inside r.
+func (r Region) Contains(p universe.GalacticPosition) bool {
+	for axis := 0; axis < 3; axis++ {
+		v := axisOf(p, axis)
+		if compareAxis(v, axisOf(r.Min, axis)) < 0 || compareAxis(v, axisOf(r.Max, axis)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// axis is one coordinate of a GalacticPosition across its three tiers.
+type axis struct {
+	sector, system int64
+	local          float64
+}
+
+func axisOf(p universe.GalacticPosition, i int) axis {
+	switch i {
+	case 0:
+		return axis{p.SectorX, p.SystemX, p.LocalX}
+	case 1:
+		return axis{p.SectorY, p.SystemY, p.LocalY}
+	}
+	return axis{p.SectorZ, p.SystemZ, p.LocalZ}
+}
+
+func compareAxis(a, b axis) int {
+	switch {
+	case a.sector != b.sector:
+		return cmpOrder(a.sector < b.sector)
+	case a.system != b.system:
+		return cmpOrder(a.system < b.system)
+	case a.local != b.local:
+		return cmpOrder(a.local < b.local)
+	}
+	return 0
+}
+
+func cmpOrder(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+// Query selects captures. Zero fields match everything.
+type Query struct {
+	ProbeID  string
+	RunID    string
+	From, To uint64 // inclusive SimTime range; To of 0 means no upper bound
+	Region   *Region
+}
+
+func (q Query) matches(c Capture) bool {
+	if q.ProbeID != "" && c.ProbeID != q.ProbeID {
+		return false
+	}
+	if q.RunID != "" && c.RunID != q.RunID {
+		return false
+	}
+	if c.SimTime < q.From || (q.To != 0 && c.SimTime > q.To) {
+		return false
+	}
+	return q.Region == nil || q.Region.Contains(c.Position)
+}
+
+// Archive stores every received capture on disk together with an index of
+// their metadata. It is safe for concurrent use.
+type Archive struct {
+	mu       sync.Mutex
+	root     string
+	index    *os.File
+	captures []Capture
+	ids      map[string]bool
+	bad      []error // index lines skipped by OpenArchive
+}
+
+// OpenArchive opens the archive in root, creating it if needed, and loads its
+// index. Index lines that cannot be parsed, such as one torn by a crash while
+// it was written, are skipped and reported by BadLines.
+func OpenArchive(root string) (*Archive, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("OpenArchive: %w", err)
+	}
+	a := &Archive{root: root, ids: make(map[string]bool)}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(root, indexFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("OpenArchive: %w", err)
+	}
+	if st, err := f.Stat(); err == nil && st.Size() == 0 {
+		fmt.Fprintln(f, indexHeader)
+	} else if err == nil && !endsWithNewline(filepath.Join(root, indexFile), st.Size()) {
+		// Finish a torn line so the next capture starts a line of its own.
+		fmt.Fprintln(f)
+	}
+	a.index = f
+	return a, nil
+}
+
+// endsWithNewline reports whether the size-byte file at path ends with '\n'.
+func endsWithNewline(path string, size int64) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, size-1); err != nil {
+		return true
+	}
+	return last[0] == '\n'
+}
+
+// BadLines returns an error for each index line OpenArchive skipped.
+func (a *Archive) BadLines() []error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]error(nil), a.bad...)
+}
+
+// Root returns the archive directory.
+func (a *Archive) Root() string { return a.root }
+
+// Close closes the index file.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.index.Close()
+}
+
+// Store writes img to the archive and records c in the index. ID and Path are
+// assigned by the archive, and Width and Height are taken from img. The
+// stored capture is returned.
+func (a *Archive) Store(c Capture, img image.Image) (Capture, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	dir := safeName(c.ProbeID)
+	for n := 0; ; n++ {
+		c.ID = fmt.Sprintf("%s/%010d_%d", dir, c.SimTime, n)
+		if !a.ids[c.ID] {
+			break
+		}
+	}
+	c.Path = filepath.ToSlash(c.ID + ".png")
+	c.Width, c.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	full := filepath.Join(a.root, filepath.FromSlash(c.Path))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return Capture{}, fmt.Errorf("Archive.Store: %w", err)
+	}
+	f, err := os.Create(full)
+	if err != nil {
+		return Capture{}, fmt.Errorf("Archive.Store: %w", err)
+	}
+	err = png.Encode(f, img)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Capture{}, fmt.Errorf("Archive.Store: %w", err)
+	}
+
+	if _, err := fmt.Fprintln(a.index, formatCapture(c)); err != nil {
+		return Capture{}, fmt.Errorf("Archive.Store: %w", err)
+	}
+	a.captures = append(a.captures, c)
+	a.ids[c.ID] = true
+	return c, nil
+}
+
+// Query returns the captures matching q in the order they were stored.
+func (a *Archive) Query(q Query) []Capture {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []Capture
+	for _, c := range a.captures {
+		if q.matches(c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Load decodes the PNG of an archived capture.
+func (a *Archive) Load(c Capture) (image.Image, error) {
+	f, err := os.Open(filepath.Join(a.root, filepath.FromSlash(c.Path)))
+	if err != nil {
+		return nil, fmt.Errorf("Archive.Load: %w", err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("Archive.Load: %s: %w", c.Path, err)
+	}
+	return img, nil
+}
+
+func (a *Archive) load() error {
+	f, err := os.Open(filepath.Join(a.root, indexFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("OpenArchive: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := sc.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		c, err := parseCapture(text)
+		if err != nil {
+			a.bad = append(a.bad, fmt.Errorf("%s line %d: %w", indexFile, line, err))
+			continue
+		}
+		a.captures = append(a.captures, c)
+		a.ids[c.ID] = true
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("OpenArchive: %w", err)
+	}
+	return nil
+}
+
+func formatCapture(c Capture) string {
+	p := c.Position
+	fields := []string{
+		strconv.Quote(c.ID),
+		strconv.Quote(c.ProbeID),
+		strconv.FormatUint(c.SimTime, 10),
+		strconv.FormatInt(p.SectorX, 10), strconv.FormatInt(p.SectorY, 10), strconv.FormatInt(p.SectorZ, 10),
+		strconv.FormatInt(p.SystemX, 10), strconv.FormatInt(p.SystemY, 10), strconv.FormatInt(p.SystemZ, 10),
+		formatFloat(p.LocalX), formatFloat(p.LocalY), formatFloat(p.LocalZ),
+		formatFloat(c.Pointing.X), formatFloat(c.Pointing.Y), formatFloat(c.Pointing.Z),
+		strconv.Quote(c.Format),
+		strconv.Itoa(c.Width), strconv.Itoa(c.Height),
+		formatFloat(c.FOV), strconv.Itoa(c.Render), strconv.Quote(c.Profile),
+		strconv.Quote(c.Path),
+		strconv.Quote(c.RunID),
+	}
+	return strings.Join(fields, "\t")
+}
+
+func formatFloat(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+
+func parseCapture(line string) (Capture, error) {
+	f := strings.Split(line, "\t")
+	if len(f) != indexFields {
+		return Capture{}, fmt.Errorf("got %d fields, want %d", len(f), indexFields)
+	}
+	p := &fieldParser{fields: f}
+	var c Capture
+	c.ID = p.str()
+	c.ProbeID = p.str()
+	c.SimTime = p.uint()
+	c.Position.SectorX, c.Position.SectorY, c.Position.SectorZ = p.int(), p.int(), p.int()
+	c.Position.SystemX, c.Position.SystemY, c.Position.SystemZ = p.int(), p.int(), p.int()
+	c.Position.LocalX, c.Position.LocalY, c.Position.LocalZ = p.float(), p.float(), p.float()
+	c.Pointing = si3d.NewVector3(p.float(), p.float(), p.float())
+	c.Format = p.str()
+	c.Width, c.Height = int(p.int()), int(p.int())
+	c.FOV, c.Render, c.Profile = p.float(), int(p.int()), p.str()
+	c.Path = p.str()
+	c.RunID = p.str()
+	return c, p.err
+}
+
+// fieldParser reads index fields in order, keeping the first error.
+type fieldParser struct {
+	fields []string
+	next   int
+	err    error
+}
+
+func (p *fieldParser) take() string {
+	s := p.fields[p.next]
+	p.next++
+	return s
+}
+
+func (p *fieldParser) fail(err error) {
+	if p.err == nil {
+		p.err = fmt.Errorf("field %d: %w", p.next, err)
+	}
+}
+
+func (p *fieldParser) str() string {
+	s, err := strconv.Unquote(p.take())
+	if err != nil {
+		p.fail(err)
+	}
+	return s
+}
+
+func (p *fieldParser) uint() uint64 {
+	v, err := strconv.ParseUint(p.take(), 10, 64)
+	if err != nil {
+		p.fail(err)
+	}
+	return v
+}
+
+func (p *fieldParser) int() int64 {
+	v, err := strconv.ParseInt(p.take(), 10, 64)
+	if err != nil {
+		p.fail(err)
+	}
+	return v
+}
+
+func (p *fieldParser) float() float64 {
+	v, err := strconv.ParseFloat(p.take(), 64)
+	if err != nil {
+		p.fail(err)
+	}
+	return v
+}
+
+// safeName maps a probe ID to a directory name.
+func safeName(id string) string {
+	if id == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, id)
+}
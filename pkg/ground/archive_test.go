@@ -0,0 +1,161 @@
+package ground
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+func solid(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestArchive_StoreQueryReopen(t *testing.T) {
+	root := t.TempDir()
+	a, err := OpenArchive(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	near := *universe.NewGalacticPosition(10000, 25000, 35000, 0, 0, 0, 0, -200, -400)
+	far := *universe.NewGalacticPosition(10001, 25000, 35000, 0, 0, 0, 0, 0, 0)
+	stored := []Capture{
+		{ProbeID: "Voyager-1", SimTime: 10, Position: near, Pointing: si3d.NewVector3(0, -200, 0), Format: "rgb332"},
+		{ProbeID: "Voyager-1", SimTime: 10, Position: near, Format: "gray8", FOV: 14.9, Render: 1100, Profile: "ccd"},
+		{ProbeID: "Pioneer\t10", SimTime: 50, Position: far, Format: "rgb565", RunID: "run-2"},
+	}
+	for i, c := range stored {
+		got, err := a.Store(c, solid(4+i, 3, color.RGBA{R: uint8(i * 80), A: 255}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored[i] = got
+	}
+	if stored[0].ID == stored[1].ID {
+		t.Fatalf("captures on the same tick share ID %q", stored[0].ID)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err = OpenArchive(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	all := a.Query(Query{})
+	if len(all) != len(stored) {
+		t.Fatalf("reopened archive has %d captures, want %d", len(all), len(stored))
+	}
+	for i := range all {
+		if all[i] != stored[i] {
+			t.Fatalf("capture %d = %+v, want %+v", i, all[i], stored[i])
+		}
+	}
+
+	if got := a.Query(Query{ProbeID: "Voyager-1"}); len(got) != 2 {
+		t.Fatalf("probe query returned %d captures, want 2", len(got))
+	}
+	if got := a.Query(Query{From: 20, To: 60}); len(got) != 1 || got[0].ProbeID != "Pioneer\t10" {
+		t.Fatalf("time query returned %+v", got)
+	}
+	if got := a.Query(Query{RunID: "run-2"}); len(got) != 1 || got[0].ProbeID != "Pioneer\t10" {
+		t.Fatalf("run query returned %+v", got)
+	}
+	region := &Region{
+		Min: *universe.NewGalacticPosition(10000, 25000, 35000, 0, 0, 0, -1000, -1000, -1000),
+		Max: *universe.NewGalacticPosition(10000, 25000, 35000, 0, 0, 0, 1000, 1000, 1000),
+	}
+	if got := a.Query(Query{Region: region}); len(got) != 2 {
+		t.Fatalf("region query returned %d captures, want 2", len(got))
+	}
+
+	img, err := a.Load(stored[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 6 || stored[2].Width != 6 {
+		t.Fatalf("loaded width %d, recorded %d, want 6", img.Bounds().Dx(), stored[2].Width)
+	}
+	if _, err := os.Stat(filepath.Join(root, "Pioneer_10")); err != nil {
+		t.Fatalf("probe directory not sanitised: %v", err)
+	}
+}
+
+func TestOpenArchive_ReportsCorruptIndex(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, indexFile), []byte(indexHeader+"\nnot\ta\tcapture\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := OpenArchive(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if bad := a.BadLines(); len(bad) != 1 || !strings.Contains(bad[0].Error(), "line 2") {
+		t.Errorf("BadLines = %v, want line 2", bad)
+	}
+}
+
+func TestArchive_SkipsTornIndexLine(t *testing.T) {
+	root := t.TempDir()
+	a, err := OpenArchive(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Store(Capture{ProbeID: "Voyager-1", SimTime: 1}, solid(2, 2, color.RGBA{A: 255})); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+
+	// A crash while appending leaves half a line without a newline.
+	f, err := os.OpenFile(filepath.Join(root, indexFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`"Voyager-1/0000000002_0"` + "\t\"Voya")
+	f.Close()
+
+	a, err = OpenArchive(root)
+	if err != nil {
+		t.Fatalf("OpenArchive with a torn line: %v", err)
+	}
+	if bad := a.BadLines(); len(bad) != 1 {
+		t.Errorf("BadLines = %v, want one", bad)
+	}
+	if _, err := a.Store(Capture{ProbeID: "Voyager-1", SimTime: 3}, solid(2, 2, color.RGBA{A: 255})); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+
+	a, err = OpenArchive(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if got := a.Query(Query{}); len(got) != 2 || got[1].SimTime != 3 {
+		t.Errorf("captures after the torn line: %+v", got)
+	}
+}
+
+func TestCapture_Place(t *testing.T) {
+	var c Capture
+	c.Place(comms.CaptureInfo{Tick: 9, Sector: [3]int64{1, 2, 3}, Local: [3]float64{4, 5, 6}, Pointing: [3]float64{0, -1, 0}, FOV: 15, Render: 1100, Profile: 2})
+	want := *universe.NewGalacticPosition(1, 2, 3, 0, 0, 0, 4, 5, 6)
+	if c.SimTime != 9 || c.Position != want || c.Pointing != si3d.NewVector3(0, -1, 0) ||
+		c.FOV != 15 || c.Render != 1100 || c.Profile != universe.CameraProfiles[2].Name {
+		t.Errorf("placed capture = %+v", c)
+	}
+}
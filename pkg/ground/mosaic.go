@@ -0,0 +1,302 @@
+package ground
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+// Projection selects the panorama layout produced by Mosaic.
+type Projection int
+
+const (
+	Equirectangular Projection = iota // 2:1 longitude/latitude map, +Z at the centre
+	CubeMap                           // 4x3 horizontal cross, +Z front face in the middle
+)
+
+// ParseProjection looks a projection up by name: "equirect" or "cube".
+func ParseProjection(name string) (Projection, error) {
+	switch name {
+	case "equirect", "equirectangular":
+		return Equirectangular, nil
+	case "cube", "cubemap":
+		return CubeMap, nil
+	}
+	return 0, fmt.Errorf("ParseProjection: unknown projection %q", name)
+}
+
+// Tile is one capture placed on the sky by the camera that took it.
+type Tile struct {
+	Image  image.Image
+	Camera *si3d.Camera
+	// Render is the size of the square frame the camera rendered and cropped
+	// Image from, as universe.CameraSpec.Layout returns it. Zero means the
+	// larger side of Image, which is right for a camera with the renderer's
+	// own field of view.
+	Render int
+}
+
+// TileFromCapture loads an archived capture and rebuilds its camera from the
+// recorded position, pointing and render size.
+func TileFromCapture(a *Archive, c Capture) (Tile, error) {
+	img, err := a.Load(c)
+	if err != nil {
+		return Tile{}, err
+	}
+	pos := c.Position
+	return Tile{Image: img, Camera: universe.NewPointedCamera(&pos, c.Pointing), Render: c.Render}, nil
+}
+
+// renderSize returns the size of the square frame a width×height image was
+// cropped from, given the recorded render size, which is 0 if not known.
+func renderSize(render, width, height int) (int, error) {
+	if render == 0 {
+		return max(width, height), nil
+	}
+	if render < max(width, height) {
+		return 0, fmt.Errorf("%dx%d image cannot be cropped from a %d pixel render", width, height, render)
+	}
+	return render, nil
+}
+
+// MosaicOptions configures Mosaic.
+type MosaicOptions struct {
+	Projection Projection
+	// Width of the panorama in pixels. The height is Width/2 for
+	// Equirectangular and 3*Width/4 for CubeMap. Defaults to 1024.
+	Width int
+}
+
+// Seam measures how well two tiles agree where they overlap. A large MeanDiff
+// on a seam between tiles that should show the same stars points at a
+// pointing or star-tracking error on one of them.
+type Seam struct {
+	A, B     int // tile indices, A < B
+	Pixels   int // panorama pixels covered by both
+	MeanDiff float64
+}
+
+// MosaicResult is a stitched panorama.
+type MosaicResult struct {
+	Image    *image.RGBA // uncovered sky is transparent
+	Coverage float64     // fraction of the sky covered by at least one tile
+	Seams    []Seam      // overlapping tile pairs, worst agreement first
+}
+
+// Mosaic stitches tiles into a panorama. Every panorama pixel is turned into a
+// view direction and projected into each tile with the same camera matrix and
+// screen projection TakeProbeSnapshot uses at the tile's render size, then
+// offset by the crop, so narrow-angle tiles cover only their own field of
+// view. Overlapping samples are blended with weights that fall off towards
+// tile edges to hide the seams.
+func Mosaic(tiles []Tile, opts MosaicOptions) (*MosaicResult, error) {
+	w := opts.Width
+	if w == 0 {
+		w = 1024
+	}
+	var h int
+	switch opts.Projection {
+	case Equirectangular:
+		h = w / 2
+	case CubeMap:
+		if w%4 != 0 {
+			return nil, fmt.Errorf("Mosaic: cube map width %d is not a multiple of 4", w)
+		}
+		h = w / 4 * 3
+	default:
+		return nil, fmt.Errorf("Mosaic: unknown projection %d", opts.Projection)
+	}
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("Mosaic: bad width %d", opts.Width)
+	}
+
+	sources := make([]mosaicSource, len(tiles))
+	for i, t := range tiles {
+		if t.Camera == nil {
+			return nil, fmt.Errorf("Mosaic: tile %d has no camera", i)
+		}
+		b := t.Image.Bounds()
+		render, err := renderSize(t.Render, b.Dx(), b.Dy())
+		if err != nil {
+			return nil, fmt.Errorf("Mosaic: tile %d: %w", i, err)
+		}
+		rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Rect, t.Image, b.Min, draw.Src)
+		sources[i] = mosaicSource{
+			img:    rgba,
+			view:   t.Camera.GetMatrix(),
+			render: float64(render),
+			cropX:  float64((render - b.Dx()) / 2),
+			cropY:  float64((render - b.Dy()) / 2),
+		}
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	seams := make(map[[2]int]*Seam)
+	var samples []mosaicSample
+	sky, covered := 0, 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dir, ok := panoramaDirection(opts.Projection, x, y, w, h)
+			if !ok {
+				continue
+			}
+			sky++
+
+			samples = samples[:0]
+			for i := range sources {
+				if s, ok := sources[i].sample(dir); ok {
+					s.tile = i
+					samples = append(samples, s)
+				}
+			}
+			if len(samples) == 0 {
+				continue
+			}
+			covered++
+
+			var r, g, b, total float64
+			for _, s := range samples {
+				r += s.c[0] * s.weight
+				g += s.c[1] * s.weight
+				b += s.c[2] * s.weight
+				total += s.weight
+			}
+			out.SetRGBA(x, y, color.RGBA{R: toByte(r / total), G: toByte(g / total), B: toByte(b / total), A: 255})
+
+			for i := 0; i < len(samples); i++ {
+				for j := i + 1; j < len(samples); j++ {
+					key := [2]int{samples[i].tile, samples[j].tile}
+					sm, ok := seams[key]
+					if !ok {
+						sm = &Seam{A: key[0], B: key[1]}
+						seams[key] = sm
+					}
+					sm.Pixels++
+					sm.MeanDiff += (math.Abs(samples[i].c[0]-samples[j].c[0]) +
+						math.Abs(samples[i].c[1]-samples[j].c[1]) +
+						math.Abs(samples[i].c[2]-samples[j].c[2])) / 3
+				}
+			}
+		}
+	}
+
+	res := &MosaicResult{Image: out}
+	if sky > 0 {
+		res.Coverage = float64(covered) / float64(sky)
+	}
+	for _, sm := range seams {
+		sm.MeanDiff /= float64(sm.Pixels)
+		res.Seams = append(res.Seams, *sm)
+	}
+	sort.Slice(res.Seams, func(i, j int) bool {
+		if res.Seams[i].MeanDiff != res.Seams[j].MeanDiff {
+			return res.Seams[i].MeanDiff > res.Seams[j].MeanDiff
+		}
+		if res.Seams[i].A != res.Seams[j].A {
+			return res.Seams[i].A < res.Seams[j].A
+		}
+		return res.Seams[i].B < res.Seams[j].B
+	})
+	return res, nil
+}
+
+type mosaicSource struct {
+	img  *image.RGBA
+	view si3d.Matrix
+	// render is the size of the square frame the tile was cropped from,
+	// and cropX and cropY the position of the tile within it.
+	render       float64
+	cropX, cropY float64
+}
+
+type mosaicSample struct {
+	tile   int
+	c      [3]float64
+	weight float64
+}
+
+// sample projects the unit direction dir into the tile and returns the
+// bilinearly filtered colour there, weighted by distance from the tile edge.
+func (s *mosaicSource) sample(dir si3d.Vector3) (mosaicSample, bool) {
+	cs := s.view.RotateVector3(dir)
+	if cs.Z <= 0 {
+		return mosaicSample{}, false
+	}
+	w, h := float64(s.img.Rect.Dx()), float64(s.img.Rect.Dy())
+	sx := si3d.ConvertToScreenX(s.render, s.render, cs.X, cs.Z) - s.cropX
+	sy := si3d.ConvertToScreenY(s.render, s.render, cs.Y, cs.Z) - s.cropY
+	if sx < 0 || sx >= w || sy < 0 || sy >= h {
+		return mosaicSample{}, false
+	}
+
+	// Pixel i covers [i, i+1), so its centre is at i+0.5.
+	fx, fy := sx-0.5, sy-0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+	var out mosaicSample
+	for _, n := range [4]struct {
+		dx, dy int
+		k      float64
+	}{
+		{0, 0, (1 - tx) * (1 - ty)},
+		{1, 0, tx * (1 - ty)},
+		{0, 1, (1 - tx) * ty},
+		{1, 1, tx * ty},
+	} {
+		px := min(max(x0+n.dx, 0), s.img.Rect.Dx()-1)
+		py := min(max(y0+n.dy, 0), s.img.Rect.Dy()-1)
+		o := s.img.PixOffset(px, py)
+		out.c[0] += float64(s.img.Pix[o]) * n.k
+		out.c[1] += float64(s.img.Pix[o+1]) * n.k
+		out.c[2] += float64(s.img.Pix[o+2]) * n.k
+	}
+
+	edge := min(sx, w-sx, sy, h-sy)
+	out.weight = edge/(min(w, h)/2) + 1e-6
+	return out, true
+}
+
+// panoramaDirection returns the unit view direction of panorama pixel (x, y),
+// or false for cube map cells outside the cross.
+func panoramaDirection(p Projection, x, y, w, h int) (si3d.Vector3, bool) {
+	if p == Equirectangular {
+		lon := (float64(x)+0.5)/float64(w)*2*math.Pi - math.Pi
+		lat := math.Pi/2 - (float64(y)+0.5)/float64(h)*math.Pi
+		return si3d.NewVector3(math.Cos(lat)*math.Sin(lon), math.Sin(lat), math.Cos(lat)*math.Cos(lon)), true
+	}
+
+	face := w / 4
+	col, row := x/face, y/face
+	u := (float64(x%face)+0.5)/float64(face)*2 - 1
+	v := (float64(y%face)+0.5)/float64(face)*2 - 1
+	var d si3d.Vector3
+	switch {
+	case row == 0 && col == 1: // +Y
+		d = si3d.NewVector3(u, 1, v)
+	case row == 2 && col == 1: // -Y
+		d = si3d.NewVector3(u, -1, -v)
+	case row == 1 && col == 0: // -X
+		d = si3d.NewVector3(-1, -v, u)
+	case row == 1 && col == 1: // +Z
+		d = si3d.NewVector3(u, -v, 1)
+	case row == 1 && col == 2: // +X
+		d = si3d.NewVector3(1, -v, -u)
+	case row == 1 && col == 3: // -Z
+		d = si3d.NewVector3(-u, -v, -1)
+	default:
+		return si3d.Vector3{}, false
+	}
+	n := math.Sqrt(d.X*d.X + d.Y*d.Y + d.Z*d.Z)
+	return si3d.NewVector3(d.X/n, d.Y/n, d.Z/n), true
+}
+
+func toByte(v float64) uint8 {
+	return uint8(min(max(math.Round(v), 0), 255))
+}
@@ -0,0 +1,111 @@
+package ground
+
+import (
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+// forwardTile is a solid tile looking down +Z from the origin.
+func forwardTile(c color.RGBA) Tile {
+	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
+	return Tile{Image: solid(128, 128, c), Camera: universe.NewPointedCamera(pos, si3d.NewVector3(0, 0, 1000))}
+}
+
+func TestMosaic_EquirectangularPlacesTileAhead(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	res, err := Mosaic([]Tile{forwardTile(red)}, MosaicOptions{Projection: Equirectangular, Width: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := res.Image.Bounds(); b.Dx() != 256 || b.Dy() != 128 {
+		t.Fatalf("panorama is %v, want 256x128", b)
+	}
+	if got := res.Image.RGBAAt(128, 64); got != red {
+		t.Fatalf("centre of panorama = %v, want %v", got, red)
+	}
+	if got := res.Image.RGBAAt(0, 64); got.A != 0 {
+		t.Fatalf("sky behind the camera = %v, want transparent", got)
+	}
+	if res.Coverage <= 0 || res.Coverage >= 0.5 {
+		t.Fatalf("coverage = %.3f, want a single field of view", res.Coverage)
+	}
+	if len(res.Seams) != 0 {
+		t.Fatalf("single tile produced seams %+v", res.Seams)
+	}
+}
+
+func TestMosaic_CroppedTileCoversItsOwnFieldOfView(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	wide, err := Mosaic([]Tile{forwardTile(red)}, MosaicOptions{Projection: Equirectangular, Width: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tele := forwardTile(red)
+	tele.Render = 512
+	narrow, err := Mosaic([]Tile{tele}, MosaicOptions{Projection: Equirectangular, Width: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := narrow.Image.RGBAAt(256, 128); got != red {
+		t.Fatalf("centre of panorama = %v, want %v", got, red)
+	}
+	// The tile is the middle quarter of a 512 pixel render, so it spans a
+	// quarter of the wide tile's screen on each axis; the wide tile's edges
+	// stretch over less sky, so the ratio is somewhat over 1/16.
+	if r := narrow.Coverage / wide.Coverage; r < 1.0/16 || r > 0.25 {
+		t.Fatalf("cropped tile covers %.3f of the wide tile's sky, want a little over 1/16", r)
+	}
+
+	tele.Render = 64
+	if _, err := Mosaic([]Tile{tele}, MosaicOptions{}); err == nil {
+		t.Fatal("expected an error for a render smaller than the tile")
+	}
+}
+
+func TestMosaic_BlendsOverlapAndReportsSeams(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	res, err := Mosaic([]Tile{forwardTile(red), forwardTile(red), forwardTile(blue)}, MosaicOptions{Projection: CubeMap, Width: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := res.Image.Bounds(); b.Dx() != 128 || b.Dy() != 96 {
+		t.Fatalf("cube map is %v, want 128x96", b)
+	}
+
+	// Front face centre blends two red tiles with one blue one.
+	got := res.Image.RGBAAt(48, 48)
+	if got.R < 160 || got.R > 180 || got.B < 75 || got.B > 95 {
+		t.Fatalf("blended centre = %v, want two thirds red, one third blue", got)
+	}
+	if got := res.Image.RGBAAt(112, 48); got.A != 0 {
+		t.Fatalf("back face = %v, want transparent", got)
+	}
+	if got := res.Image.RGBAAt(0, 0); got.A != 0 {
+		t.Fatalf("cell outside the cross = %v, want transparent", got)
+	}
+
+	if len(res.Seams) != 3 {
+		t.Fatalf("got %d seams, want 3", len(res.Seams))
+	}
+	worst, best := res.Seams[0], res.Seams[2]
+	if worst.B != 2 || math.Abs(worst.MeanDiff-170) > 1 {
+		t.Fatalf("worst seam = %+v, want a red/blue pair differing by 170", worst)
+	}
+	if best.A != 0 || best.B != 1 || best.MeanDiff != 0 {
+		t.Fatalf("best seam = %+v, want the two red tiles agreeing exactly", best)
+	}
+}
+
+func TestMosaic_RejectsBadOptions(t *testing.T) {
+	if _, err := Mosaic(nil, MosaicOptions{Projection: CubeMap, Width: 130}); err == nil {
+		t.Fatal("expected an error for a cube map width not divisible by 4")
+	}
+	if _, err := Mosaic([]Tile{{Image: solid(4, 4, color.RGBA{})}}, MosaicOptions{}); err == nil {
+		t.Fatal("expected an error for a tile without a camera")
+	}
+}
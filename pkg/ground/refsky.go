@@ -0,0 +1,206 @@
+package ground
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+// Pose is where a probe claims to be and where it claims to be looking, and
+// the camera it was looking with.
+type Pose struct {
+	Position universe.GalacticPosition
+	Pointing si3d.Vector3 // camera look-at target, local coordinates
+	// Render is the size of the square frame the camera renders and crops
+	// its images from, as in Tile. Zero means the larger side of the image.
+	Render int
+	// Profile names the camera's entry in universe.CameraProfiles; empty
+	// means the default profile.
+	Profile string
+}
+
+// PoseOf returns the pose and camera recorded with an archived capture.
+func PoseOf(c Capture) Pose {
+	return Pose{Position: c.Position, Pointing: c.Pointing, Render: c.Render, Profile: c.Profile}
+}
+
+// RenderReferenceSky renders the width×height starfield the camera of pose
+// should see, without sensor noise and without local scene entities. Like
+// LocalScene.TakeExposure it renders the pose's full square frame and crops
+// the middle of it.
+func RenderReferenceSky(pose Pose, width, height int) (*image.RGBA, error) {
+	render, err := renderSize(pose.Render, width, height)
+	if err != nil {
+		return nil, fmt.Errorf("RenderReferenceSky: %w", err)
+	}
+	profile := universe.DefaultCameraProfile
+	if pose.Profile != "" {
+		if profile, err = universe.CameraProfileByName(pose.Profile); err != nil {
+			return nil, fmt.Errorf("RenderReferenceSky: %w", err)
+		}
+	}
+	pos := pose.Position
+	cam := universe.NewPointedCamera(&pos, pose.Pointing)
+	full := universe.GalaxyStars.RenderReference(cam, pos.ToStarfieldPosition(), render, render, universe.DefaultExposure, profile)
+	if render == width && render == height {
+		return full, nil
+	}
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(out, out.Rect, full, image.Pt((render-width)/2, (render-height)/2), draw.Src)
+	return out, nil
+}
+
+// SkyMatch is the result of comparing a downlinked image with a reference.
+type SkyMatch struct {
+	// Score is the normalised cross-correlation of the two images at the best
+	// offset, from -1 to 1. Above roughly 0.5 the star patterns agree.
+	Score float64
+	// DX and DY are the sub-pixel shift of the observed image relative to the
+	// reference: a star at (x, y) in the reference appears at (x+DX, y+DY).
+	DX, DY float64
+	// Overlap is the number of pixels compared at the best integer offset.
+	Overlap int
+}
+
+// CompareSky finds the shift of observed relative to reference, searching up
+// to maxShift pixels in each direction, and scores how well they match. The
+// images must be the same size.
+func CompareSky(observed, reference image.Image, maxShift int) (SkyMatch, error) {
+	ob, rb := observed.Bounds(), reference.Bounds()
+	if ob.Dx() != rb.Dx() || ob.Dy() != rb.Dy() {
+		return SkyMatch{}, fmt.Errorf("CompareSky: observed %dx%d and reference %dx%d differ in size", ob.Dx(), ob.Dy(), rb.Dx(), rb.Dy())
+	}
+	w, h := ob.Dx(), ob.Dy()
+	if maxShift < 0 || maxShift >= w || maxShift >= h {
+		return SkyMatch{}, fmt.Errorf("CompareSky: shift %d out of range for %dx%d images", maxShift, w, h)
+	}
+	obs, ref := luminance(observed), luminance(reference)
+
+	span := 2*maxShift + 1
+	scores := make([]float64, span*span)
+	best := SkyMatch{Score: math.Inf(-1)}
+	bestX, bestY := 0, 0
+	for dy := -maxShift; dy <= maxShift; dy++ {
+		for dx := -maxShift; dx <= maxShift; dx++ {
+			score, n := ncc(obs, ref, w, h, dx, dy)
+			scores[(dy+maxShift)*span+dx+maxShift] = score
+			if score > best.Score {
+				best = SkyMatch{Score: score, DX: float64(dx), DY: float64(dy), Overlap: n}
+				bestX, bestY = dx+maxShift, dy+maxShift
+			}
+		}
+	}
+
+	// Refine to sub-pixel precision by fitting a parabola through the peak
+	// and its neighbours on each axis.
+	at := func(x, y int) float64 { return scores[y*span+x] }
+	if bestX > 0 && bestX < span-1 {
+		best.DX += parabolaPeak(at(bestX-1, bestY), at(bestX, bestY), at(bestX+1, bestY))
+	}
+	if bestY > 0 && bestY < span-1 {
+		best.DY += parabolaPeak(at(bestX, bestY-1), at(bestX, bestY), at(bestX, bestY+1))
+	}
+	return best, nil
+}
+
+// OffsetAngles converts a pixel shift in a width×height image taken with the
+// pose's camera into the yaw and pitch, in radians, by which the camera is
+// turned relative to the pose. Positive yaw turns towards increasing screen x
+// and positive pitch towards the top of the image. The scale is taken from the
+// same screen projection the renderer uses at the camera's render size.
+func (p Pose) OffsetAngles(width, height int, dx, dy float64) (yaw, pitch float64, err error) {
+	render, err := renderSize(p.Render, width, height)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Pose.OffsetAngles: %w", err)
+	}
+	r := float64(render)
+	scaleX := si3d.ConvertToScreenX(r, r, 1, 1) - si3d.ConvertToScreenX(r, r, 0, 1)
+	scaleY := si3d.ConvertToScreenY(r, r, 0, 1) - si3d.ConvertToScreenY(r, r, 1, 1)
+	// Stars move opposite to the camera: the scene shifts right when the
+	// camera turns left.
+	return -math.Atan(dx / scaleX), math.Atan(dy / scaleY), nil
+}
+
+// BestPose renders the reference sky for each candidate pose and returns the
+// index of the one matching observed best, together with its match. It is a
+// brute-force search for estimating position errors from a capture.
+func BestPose(observed image.Image, candidates []Pose, maxShift int) (int, SkyMatch, error) {
+	if len(candidates) == 0 {
+		return -1, SkyMatch{}, fmt.Errorf("BestPose: no candidate poses")
+	}
+	b := observed.Bounds()
+	bestIdx, best := -1, SkyMatch{Score: math.Inf(-1)}
+	for i, pose := range candidates {
+		ref, err := RenderReferenceSky(pose, b.Dx(), b.Dy())
+		if err != nil {
+			return -1, SkyMatch{}, fmt.Errorf("BestPose: candidate %d: %w", i, err)
+		}
+		m, err := CompareSky(observed, ref, maxShift)
+		if err != nil {
+			return -1, SkyMatch{}, err
+		}
+		if m.Score > best.Score {
+			bestIdx, best = i, m
+		}
+	}
+	return bestIdx, best, nil
+}
+
+// luminance returns the grey level of each pixel, row by row.
+func luminance(img image.Image) []float64 {
+	b := img.Bounds()
+	out := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			out = append(out, float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y))
+		}
+	}
+	return out
+}
+
+// ncc is the normalised cross-correlation of obs(x+dx, y+dy) with ref(x, y)
+// over their overlap. Flat images score 0.
+func ncc(obs, ref []float64, w, h, dx, dy int) (float64, int) {
+	x0, x1 := max(0, -dx), min(w, w-dx)
+	y0, y1 := max(0, -dy), min(h, h-dy)
+	var so, sr, soo, srr, sor float64
+	n := 0
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			o := obs[(y+dy)*w+x+dx]
+			r := ref[y*w+x]
+			so += o
+			sr += r
+			soo += o * o
+			srr += r * r
+			sor += o * r
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	fn := float64(n)
+	cov := sor - so*sr/fn
+	vo := soo - so*so/fn
+	vr := srr - sr*sr/fn
+	if vo <= 0 || vr <= 0 {
+		return 0, n
+	}
+	return cov / math.Sqrt(vo*vr), n
+}
+
+// parabolaPeak returns the offset, in [-0.5, 0.5], of the vertex of the
+// parabola through (-1, a), (0, b) and (1, c).
+func parabolaPeak(a, b, c float64) float64 {
+	d := a - 2*b + c
+	if d >= 0 {
+		return 0
+	}
+	return min(max(0.5*(a-c)/d, -0.5), 0.5)
+}
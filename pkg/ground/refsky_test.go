@@ -0,0 +1,152 @@
+package ground
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+// syntheticSky draws blurred stars at fixed positions, shifted by (sx, sy).
+func syntheticSky(w, h, sx, sy int) *image.RGBA {
+	img := solid(w, h, color.RGBA{R: 5, G: 5, B: 8, A: 255})
+	r := rand.New(rand.NewSource(7))
+	for i := 0; i < 40; i++ {
+		cx, cy := r.Intn(w)+sx, r.Intn(h)+sy
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				x, y := cx+dx, cy+dy
+				if !image.Pt(x, y).In(img.Rect) {
+					continue
+				}
+				v := uint8(250 / (1 + dx*dx + dy*dy))
+				img.SetRGBA(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestCompareSky_FindsShift(t *testing.T) {
+	ref := syntheticSky(128, 128, 0, 0)
+	obs := syntheticSky(128, 128, 3, -2)
+
+	m, err := CompareSky(obs, ref, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(m.DX-3) > 0.5 || math.Abs(m.DY+2) > 0.5 {
+		t.Fatalf("offset = (%.2f, %.2f), want (3, -2)", m.DX, m.DY)
+	}
+	if m.Score < 0.9 {
+		t.Fatalf("score = %.3f for a pure shift, want > 0.9", m.Score)
+	}
+
+	other := syntheticSky(128, 128, 0, 0)
+	for i := range other.Pix {
+		if i%4 != 3 {
+			other.Pix[i] = 255 - other.Pix[i]
+		}
+	}
+	if m, _ := CompareSky(other, ref, 8); m.Score > 0.5 {
+		t.Fatalf("inverted sky scored %.3f, want a poor match", m.Score)
+	}
+
+	if _, err := CompareSky(obs, solid(64, 64, color.RGBA{}), 8); err == nil {
+		t.Fatal("expected an error for images of different sizes")
+	}
+}
+
+func TestRenderReferenceSky_IsNoiseFreeSnapshot(t *testing.T) {
+	pose := Pose{
+		Position: *universe.NewGalacticPosition(10000, 25000, 35000, 0, 0, 0, 0, -200, -400),
+		Pointing: si3d.NewVector3(0, -200, 0),
+	}
+	ref, err := RenderReferenceSky(pose, 64, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := RenderReferenceSky(pose, 64, 64); string(again.Pix) != string(ref.Pix) {
+		t.Fatal("reference render is not deterministic")
+	}
+
+	pos := pose.Position
+	cam := universe.NewPointedCamera(&pos, pose.Pointing)
+	snap := universe.GalaxyStars.TakeProbeSnapshot(cam, pos.ToStarfieldPosition(), 64, 64, universe.DefaultExposure, universe.Seed)
+	for i := range ref.Pix {
+		if d := int(ref.Pix[i]) - int(snap.Pix[i]); d < -2 || d > 2 {
+			t.Fatalf("byte %d differs from the snapshot by %d, more than sensor noise", i, d)
+		}
+	}
+}
+
+func TestRenderReferenceSky_UsesPoseCamera(t *testing.T) {
+	pose := Pose{
+		Position: *universe.NewGalacticPosition(10000, 25000, 35000, 0, 0, 0, 0, -200, -400),
+		Pointing: si3d.NewVector3(0, -200, 0),
+	}
+	full, err := RenderReferenceSky(pose, 256, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pose.Render = 256
+	narrow, err := RenderReferenceSky(pose, 64, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			if narrow.RGBAAt(x, y) != full.RGBAAt(96+x, 112+y) {
+				t.Fatalf("pixel (%d, %d) is not the middle of the full render", x, y)
+			}
+		}
+	}
+
+	pose.Render = 0
+	pose.Profile = universe.CameraProfiles[len(universe.CameraProfiles)-1].Name
+	other, err := RenderReferenceSky(pose, 256, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(other.Pix) == string(full.Pix) {
+		t.Errorf("profile %q rendered the same as the default", pose.Profile)
+	}
+
+	for _, bad := range []Pose{{Profile: "no-such-camera"}, {Render: 32}} {
+		if _, err := RenderReferenceSky(bad, 64, 64); err == nil {
+			t.Errorf("RenderReferenceSky(%+v) succeeded", bad)
+		}
+	}
+}
+
+func TestPose_OffsetAngles(t *testing.T) {
+	var wide Pose
+	if yaw, pitch, err := wide.OffsetAngles(128, 128, 0, 0); err != nil || yaw != 0 || pitch != 0 {
+		t.Fatalf("zero offset gave yaw %v pitch %v, %v", yaw, pitch, err)
+	}
+	yaw, _, _ := wide.OffsetAngles(128, 128, 10, 0)
+	if yaw >= 0 {
+		t.Fatalf("stars moving right gave yaw %v, want the camera turned left", yaw)
+	}
+	_, pitch, _ := wide.OffsetAngles(128, 128, 0, 10)
+	if pitch <= 0 {
+		t.Fatalf("stars moving down gave pitch %v, want the camera tilted up", pitch)
+	}
+
+	// A telescope's pixels span a smaller angle.
+	tele := Pose{Render: 1024}
+	teleYaw, _, err := tele.OffsetAngles(128, 128, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := yaw / 8; math.Abs(teleYaw-want) > math.Abs(want)*0.01 {
+		t.Errorf("telescope yaw %v, want about %v", teleYaw, want)
+	}
+	if _, _, err := (Pose{Render: 64}).OffsetAngles(128, 128, 1, 1); err == nil {
+		t.Error("a render smaller than the image was accepted")
+	}
+}
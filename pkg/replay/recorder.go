@@ -0,0 +1,106 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/spacecraft"
+)
+
+// Recorder steps a world and records its inputs. It is a comms.Bus and a
+// comms.Inspector: give it, not the world's bus, to anything outside the
+// world that sends messages, such as a comms.Server. Sends, commands and steps are serialised, so an
+// uplink always lands between two ticks.
+type Recorder struct {
+	mu    sync.Mutex
+	world *spacecraft.World
+	enc   *gob.Encoder
+	err   error
+}
+
+// NewRecorder writes the world's current state to w and starts recording.
+func NewRecorder(w io.Writer, world *spacecraft.World) (*Recorder, error) {
+	g, err := world.SaveGame()
+	if err != nil {
+		return nil, fmt.Errorf("NewRecorder: %w", err)
+	}
+	var start bytes.Buffer
+	if _, err := g.WriteTo(&start); err != nil {
+		return nil, fmt.Errorf("NewRecorder: %w", err)
+	}
+	if err := writeHeader(w); err != nil {
+		return nil, fmt.Errorf("NewRecorder: %w", err)
+	}
+	r := &Recorder{world: world, enc: gob.NewEncoder(w)}
+	if err := r.enc.Encode(Header{Start: start.Bytes(), Network: world.Bus.Network()}); err != nil {
+		return nil, fmt.Errorf("NewRecorder: %w", err)
+	}
+	return r, nil
+}
+
+// record writes an event. The first failure is kept and stops recording.
+func (r *Recorder) record(e Event) {
+	if r.err != nil {
+		return
+	}
+	if err := r.enc.Encode(e); err != nil {
+		r.err = fmt.Errorf("Recorder: %w", err)
+	}
+}
+
+// Err returns the error that stopped recording, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Subscribe subscribes to the world's bus. Subscriptions are not recorded.
+func (r *Recorder) Subscribe(id string, receiver comms.ReceiverFunc) *comms.Subscription {
+	return r.world.Bus.Subscribe(id, receiver)
+}
+
+// Subscribers returns the IDs subscribed to the world's bus, so a
+// comms.Server serving the Recorder answers inspection queries.
+func (r *Recorder) Subscribers() []string {
+	return r.world.Bus.Subscribers()
+}
+
+// Pending returns the messages queued on the world's bus.
+func (r *Recorder) Pending() []comms.Message {
+	return r.world.Bus.Pending()
+}
+
+// Send records an uplink and sends it on the world's bus.
+func (r *Recorder) Send(senderID string, targetID string, payload []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	payload = append([]byte(nil), payload...)
+	r.record(Event{Kind: EventUplink, Message: comms.Message{SenderID: senderID, TargetID: targetID, Payload: payload}})
+	r.world.Bus.Send(senderID, targetID, payload)
+}
+
+// Command records an operator command and applies it.
+func (r *Recorder) Command(cmd string, apply CommandFunc) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record(Event{Kind: EventCommand, Command: cmd})
+	return apply(r.world, cmd)
+}
+
+// Step steps the world and records the tick with the state it left.
+func (r *Recorder) Step(cycles int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.world.Step(cycles)
+	state, err := digest(r.world)
+	if err != nil {
+		return fmt.Errorf("Recorder.Step: %w", err)
+	}
+	r.record(Event{Kind: EventTick, Cycles: cycles, Tick: r.world.Tick, State: state})
+	return r.err
+}
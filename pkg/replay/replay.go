@@ -0,0 +1,132 @@
+// Package replay records the external inputs of a simulation run and replays
+// them to reproduce the run exactly.
+//
+// A recording starts from a save game of the world. The world only changes
+// when it is stepped or when something outside it acts, so a recording holds
+// every uplink sent onto the bus from outside, every operator command and
+// every tick boundary. Each tick also records digests of the bus and of each
+// probe's complete state, VM and captured images included, so a replay can
+// tell the first tick at which it went differently.
+package replay
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/spacecraft"
+)
+
+// Recording file layout, integers little-endian:
+//
+//	[Magic "UGRL"][Version: uint16][gob stream: Header, Event, Event, ...]
+//
+// Events are appended as they happen, so a recording cut short by a crash
+// replays up to the last complete event.
+const (
+	recordingMagic = "UGRL"
+	Version        = 1
+)
+
+// Header starts a recording.
+type Header struct {
+	Start   []byte        // the world as a universe save game
+	Network comms.Network // the bus's relays and routes, which save games leave out
+}
+
+// EventKind is the kind of a recorded event.
+type EventKind uint8
+
+const (
+	EventUplink  EventKind = iota + 1 // a message sent onto the bus from outside
+	EventCommand                      // an operator command
+	EventTick                         // the world was stepped
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventUplink:
+		return "uplink"
+	case EventCommand:
+		return "command"
+	case EventTick:
+		return "tick"
+	}
+	return fmt.Sprintf("EventKind(%d)", uint8(k))
+}
+
+// Event is one recorded input.
+type Event struct {
+	Kind    EventKind
+	Message comms.Message // EventUplink
+	Command string        // EventCommand
+	Cycles  int           // EventTick: instructions each probe ran
+	Tick    uint64        // EventTick: the tick the world reached
+	State   []Digest      // EventTick: the state the tick left
+}
+
+// Digest is the hash of one part of the world's state.
+type Digest struct {
+	Name string // "bus" or a probe ID
+	Sum  [sha256.Size]byte
+}
+
+// CommandFunc applies an operator command to the world. Commands are opaque
+// to the recorder; the same function must be given to Replay.
+type CommandFunc func(w *spacecraft.World, cmd string) error
+
+func writeHeader(w io.Writer) error {
+	var header [6]byte
+	copy(header[:], recordingMagic)
+	binary.LittleEndian.PutUint16(header[4:], Version)
+	_, err := w.Write(header[:])
+	return err
+}
+
+func readHeader(r io.Reader) error {
+	var header [6]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	if string(header[:4]) != recordingMagic {
+		return fmt.Errorf("not a recording")
+	}
+	if v := binary.LittleEndian.Uint16(header[4:]); v != Version {
+		return fmt.Errorf("version %d, want %d", v, Version)
+	}
+	return nil
+}
+
+// digest hashes the bus and every probe. The state is hashed as JSON, which
+// unlike gob encodes the same way in every process and sorts map keys.
+func digest(w *spacecraft.World) ([]Digest, error) {
+	d := make([]Digest, 0, len(w.Probes)+1)
+	sum, err := hashJSON(w.Bus.State())
+	if err != nil {
+		return nil, err
+	}
+	d = append(d, Digest{Name: "bus", Sum: sum})
+	for _, sp := range w.Probes {
+		s, err := sp.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		sum, err := hashJSON(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sp.Physical.ID, err)
+		}
+		d = append(d, Digest{Name: sp.Physical.ID, Sum: sum})
+	}
+	return d, nil
+}
+
+func hashJSON(v any) ([sha256.Size]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
@@ -0,0 +1,196 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/spacecraft"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+func newWorld(t *testing.T) *spacecraft.World {
+	t.Helper()
+	bus := comms.NewMessageBus()
+	bus.AddRelay("DSN", comms.Link{Delay: 1})
+	bus.AddRoute(spacecraft.GroundStationID, "Probe1", "DSN")
+	scene := universe.NewLocalScene(1, 2, 3, 4, 5, 6)
+	err := scene.AddEntitySpec(universe.EntitySpec{
+		Kind:      universe.EntityHeightmap,
+		Heightmap: &universe.HeightmapSpec{Width: 100, Depth: 100, Color: color.RGBA{A: 255}, Subdivisions: 2, Seed: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := universe.NewGalacticPosition(1, 2, 3, 4, 5, 6, 7, 8, 9)
+	probe, err := spacecraft.NewSpaceProbeWithOptions("Probe1", pos, scene, bus, spacecraft.ProbeOptions{OSBinary: []byte{1, 2, 3, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &spacecraft.World{Scenes: []*universe.LocalScene{scene}, Bus: bus, Probes: []*spacecraft.SpaceProbe{probe}}
+}
+
+// poke writes the command's value to probe RAM.
+func poke(w *spacecraft.World, cmd string) error {
+	var v byte
+	if _, err := fmt.Sscanf(cmd, "poke %d", &v); err != nil {
+		return err
+	}
+	w.Probes[0].VM.Memory[0x6000] = v
+	return nil
+}
+
+func record(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf, newWorld(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tick := 0; tick < 6; tick++ {
+		switch tick {
+		case 1:
+			rec.Send(spacecraft.GroundStationID, "Probe1", comms.EncodeUplink(comms.UplinkMachineCode, []byte{5, 6, 7, 8}))
+		case 3:
+			if err := rec.Command("poke 9", poke); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rec.Step(100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestReplay_Reproduces(t *testing.T) {
+	recording := record(t)
+	world, err := Replay(bytes.NewReader(recording), comms.NewMessageBus(), poke)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if world.Tick != 6 {
+		t.Errorf("replayed to tick %d, want 6", world.Tick)
+	}
+	vm := world.Probes[0].VM
+	if vm.Memory[0x6000] != 9 || !bytes.Equal(vm.Memory[:4], []byte{5, 6, 7, 8}) {
+		t.Error("replayed inputs did not reach the probe")
+	}
+
+	// A recording cut short replays as far as it goes.
+	if _, err := Replay(bytes.NewReader(recording[:len(recording)-10]), comms.NewMessageBus(), poke); err != nil {
+		t.Errorf("truncated recording: %v", err)
+	}
+}
+
+func TestReplay_ReportsFirstDivergence(t *testing.T) {
+	recording := record(t)
+	pokeOther := func(w *spacecraft.World, cmd string) error {
+		w.Probes[0].VM.Memory[0x6000] = 10
+		return nil
+	}
+	_, err := Replay(bytes.NewReader(recording), comms.NewMessageBus(), pokeOther)
+	var d *Divergence
+	if !errors.As(err, &d) {
+		t.Fatalf("got %v, want a divergence", err)
+	}
+	if d.Tick != 4 || len(d.Parts) != 1 || d.Parts[0] != "Probe1" {
+		t.Errorf("divergence = %v", d)
+	}
+
+	if _, err := Replay(bytes.NewReader(recording), comms.NewMessageBus(), nil); err == nil {
+		t.Error("expected an error replaying a command without a CommandFunc")
+	}
+}
+
+// worldDigest returns the digests of a fresh test world as text.
+func worldDigest(t *testing.T) string {
+	t.Helper()
+	d, err := digest(newWorld(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	for _, part := range d {
+		fmt.Fprintf(&b, "%s=%x ", part.Name, part.Sum)
+	}
+	return b.String()
+}
+
+// A recording is replayed by another process, so the same state must hash
+// the same in every process.
+func TestDigest_SameInEveryProcess(t *testing.T) {
+	if os.Getenv("REPLAY_DIGEST_CHILD") != "" {
+		fmt.Println("digest:", worldDigest(t))
+		return
+	}
+	// Encode a type the child never does, so the two processes number gob
+	// types differently.
+	if err := gob.NewEncoder(io.Discard).Encode(struct{ A, B int }{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	want := worldDigest(t)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDigest_SameInEveryProcess$")
+	cmd.Env = append(os.Environ(), "REPLAY_DIGEST_CHILD=1")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("child: %v\n%s", err, out)
+	}
+	var got string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if line, ok := strings.CutPrefix(sc.Text(), "digest: "); ok {
+			got = line
+		}
+	}
+	if got != want {
+		t.Errorf("child digest %q, want %q", got, want)
+	}
+}
+
+func TestRecorder_AnswersServerQueries(t *testing.T) {
+	rec, err := NewRecorder(io.Discard, newWorld(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := comms.NewServer(rec)
+	go srv.Serve(l)
+	defer srv.Close()
+	client, err := comms.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	rec.Send(spacecraft.GroundStationID, "Probe1", []byte("hello"))
+	subs, err := client.Subscribers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(subs, "Probe1") {
+		t.Errorf("subscribers = %v, want Probe1", subs)
+	}
+	pending, err := client.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || string(pending[0].Payload) != "hello" {
+		t.Errorf("pending = %+v, want the uplink", pending)
+	}
+}
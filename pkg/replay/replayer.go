@@ -0,0 +1,117 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/spacecraft"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+// Divergence is the first tick at which a replay's state differs from the
+// recording's.
+type Divergence struct {
+	Tick  uint64
+	Parts []string // names of the digests that differ
+}
+
+func (d *Divergence) Error() string {
+	return fmt.Sprintf("replay diverged at tick %d: %s differs", d.Tick, strings.Join(d.Parts, ", "))
+}
+
+// Replay rebuilds the recorded world on bus, replacing the bus's relays and
+// routes with the recording's, and feeds it the recorded inputs, applying
+// commands with apply. It returns the world as the replay left it.
+// If the replay diverges it stops at that tick and the error is a
+// *Divergence.
+func Replay(r io.Reader, bus *comms.MessageBus, apply CommandFunc) (*spacecraft.World, error) {
+	if err := readHeader(r); err != nil {
+		return nil, fmt.Errorf("Replay: %w", err)
+	}
+	dec := gob.NewDecoder(r)
+	var h Header
+	if err := dec.Decode(&h); err != nil {
+		return nil, fmt.Errorf("Replay: %w", err)
+	}
+	g, err := universe.ReadSaveGame(bytes.NewReader(h.Start))
+	if err != nil {
+		return nil, fmt.Errorf("Replay: %w", err)
+	}
+	bus.SetNetwork(h.Network)
+	world, err := spacecraft.LoadWorld(g, bus)
+	if err != nil {
+		return nil, fmt.Errorf("Replay: %w", err)
+	}
+
+	for {
+		var e Event
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return world, nil
+			}
+			return world, fmt.Errorf("Replay: %w", err)
+		}
+		switch e.Kind {
+		case EventUplink:
+			m := e.Message
+			bus.Send(m.SenderID, m.TargetID, m.Payload)
+		case EventCommand:
+			if apply == nil {
+				return world, fmt.Errorf("Replay: command %q recorded but no CommandFunc given", e.Command)
+			}
+			if err := apply(world, e.Command); err != nil {
+				return world, fmt.Errorf("Replay: command %q: %w", e.Command, err)
+			}
+		case EventTick:
+			world.Step(e.Cycles)
+			state, err := digest(world)
+			if err != nil {
+				return world, fmt.Errorf("Replay: %w", err)
+			}
+			if parts := differ(e.State, state); world.Tick != e.Tick || len(parts) > 0 {
+				return world, &Divergence{Tick: e.Tick, Parts: parts}
+			}
+		default:
+			return world, fmt.Errorf("Replay: unknown event %s", e.Kind)
+		}
+	}
+}
+
+// ReplayFile replays a recording file.
+func ReplayFile(path string, bus *comms.MessageBus, apply CommandFunc) (*spacecraft.World, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReplayFile: %w", err)
+	}
+	defer f.Close()
+	return Replay(bufio.NewReader(f), bus, apply)
+}
+
+// differ returns the names of the digests that are not the same in want and
+// got, including any only one of them has.
+func differ(want, got []Digest) []string {
+	sums := make(map[string][32]byte, len(got))
+	for _, d := range got {
+		sums[d.Name] = d.Sum
+	}
+	var parts []string
+	for _, d := range want {
+		if sum, ok := sums[d.Name]; !ok || sum != d.Sum {
+			parts = append(parts, d.Name)
+		}
+		delete(sums, d.Name)
+	}
+	for _, d := range got {
+		if _, ok := sums[d.Name]; ok {
+			parts = append(parts, d.Name)
+		}
+	}
+	return parts
+}
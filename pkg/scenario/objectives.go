@@ -0,0 +1,174 @@
+package scenario
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/ground"
+	"github.com/smasonuk/unknowngalaxy/pkg/spacecraft"
+)
+
+// ObjectiveKind is what an objective asks for.
+type ObjectiveKind string
+
+const (
+	// ObjectiveCapture is met when the archive holds Count images from
+	// Probe, or from any probe if Probe is empty, received in the tracked
+	// run.
+	ObjectiveCapture ObjectiveKind = "capture"
+	// ObjectiveReach is met when Probe comes within Radius of Target, a
+	// position local to its scene.
+	ObjectiveReach ObjectiveKind = "reach"
+	// ObjectiveSurvive is met if Probe's CPU is running at tick Deadline.
+	ObjectiveSurvive ObjectiveKind = "survive"
+)
+
+// Objective is a goal of the mission.
+type Objective struct {
+	Name   string        `json:"name"`
+	Kind   ObjectiveKind `json:"kind"`
+	Probe  string        `json:"probe,omitempty"`
+	Count  int           `json:"count,omitempty"`
+	Target [3]float64    `json:"target,omitempty"`
+	Radius float64       `json:"radius,omitempty"`
+	// Deadline is the tick by which the objective must be met, 0 for none.
+	Deadline uint64 `json:"deadline,omitempty"`
+}
+
+func (o Objective) validate(ids map[string]string) error {
+	if o.Probe != "" && ids[o.Probe] != "probe" {
+		return fmt.Errorf("Scenario.Validate: objective %q: unknown probe %q", o.Name, o.Probe)
+	}
+	switch o.Kind {
+	case ObjectiveCapture:
+		if o.Count < 1 {
+			return fmt.Errorf("Scenario.Validate: objective %q: capture count must be at least 1", o.Name)
+		}
+	case ObjectiveReach:
+		if o.Probe == "" || o.Radius <= 0 {
+			return fmt.Errorf("Scenario.Validate: objective %q: reach needs a probe and a radius", o.Name)
+		}
+	case ObjectiveSurvive:
+		if o.Probe == "" || o.Deadline == 0 {
+			return fmt.Errorf("Scenario.Validate: objective %q: survive needs a probe and a deadline", o.Name)
+		}
+	default:
+		return fmt.Errorf("Scenario.Validate: objective %q: unknown kind %q", o.Name, o.Kind)
+	}
+	return nil
+}
+
+// ObjectiveStatus is how an objective stands.
+type ObjectiveStatus int
+
+const (
+	ObjectivePending ObjectiveStatus = iota
+	ObjectiveMet
+	ObjectiveFailed
+)
+
+func (s ObjectiveStatus) String() string {
+	switch s {
+	case ObjectivePending:
+		return "pending"
+	case ObjectiveMet:
+		return "met"
+	case ObjectiveFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("ObjectiveStatus(%d)", int(s))
+}
+
+// Tracker follows objectives as a simulation runs. Once met or failed, an
+// objective stays so.
+type Tracker struct {
+	objectives []Objective
+	status     []ObjectiveStatus
+	run        ground.Query // the archived captures of the tracked run
+}
+
+// NewTracker tracks the scenario's objectives over one run of the
+// simulation, which started at tick start and archives its captures with
+// runID. Captures an earlier run left in the archive do not count.
+func (s *Scenario) NewTracker(runID string, start uint64) *Tracker {
+	return &Tracker{
+		objectives: s.Objectives,
+		status:     make([]ObjectiveStatus, len(s.Objectives)),
+		run:        ground.Query{RunID: runID, From: start},
+	}
+}
+
+// Decision is an objective that has been met or failed.
+type Decision struct {
+	Objective Objective
+	Status    ObjectiveStatus
+	Tick      uint64
+}
+
+// Update checks the pending objectives against the world and archive and
+// returns those that were decided. The archive may be nil, leaving capture
+// objectives pending until their deadline.
+func (t *Tracker) Update(w *spacecraft.World, a *ground.Archive) []Decision {
+	var decided []Decision
+	for i, o := range t.objectives {
+		if t.status[i] != ObjectivePending {
+			continue
+		}
+		s := o.check(w, a, t.run)
+		if s == ObjectivePending && o.Deadline != 0 && w.Tick > o.Deadline {
+			s = ObjectiveFailed
+		}
+		if s != ObjectivePending {
+			t.status[i] = s
+			decided = append(decided, Decision{Objective: o, Status: s, Tick: w.Tick})
+		}
+	}
+	return decided
+}
+
+// Status returns the status of each objective, in scenario order.
+func (t *Tracker) Status() []ObjectiveStatus {
+	return append([]ObjectiveStatus(nil), t.status...)
+}
+
+func (o Objective) check(w *spacecraft.World, a *ground.Archive, run ground.Query) ObjectiveStatus {
+	var probe *spacecraft.SpaceProbe
+	for _, sp := range w.Probes {
+		if sp.Physical.ID == o.Probe {
+			probe = sp
+		}
+	}
+
+	switch o.Kind {
+	case ObjectiveCapture:
+		q := run
+		q.ProbeID, q.To = o.Probe, o.Deadline
+		if a != nil && len(a.Query(q)) >= o.Count {
+			return ObjectiveMet
+		}
+	case ObjectiveReach:
+		if probe == nil {
+			return ObjectivePending
+		}
+		p, s := probe.Physical.Position, probe.Scene()
+		if p.SectorX != s.SectorX || p.SectorY != s.SectorY || p.SectorZ != s.SectorZ ||
+			p.SystemX != s.SystemX || p.SystemY != s.SystemY || p.SystemZ != s.SystemZ {
+			return ObjectivePending
+		}
+		d := math.Sqrt(sq(p.LocalX-o.Target[0]) + sq(p.LocalY-o.Target[1]) + sq(p.LocalZ-o.Target[2]))
+		if d <= o.Radius {
+			return ObjectiveMet
+		}
+	case ObjectiveSurvive:
+		if w.Tick < o.Deadline {
+			return ObjectivePending
+		}
+		if probe != nil && probe.State() == spacecraft.CPURunning {
+			return ObjectiveMet
+		}
+		return ObjectiveFailed
+	}
+	return ObjectivePending
+}
+
+func sq(v float64) float64 { return v * v }
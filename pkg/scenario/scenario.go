@@ -0,0 +1,302 @@
+// Package scenario loads declarative descriptions of a simulation: the
+// galaxy, the scenes and what is in them, the probes, the relay network and
+// ground stations, and the objectives of the mission.
+//
+// A scenario is JSON, e.g.
+//
+//	{
+//	  "name": "Two probes",
+//	  "galaxy": {"seed": 42},
+//	  "scenes": [{"name": "home", "sector": [10000, 25000, 35000], "entities": [
+//	    {"kind": "primitive", "y": -200, "primitive": {"shape": "sphere", "size": 100}}
+//	  ]}],
+//	  "probes": [
+//	    {"id": "Voyager-1", "scene": "home", "local": [0, -200, -400], "look_at": [0, -200, 0]},
+//	    {"id": "Voyager-2", "scene": "home", "local": [500, -200, -400], "os": "basic_probe_os", "hardware_file": "v2.json"}
+//	  ],
+//	  "relays": [{"id": "DSN", "delay": 30}],
+//	  "ground_stations": [{"id": "Earth", "via": ["DSN"]}],
+//	  "objectives": [{"name": "First light", "kind": "capture", "probe": "Voyager-1", "count": 1}]
+//	}
+//
+// Relative paths are relative to the scenario file.
+package scenario
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/spacecraft"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+//go:embed default.json
+var defaultScenario []byte
+
+// Scenario describes how a simulation starts.
+type Scenario struct {
+	Name           string          `json:"name,omitempty"`
+	Galaxy         Galaxy          `json:"galaxy"`
+	Scenes         []Scene         `json:"scenes"`
+	Probes         []Probe         `json:"probes"`
+	Relays         []Relay         `json:"relays,omitempty"`
+	GroundStations []GroundStation `json:"ground_stations,omitempty"` // spacecraft.GroundStationID alone if empty
+	Objectives     []Objective     `json:"objectives,omitempty"`
+}
+
+// Galaxy sets the starfield probes photograph.
+type Galaxy struct {
+	Seed  *int64 `json:"seed,omitempty"`  // the current universe.Seed if nil
+	Stars int    `json:"stars,omitempty"` // universe.DefaultStarCount if 0
+}
+
+// Scene is a universe.LocalScene.
+type Scene struct {
+	Name     string                `json:"name"`
+	Sector   [3]int64              `json:"sector"`
+	System   [3]int64              `json:"system"`
+	Entities []universe.EntitySpec `json:"entities"`
+}
+
+// Probe places a probe in a scene; its position is local to the scene's
+// sector and system. Hardware defaults to the stock loadout, the OS to
+// spacecraft.DefaultOS and the ground station the probe downlinks to to the
+// scenario's first.
+type Probe struct {
+	ID            string                       `json:"id"`
+	Scene         string                       `json:"scene"`
+	Local         [3]float64                   `json:"local"`
+	LookAt        *[3]float64                  `json:"look_at,omitempty"`
+	Hardware      *spacecraft.HardwareManifest `json:"hardware,omitempty"`
+	HardwareFile  string                       `json:"hardware_file,omitempty"`
+	OS            string                       `json:"os,omitempty"`
+	OSFile        string                       `json:"os_file,omitempty"`
+	OSBinary      string                       `json:"os_binary,omitempty"`
+	GroundStation string                       `json:"ground_station,omitempty"`
+}
+
+// Relay is a comms relay node.
+type Relay struct {
+	ID        string `json:"id"`
+	Delay     int    `json:"delay,omitempty"`
+	Bandwidth int    `json:"bandwidth,omitempty"`
+}
+
+// GroundStation is a bus ID on the ground. Its traffic with every probe goes
+// through the relays in Via, nearest the station first, in both directions.
+type GroundStation struct {
+	ID  string   `json:"id"`
+	Via []string `json:"via,omitempty"`
+}
+
+// Default returns the scenario the simulation starts with when given none:
+// Voyager-1 over a mountain range.
+func Default() *Scenario {
+	s, err := Parse(defaultScenario, "")
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Load reads a scenario file.
+func Load(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Load: %w", err)
+	}
+	s, err := Parse(data, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("Load: %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Parse decodes and validates a scenario, resolving relative paths against
+// dir.
+func Parse(data []byte, dir string) (*Scenario, error) {
+	s := new(Scenario)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("Parse: %w", err)
+	}
+	rel := func(p string) string {
+		if p == "" || dir == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	for i := range s.Scenes {
+		for _, e := range s.Scenes[i].Entities {
+			if e.Model != nil {
+				e.Model.Path = rel(e.Model.Path)
+			}
+		}
+	}
+	for i := range s.Probes {
+		p := &s.Probes[i]
+		p.HardwareFile, p.OSFile, p.OSBinary = rel(p.HardwareFile), rel(p.OSFile), rel(p.OSBinary)
+	}
+	if len(s.GroundStations) == 0 {
+		s.GroundStations = []GroundStation{{ID: spacecraft.GroundStationID}}
+	}
+	for i := range s.Probes {
+		if s.Probes[i].GroundStation == "" {
+			s.Probes[i].GroundStation = s.GroundStations[0].ID
+		}
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("Parse: %w", err)
+	}
+	return s, nil
+}
+
+// Validate checks that names are unique and that everything referred to
+// exists. Entities, hardware and OS images are checked by Build.
+func (s *Scenario) Validate() error {
+	ids := make(map[string]string) // bus ID to what it is
+	claim := func(id, what string) error {
+		if id == "" {
+			return fmt.Errorf("Scenario.Validate: %s without an ID", what)
+		}
+		if other, ok := ids[id]; ok {
+			return fmt.Errorf("Scenario.Validate: %s %q has the same ID as a %s", what, id, other)
+		}
+		ids[id] = what
+		return nil
+	}
+
+	scenes := make(map[string]bool)
+	for _, sc := range s.Scenes {
+		if scenes[sc.Name] {
+			return fmt.Errorf("Scenario.Validate: duplicate scene %q", sc.Name)
+		}
+		scenes[sc.Name] = true
+	}
+	for _, p := range s.Probes {
+		if err := claim(p.ID, "probe"); err != nil {
+			return err
+		}
+		if !scenes[p.Scene] {
+			return fmt.Errorf("Scenario.Validate: probe %s is in unknown scene %q", p.ID, p.Scene)
+		}
+		if p.Hardware != nil && p.HardwareFile != "" {
+			return fmt.Errorf("Scenario.Validate: probe %s has both hardware and hardware_file", p.ID)
+		}
+	}
+	for _, r := range s.Relays {
+		if err := claim(r.ID, "relay"); err != nil {
+			return err
+		}
+		if r.Delay < 0 || r.Bandwidth < 0 {
+			return fmt.Errorf("Scenario.Validate: relay %s has a negative delay or bandwidth", r.ID)
+		}
+	}
+	for _, g := range s.GroundStations {
+		if err := claim(g.ID, "ground station"); err != nil {
+			return err
+		}
+		for _, via := range g.Via {
+			if ids[via] != "relay" {
+				return fmt.Errorf("Scenario.Validate: ground station %s goes via unknown relay %q", g.ID, via)
+			}
+		}
+	}
+	for _, p := range s.Probes {
+		if p.GroundStation != "" && ids[p.GroundStation] != "ground station" {
+			return fmt.Errorf("Scenario.Validate: probe %s downlinks to unknown ground station %q", p.ID, p.GroundStation)
+		}
+	}
+	for _, o := range s.Objectives {
+		if err := o.validate(ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Build sets the galaxy and builds the scenes and probes, subscribing the
+// probes to bus. It does not set up the network; see Connect.
+func (s *Scenario) Build(bus *comms.MessageBus) (*spacecraft.World, error) {
+	seed := universe.Seed
+	if s.Galaxy.Seed != nil {
+		seed = *s.Galaxy.Seed
+	}
+	universe.SetGalaxy(seed, s.Galaxy.Stars)
+
+	world := &spacecraft.World{Bus: bus}
+	scenes := make(map[string]*universe.LocalScene)
+	for _, c := range s.Scenes {
+		scene := universe.NewLocalScene(c.Sector[0], c.Sector[1], c.Sector[2], c.System[0], c.System[1], c.System[2])
+		scene.Name = c.Name
+		for i, e := range c.Entities {
+			if err := scene.AddEntitySpec(e); err != nil {
+				return nil, fmt.Errorf("Scenario.Build: scene %q: entity %d: %w", c.Name, i, err)
+			}
+		}
+		scenes[c.Name] = scene
+		world.Scenes = append(world.Scenes, scene)
+	}
+
+	for _, c := range s.Probes {
+		scene := scenes[c.Scene]
+		opts := spacecraft.ProbeOptions{Hardware: c.Hardware, OS: c.OS, OSFile: c.OSFile, GroundStation: c.GroundStation}
+		if c.HardwareFile != "" {
+			hw, err := spacecraft.LoadHardwareManifest(c.HardwareFile)
+			if err != nil {
+				return nil, fmt.Errorf("Scenario.Build: probe %s: %w", c.ID, err)
+			}
+			opts.Hardware = &hw
+		}
+		if c.OSBinary != "" {
+			mc, err := os.ReadFile(c.OSBinary)
+			if err != nil {
+				return nil, fmt.Errorf("Scenario.Build: probe %s: %w", c.ID, err)
+			}
+			opts.OSBinary = mc
+		}
+		pos := universe.NewGalacticPosition(
+			scene.SectorX, scene.SectorY, scene.SectorZ,
+			scene.SystemX, scene.SystemY, scene.SystemZ,
+			c.Local[0], c.Local[1], c.Local[2],
+		)
+		probe, err := spacecraft.NewSpaceProbeWithOptions(c.ID, pos, scene, bus, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Scenario.Build: probe %s: %w", c.ID, err)
+		}
+		if c.LookAt != nil {
+			probe.Physical.PointCamera(si3d.NewVector3(c.LookAt[0], c.LookAt[1], c.LookAt[2]))
+		}
+		world.Probes = append(world.Probes, probe)
+	}
+	return world, nil
+}
+
+// Connect adds the scenario's relays to the world's bus and routes each
+// ground station's traffic with every probe in the world through them.
+// Relays and routes are not saved, so a loaded world needs connecting too.
+func (s *Scenario) Connect(w *spacecraft.World) {
+	for _, r := range s.Relays {
+		w.Bus.AddRelay(r.ID, comms.Link{Delay: r.Delay, Bandwidth: r.Bandwidth})
+	}
+	for _, g := range s.GroundStations {
+		if len(g.Via) == 0 {
+			continue
+		}
+		last := len(g.Via) - 1
+		for _, sp := range w.Probes {
+			id := sp.Physical.ID
+			w.Bus.AddRoute(g.ID, id, g.Via[0])
+			w.Bus.AddRoute(id, g.ID, g.Via[last])
+			for i := 0; i < last; i++ {
+				w.Bus.AddRoute(g.Via[i], id, g.Via[i+1])
+				w.Bus.AddRoute(g.Via[i+1], g.ID, g.Via[i])
+			}
+		}
+	}
+}
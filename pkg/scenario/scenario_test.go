@@ -0,0 +1,258 @@
+package scenario
+
+import (
+	"image"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/ground"
+	"github.com/smasonuk/unknowngalaxy/pkg/spacecraft"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+const twoProbes = `{
+  "galaxy": {"seed": 7, "stars": 1000},
+  "scenes": [
+    {"name": "home", "sector": [1, 2, 3], "entities": [
+      {"kind": "primitive", "y": -200, "primitive": {"shape": "sphere", "size": 100}},
+      {"kind": "model", "model": {"path": "rock.obj"}}
+    ]},
+    {"name": "far", "sector": [4, 5, 6], "system": [1, 0, 0]}
+  ],
+  "probes": [
+    {"id": "A", "scene": "home", "local": [0, -200, -400], "look_at": [0, -200, 0], "os_binary": "a.bin"},
+    {"id": "B", "scene": "far", "local": [10, 0, 0], "os": "basic_probe_os"}
+  ],
+  "relays": [{"id": "DSN", "delay": 2}, {"id": "Mars", "delay": 1}],
+  "ground_stations": [{"id": "Earth", "via": ["DSN", "Mars"]}],
+  "objectives": [
+    {"name": "Approach", "kind": "reach", "probe": "B", "target": [100, 0, 0], "radius": 50},
+    {"name": "Stay alive", "kind": "survive", "probe": "A", "deadline": 3}
+  ]
+}`
+
+const twoScenes = `{
+  "scenes": [
+    {"name": "home", "sector": [1, 2, 3], "entities": [
+      {"kind": "heightmap", "heightmap": {"width": 100, "depth": 100, "color": {"A": 255}, "subdivisions": 2}}
+    ]},
+    {"name": "far", "sector": [4, 5, 6], "system": [1, 0, 0]}
+  ],
+  "probes": [
+    {"id": "A", "scene": "home", "local": [0, -200, -400], "look_at": [0, -200, 0], "os_binary": "a.bin"},
+    {"id": "B", "scene": "far", "local": [10, 0, 0], "os": "basic_probe_os"}
+  ]
+}`
+
+func TestParse_ResolvesPathsAndDefaults(t *testing.T) {
+	s, err := Parse([]byte(twoProbes), "/missions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Probes[0].OSBinary; got != filepath.Join("/missions", "a.bin") {
+		t.Errorf("os_binary = %s", got)
+	}
+	if got := s.Scenes[0].Entities[1].Model.Path; got != filepath.Join("/missions", "rock.obj") {
+		t.Errorf("model path = %s", got)
+	}
+
+	s, err = Parse([]byte(`{"scenes": [{"name": "s"}], "probes": [{"id": "P", "scene": "s"}]}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.GroundStations) != 1 || s.GroundStations[0].ID != spacecraft.GroundStationID {
+		t.Errorf("ground stations = %+v", s.GroundStations)
+	}
+	if s.Probes[0].GroundStation != spacecraft.GroundStationID {
+		t.Errorf("probe downlinks to %q", s.Probes[0].GroundStation)
+	}
+
+	s, err = Parse([]byte(`{"scenes": [{"name": "s"}], "probes": [{"id": "P", "scene": "s"}], "ground_stations": [{"id": "Houston"}]}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	world, err := s.Build(comms.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := world.Probes[0].GroundStation(); got != "Houston" {
+		t.Errorf("probe downlinks to %q, want the scenario's only ground station", got)
+	}
+}
+
+func TestParse_Validates(t *testing.T) {
+	for _, tc := range []struct{ name, json, want string }{
+		{"unknown scene", `{"probes": [{"id": "P", "scene": "nowhere"}]}`, "unknown scene"},
+		{"duplicate ID", `{"scenes": [{"name": "s"}], "probes": [{"id": "Earth", "scene": "s"}]}`, "same ID"},
+		{"duplicate probe", `{"scenes": [{"name": "s"}], "probes": [{"id": "P", "scene": "s"}, {"id": "P", "scene": "s"}]}`, "same ID"},
+		{"duplicate scene", `{"scenes": [{"name": "s"}, {"name": "s"}]}`, "duplicate scene"},
+		{"two hardwares", `{"scenes": [{"name": "s"}], "probes": [{"id": "P", "scene": "s", "hardware": {}, "hardware_file": "hw.json"}]}`, "both"},
+		{"unknown relay", `{"ground_stations": [{"id": "Earth", "via": ["DSN"]}]}`, "unknown relay"},
+		{"unknown ground station", `{"scenes": [{"name": "s"}], "probes": [{"id": "P", "scene": "s", "ground_station": "Houston"}]}`, "unknown ground station"},
+		{"bad objective", `{"objectives": [{"name": "x", "kind": "capture"}]}`, "count"},
+		{"objective probe", `{"objectives": [{"name": "x", "kind": "survive", "probe": "P", "deadline": 1}]}`, "unknown probe"},
+	} {
+		if _, err := Parse([]byte(tc.json), ""); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestScenario_Build(t *testing.T) {
+	s, err := Parse([]byte(twoScenes), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Probes[0].OSBinary = ""
+	world, err := s.Build(comms.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(world.Scenes) != 2 || len(world.Probes) != 2 {
+		t.Fatalf("world has %d scenes and %d probes", len(world.Scenes), len(world.Probes))
+	}
+	a, b := world.Probes[0], world.Probes[1]
+	if a.Scene() != world.Scenes[0] || b.Scene() != world.Scenes[1] {
+		t.Error("probes are not in their scenes")
+	}
+	if a.Physical.Position.SectorZ != 3 || a.Physical.Position.LocalZ != -400 || !a.Physical.Pointed {
+		t.Errorf("A = %+v", a.Physical)
+	}
+	if b.Physical.Position.SystemX != 1 || b.Physical.Pointed {
+		t.Errorf("B = %+v", b.Physical)
+	}
+	if len(world.Scenes[0].Entities) != 1 {
+		t.Errorf("home has %d entities", len(world.Scenes[0].Entities))
+	}
+
+	s.Probes[0].OSBinary = "does-not-exist.bin"
+	if _, err := s.Build(comms.NewMessageBus()); err == nil {
+		t.Error("expected an error for a missing OS image")
+	}
+}
+
+func TestScenario_BuildAndConnect(t *testing.T) {
+	s := Default()
+	bus := comms.NewMessageBus()
+	world, err := s.Build(bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(world.Scenes) != 1 || len(world.Probes) != 1 {
+		t.Fatalf("default world has %d scenes and %d probes", len(world.Scenes), len(world.Probes))
+	}
+	p := world.Probes[0]
+	if p.Physical.ID != "Voyager-1" || p.Physical.Position.SectorX != 10000 || p.Physical.Position.LocalZ != -400 || !p.Physical.Pointed {
+		t.Errorf("Voyager-1 = %+v", p.Physical)
+	}
+	if hw := p.Hardware(); len(hw.Peripherals) != 5 || hw.Peripherals[4].Name != "TELESCOP" {
+		t.Errorf("hardware = %+v", hw)
+	}
+	if universe.Seed != 1772054134190328000 {
+		t.Errorf("seed = %d", universe.Seed)
+	}
+
+	s.Relays = []Relay{{ID: "DSN", Delay: 2}, {ID: "Mars", Delay: 1}}
+	s.GroundStations = []GroundStation{{ID: "Earth", Via: []string{"DSN", "Mars"}}}
+	s.Connect(world)
+	n := bus.Network()
+	if n.Routes["Earth"]["Voyager-1"] != "DSN" || n.Routes["DSN"]["Voyager-1"] != "Mars" {
+		t.Errorf("uplink routes = %v", n.Routes)
+	}
+	if n.Routes["Voyager-1"]["Earth"] != "Mars" || n.Routes["Mars"]["Earth"] != "DSN" {
+		t.Errorf("downlink routes = %v", n.Routes)
+	}
+	if n.Relays["DSN"].Delay != 2 {
+		t.Errorf("relays = %v", n.Relays)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	s, err := Parse([]byte(`{
+	  "scenes": [{"name": "s", "sector": [1, 1, 1]}],
+	  "probes": [{"id": "P", "scene": "s", "local": [0, 0, 0]}, {"id": "Q", "scene": "s"}],
+	  "objectives": [
+	    {"name": "Approach", "kind": "reach", "probe": "P", "target": [100, 0, 0], "radius": 10, "deadline": 5},
+	    {"name": "Alive", "kind": "survive", "probe": "P", "deadline": 2},
+	    {"name": "Photo", "kind": "capture", "count": 1, "deadline": 1}
+	  ]
+	}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	world, err := s.Build(comms.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := s.NewTracker("run-1", 0)
+	if d := tr.Update(world, nil); len(d) != 0 {
+		t.Errorf("decided at tick 0: %+v", d)
+	}
+
+	world.Tick = 2
+	world.Probes[0].Physical.Position.Move(95, 0, 0)
+	d := tr.Update(world, nil)
+	want := map[string]ObjectiveStatus{"Approach": ObjectiveMet, "Photo": ObjectiveFailed}
+	if world.Probes[0].State() == spacecraft.CPURunning {
+		want["Alive"] = ObjectiveMet
+	} else {
+		want["Alive"] = ObjectiveFailed
+	}
+	if len(d) != len(want) {
+		t.Fatalf("decisions = %+v", d)
+	}
+	for _, dec := range d {
+		if want[dec.Objective.Name] != dec.Status {
+			t.Errorf("%s: %s, want %s", dec.Objective.Name, dec.Status, want[dec.Objective.Name])
+		}
+	}
+
+	world.Probes[0].Physical.Position.Move(1000, 0, 0)
+	if d := tr.Update(world, nil); len(d) != 0 {
+		t.Errorf("decided objectives changed: %+v", d)
+	}
+	if st := tr.Status(); st[0] != ObjectiveMet {
+		t.Errorf("status = %v", st)
+	}
+}
+
+func TestTracker_CountsOnlyThisRunsCaptures(t *testing.T) {
+	s, err := Parse([]byte(`{
+	  "scenes": [{"name": "s"}],
+	  "probes": [{"id": "P", "scene": "s"}],
+	  "objectives": [{"name": "Photo", "kind": "capture", "probe": "P", "count": 1}]
+	}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	world, err := s.Build(comms.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := ground.OpenArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	store := func(run string, tick uint64) {
+		t.Helper()
+		if _, err := a.Store(ground.Capture{ProbeID: "P", SimTime: tick, RunID: run}, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	world.Tick = 10
+	tr := s.NewTracker("run-2", 10)
+	store("run-1", 12) // an earlier run resumed from the same save
+	store("run-2", 9)  // captured before this run started
+	if d := tr.Update(world, a); len(d) != 0 {
+		t.Fatalf("decided on other runs' captures: %+v", d)
+	}
+	store("run-2", 11)
+	if d := tr.Update(world, a); len(d) != 1 || d[0].Status != ObjectiveMet {
+		t.Errorf("decisions = %+v, want Photo met", d)
+	}
+}
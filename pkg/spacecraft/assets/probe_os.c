@@ -1,12 +1,170 @@
 #include <video.c>
 #include <vfs.c>
 #include <stdio.c>
+#include "rle.c"
 
 #define INBOX_BUFFER 0x8000
 
 int* INT_MASK = 0xFF09;
 int* MMIO_SLOT_BASE = 0xFE00;
 char* COMMAND_TAKE_PICTURE = "TAKE_PICTURE";
+char* COMMAND_CAPTURE_GRAY = "CAPTURE_GRAY8";
+char* COMMAND_CAPTURE_RGB565 = "CAPTURE_RGB565";
+char* COMMAND_CAPTURE_MONO = "CAPTURE_MONO1";
+char* COMMAND_CAPTURE_PROGRESSIVE = "CAPTURE_PROGRESSIVE";
+char* COMMAND_ABORT_DOWNLINK = "ABORT_DOWNLINK";
+char* COMMAND_PROFILE_DEFAULT = "PROFILE_DEFAULT";
+char* COMMAND_PROFILE_VIDICON = "PROFILE_VIDICON";
+char* COMMAND_PROFILE_CCD = "PROFILE_CCD";
+char* COMMAND_AUTO_EXPOSURE = "AUTO_EXPOSURE";
+char* COMMAND_MANUAL_EXPOSURE = "MANUAL_EXPOSURE";
+char* COMMAND_CAPTURE_HDR = "CAPTURE_HDR";
+char* COMMAND_CAPTURE_TELESCOPE = "CAPTURE_TELESCOPE";
+
+// Imager register offsets and image formats (see spacecraft/imager_hw.go).
+#define IMAGER_CMD 0
+#define IMAGER_SELECT 2
+#define IMAGER_VALUE 4
+#define IMAGER_REG_FORMAT 0
+#define IMAGER_REG_FRAGMENT 2
+#define IMAGER_REG_PROFILE 4
+#define IMAGER_REG_AUTO_EXPOSE 7
+#define IMAGER_REG_HDR 8
+#define IMAGER_REG_REPLY_TO 12
+#define HDR_BRACKETS 3
+#define IMAGER_CMD_CAPTURE 1
+#define IMAGER_CMD_ABORT 2
+#define PROGRESSIVE_FRAGMENT_SIZE 1024
+#define FORMAT_RGB332 1
+#define FORMAT_GRAY8 2
+#define FORMAT_RGB565 3
+#define FORMAT_MONO1 5
+#define PROFILE_DEFAULT 0
+#define PROFILE_VIDICON 1
+#define PROFILE_CCD 2
+
+#define BOOT_CONFIRM 0xB007
+
+// Returns the address of a register of the named imager instrument, or 0 if
+// it is not mounted.
+int* instrument_register(char* name, int offset) {
+    int* slot_ptr = find_peripheral(name);
+    if (slot_ptr == 0) {
+        print("Error: Instrument not found: ");
+        print(name);
+        print("\n");
+        return 0;
+    }
+    int base = (int)slot_ptr;
+    return base + offset;
+}
+
+// Returns the address of an IMAGER register, or 0 if no imager is mounted.
+int* imager_register(int offset) {
+    return instrument_register("IMAGER", offset);
+}
+
+// Makes the named instrument send the frames it captures next to sender.
+void reply_to(char* name, char* sender) {
+    int* select = instrument_register(name, IMAGER_SELECT);
+    if (select == 0) {
+        return;
+    }
+    int* value = instrument_register(name, IMAGER_VALUE);
+
+    *select = IMAGER_REG_REPLY_TO;
+    *value = (int)sender;
+}
+
+// Captures a frame for sender with the narrow-angle telescope, if one is
+// mounted.
+void capture_telescope(char* sender) {
+    int* cmd = instrument_register("TELESCOP", IMAGER_CMD);
+    if (cmd != 0) {
+        reply_to("TELESCOP", sender);
+        *cmd = IMAGER_CMD_CAPTURE;
+    }
+}
+
+// Captures a frame for sender as progressive fragments, which the imager
+// sends a fragment at a time so the ground sees a preview early.
+void capture_progressive(char* sender) {
+    int* select = imager_register(IMAGER_SELECT);
+    if (select == 0) {
+        return;
+    }
+    int* value = imager_register(IMAGER_VALUE);
+    int* cmd = imager_register(IMAGER_CMD);
+
+    reply_to("IMAGER", sender);
+    *select = IMAGER_REG_FRAGMENT;
+    *value = PROGRESSIVE_FRAGMENT_SIZE;
+    *cmd = IMAGER_CMD_CAPTURE;
+    *value = 0;
+}
+
+void abort_downlink() {
+    int* cmd = imager_register(IMAGER_CMD);
+    if (cmd != 0) {
+        *cmd = IMAGER_CMD_ABORT;
+    }
+}
+
+// Writes an imager configuration register.
+void set_imager_register(int reg, int val) {
+    int* select = imager_register(IMAGER_SELECT);
+    if (select == 0) {
+        return;
+    }
+    int* value = imager_register(IMAGER_VALUE);
+
+    *select = reg;
+    *value = val;
+}
+
+// Captures one frame for sender merged from HDR_BRACKETS exposures, then
+// returns the imager to single exposures.
+void capture_hdr(char* sender) {
+    int* cmd = imager_register(IMAGER_CMD);
+    if (cmd == 0) {
+        return;
+    }
+    reply_to("IMAGER", sender);
+    set_imager_register(IMAGER_REG_HDR, HDR_BRACKETS);
+    *cmd = IMAGER_CMD_CAPTURE;
+    set_imager_register(IMAGER_REG_HDR, 0);
+}
+
+// Switches the imager to another camera model (see universe.CameraProfiles).
+void set_camera_profile(int profile) {
+    int* select = imager_register(IMAGER_SELECT);
+    if (select == 0) {
+        return;
+    }
+    int* value = imager_register(IMAGER_VALUE);
+
+    *select = IMAGER_REG_PROFILE;
+    *value = profile;
+    if (*value != profile) {
+        print("Error: camera profile rejected\n");
+    }
+}
+
+// Captures a frame with the IMAGER peripheral in the given format. The imager
+// downlinks the encoded frame to sender itself.
+void capture_image(int format, char* sender) {
+    int* select = imager_register(IMAGER_SELECT);
+    if (select == 0) {
+        return;
+    }
+    int* value = imager_register(IMAGER_VALUE);
+    int* cmd = imager_register(IMAGER_CMD);
+
+    reply_to("IMAGER", sender);
+    *select = IMAGER_REG_FORMAT;
+    *value = format;
+    *cmd = IMAGER_CMD_CAPTURE;
+}
 
 void isr() {
     int pending = *INT_MASK;
@@ -54,7 +212,31 @@ void isr() {
                         print("\n");
 
                         if (strcmp(COMMAND_TAKE_PICTURE, (char*)buffer) == 0  ){
-                            take_picture_and_send(sender_buffer);
+                            capture_image(FORMAT_RGB332, sender_buffer);
+                        } else if (strcmp(COMMAND_CAPTURE_GRAY, (char*)buffer) == 0) {
+                            capture_image(FORMAT_GRAY8, sender_buffer);
+                        } else if (strcmp(COMMAND_CAPTURE_RGB565, (char*)buffer) == 0) {
+                            capture_image(FORMAT_RGB565, sender_buffer);
+                        } else if (strcmp(COMMAND_CAPTURE_MONO, (char*)buffer) == 0) {
+                            capture_image(FORMAT_MONO1, sender_buffer);
+                        } else if (strcmp(COMMAND_CAPTURE_PROGRESSIVE, (char*)buffer) == 0) {
+                            capture_progressive(sender_buffer);
+                        } else if (strcmp(COMMAND_ABORT_DOWNLINK, (char*)buffer) == 0) {
+                            abort_downlink();
+                        } else if (strcmp(COMMAND_PROFILE_DEFAULT, (char*)buffer) == 0) {
+                            set_camera_profile(PROFILE_DEFAULT);
+                        } else if (strcmp(COMMAND_PROFILE_VIDICON, (char*)buffer) == 0) {
+                            set_camera_profile(PROFILE_VIDICON);
+                        } else if (strcmp(COMMAND_PROFILE_CCD, (char*)buffer) == 0) {
+                            set_camera_profile(PROFILE_CCD);
+                        } else if (strcmp(COMMAND_AUTO_EXPOSURE, (char*)buffer) == 0) {
+                            set_imager_register(IMAGER_REG_AUTO_EXPOSE, 1);
+                        } else if (strcmp(COMMAND_MANUAL_EXPOSURE, (char*)buffer) == 0) {
+                            set_imager_register(IMAGER_REG_AUTO_EXPOSE, 0);
+                        } else if (strcmp(COMMAND_CAPTURE_HDR, (char*)buffer) == 0) {
+                            capture_hdr(sender_buffer);
+                        } else if (strcmp(COMMAND_CAPTURE_TELESCOPE, (char*)buffer) == 0) {
+                            capture_telescope(sender_buffer);
                         } else {
                             print("Unknown message:");
                             print(buffer);
@@ -84,8 +266,18 @@ void isr() {
     }
 }
 
+// Tells the loader this software booted, so an uplinked image is kept
+// rather than rolled back.
+void confirm_boot() {
+    int* ctl = find_peripheral("BOOTCTL");
+    if (ctl != 0) {
+        *ctl = BOOT_CONFIRM;
+    }
+}
+
 int main() {
     print("Voyager-1 OS starting...\n");
+    confirm_boot();
     
     enable_interrupts();
     print("Interrupts enabled. Waiting for messages...\n");
@@ -0,0 +1,93 @@
+// PackBits-style run-length coding, byte-compatible with comms.EncodeRLE.
+// Each chunk starts with a control byte n:
+//   n < 128:  copy the next n+1 bytes literally
+//   n >= 128: repeat the next byte n-126 times
+
+#define RLE_MAX_LITERAL 128
+#define RLE_MAX_RUN 129
+
+// Compresses len bytes of src into dst and returns the compressed length.
+// dst must hold at least len + len / 128 + 1 bytes.
+int rle_encode(char* src, int len, char* dst) {
+    int i = 0;
+    int out = 0;
+    while (i < len) {
+        int run = 1;
+        int scanning = 1;
+        while (scanning) {
+            if (i + run >= len || run >= RLE_MAX_RUN) {
+                scanning = 0;
+            } else if (src[i + run] != src[i]) {
+                scanning = 0;
+            } else {
+                run = run + 1;
+            }
+        }
+
+        if (run >= 2) {
+            dst[out] = run + 126;
+            dst[out + 1] = src[i];
+            out = out + 2;
+            i = i + run;
+        } else {
+            int start = i;
+            int collecting = 1;
+            while (collecting) {
+                if (i >= len || i - start >= RLE_MAX_LITERAL) {
+                    collecting = 0;
+                } else if (i + 1 < len && src[i + 1] == src[i]) {
+                    collecting = 0;
+                } else {
+                    i = i + 1;
+                }
+            }
+            int count = i - start;
+            dst[out] = count - 1;
+            out = out + 1;
+            for (int j = 0; j < count; j++) {
+                dst[out] = src[start + j];
+                out = out + 1;
+            }
+        }
+    }
+    return out;
+}
+
+// Expands len bytes of src into dst, writing at most max bytes.
+// Returns the expanded length, or -1 if the data is corrupt.
+int rle_decode(char* src, int len, char* dst, int max) {
+    int i = 0;
+    int out = 0;
+    while (i < len) {
+        int n = src[i] & 255;
+        i = i + 1;
+        if (n < 128) {
+            int count = n + 1;
+            if (i + count > len) {
+                return -1;
+            }
+            if (out + count > max) {
+                return -1;
+            }
+            for (int j = 0; j < count; j++) {
+                dst[out] = src[i + j];
+                out = out + 1;
+            }
+            i = i + count;
+        } else {
+            int count = n - 126;
+            if (i >= len) {
+                return -1;
+            }
+            if (out + count > max) {
+                return -1;
+            }
+            for (int j = 0; j < count; j++) {
+                dst[out] = src[i];
+                out = out + 1;
+            }
+            i = i + 1;
+        }
+    }
+    return out;
+}
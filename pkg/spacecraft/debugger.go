@@ -0,0 +1,309 @@
+package spacecraft
+
+import (
+	"fmt"
+	"sort"
+
+	"gocpu/pkg/cpu"
+)
+
+// Guest address map used by watchpoints: peripheral slot n's registers are at
+// MMIOBase + n*MMIOSlotSize, and the interrupt mask register is at
+// IntMaskAddr.
+const (
+	MMIOBase     = 0xFE00
+	MMIOSlotSize = 0x10
+	IntMaskAddr  = 0xFF09
+)
+
+// WatchKind selects the accesses a Watchpoint stops on.
+type WatchKind uint8
+
+const (
+	WatchRead WatchKind = 1 << iota
+	WatchWrite
+	WatchAccess = WatchRead | WatchWrite
+)
+
+func (k WatchKind) String() string {
+	switch k {
+	case WatchRead:
+		return "r"
+	case WatchWrite:
+		return "w"
+	case WatchAccess:
+		return "rw"
+	}
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+// Watchpoint stops execution when the guest accesses an address in Lo-Hi
+// inclusive. Reads and writes are both seen in peripheral slot space; in RAM
+// and at IntMaskAddr only writes that change the value are seen.
+type Watchpoint struct {
+	Lo, Hi uint16
+	Kind   WatchKind
+}
+
+func (w Watchpoint) covers(addr uint16) bool {
+	return addr >= w.Lo && addr <= w.Hi
+}
+
+// Access is a guest memory access caught by a watchpoint.
+type Access struct {
+	Addr  uint16
+	Write bool
+	Value uint16
+}
+
+func (a Access) String() string {
+	if a.Write {
+		return fmt.Sprintf("write %04x to %04x", a.Value, a.Addr)
+	}
+	return fmt.Sprintf("read %04x from %04x", a.Value, a.Addr)
+}
+
+// StopReason says why the debugger returned control.
+type StopReason int
+
+const (
+	StopStep       StopReason = iota // one instruction was executed
+	StopBreakpoint                   // the PC reached a breakpoint
+	StopWatchpoint                   // a watched address was accessed
+	StopLimit                        // Continue ran out of cycles
+	StopNotRunning                   // the probe's State is not CPURunning
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopStep:
+		return "step"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopWatchpoint:
+		return "watchpoint"
+	case StopLimit:
+		return "cycle limit"
+	case StopNotRunning:
+		return "not running"
+	}
+	return fmt.Sprintf("reason(%d)", int(r))
+}
+
+// StopEvent describes where and why execution stopped.
+type StopEvent struct {
+	Reason StopReason
+	PC     uint16
+	Cycles int     // instructions executed before stopping
+	Access *Access // the access that hit a watchpoint
+}
+
+func (e StopEvent) String() string {
+	s := fmt.Sprintf("%s at %04x", e.Reason, e.PC)
+	if e.Access != nil {
+		s += ": " + e.Access.String()
+	}
+	return fmt.Sprintf("%s after %d cycles", s, e.Cycles)
+}
+
+// Debugger controls a SpaceProbe's execution one instruction at a time. It
+// drives the probe through Tick, so uplinks and boot supervision behave as
+// they do in the simulation; the simulation must not tick the probe while a
+// debugger is attached.
+//
+// Breakpoints are by address only. gocpu's compiler.Compile returns the
+// machine code and an assembly listing but no map from C source lines to
+// addresses, so breaking on a line of probe_os.c is left until it does.
+type Debugger struct {
+	sp          *SpaceProbe
+	breakpoints map[uint16]bool
+	watchpoints []Watchpoint
+	hits        []Access
+}
+
+// NewDebugger attaches a debugger to sp, replacing any already attached.
+func NewDebugger(sp *SpaceProbe) *Debugger {
+	d := &Debugger{sp: sp, breakpoints: make(map[uint16]bool)}
+	sp.debugger = d
+	return d
+}
+
+// Detach stops the debugger observing the probe.
+func (d *Debugger) Detach() {
+	if d.sp.debugger == d {
+		d.sp.debugger = nil
+	}
+}
+
+// Break sets a breakpoint at addr.
+func (d *Debugger) Break(addr uint16) {
+	d.breakpoints[addr] = true
+}
+
+// Clear removes the breakpoint at addr.
+func (d *Debugger) Clear(addr uint16) {
+	delete(d.breakpoints, addr)
+}
+
+// Breakpoints returns the breakpoint addresses in order.
+func (d *Debugger) Breakpoints() []uint16 {
+	out := make([]uint16, 0, len(d.breakpoints))
+	for a := range d.breakpoints {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// Watch adds a watchpoint.
+func (d *Debugger) Watch(w Watchpoint) error {
+	if w.Hi < w.Lo || w.Kind&WatchAccess == 0 || w.Kind&^WatchAccess != 0 {
+		return fmt.Errorf("Debugger.Watch: bad watchpoint %04x-%04x %s", w.Lo, w.Hi, w.Kind)
+	}
+	d.watchpoints = append(d.watchpoints, w)
+	return nil
+}
+
+// Watchpoints returns the watchpoints in the order they were added.
+func (d *Debugger) Watchpoints() []Watchpoint {
+	return append([]Watchpoint(nil), d.watchpoints...)
+}
+
+// ClearWatchpoints removes every watchpoint.
+func (d *Debugger) ClearWatchpoints() {
+	d.watchpoints = nil
+}
+
+// Registers returns the CPU registers.
+func (d *Debugger) Registers() Registers {
+	return vmRegisters(d.sp.VM)
+}
+
+// Memory returns a copy of n bytes of RAM from addr. Peripheral registers
+// are not read, as reading some has side effects.
+func (d *Debugger) Memory(addr uint16, n int) []byte {
+	mem := d.sp.VM.Memory[:]
+	end := min(int(addr)+max(n, 0), len(mem))
+	return append([]byte(nil), mem[addr:end]...)
+}
+
+// Step executes one instruction.
+func (d *Debugger) Step() StopEvent {
+	if d.sp.State() != CPURunning {
+		return d.event(StopNotRunning, 0, nil)
+	}
+	before := d.snapshot()
+	d.hits = d.hits[:0]
+	d.sp.Tick(1)
+	if hit := d.changed(before); hit != nil {
+		d.hits = append(d.hits, *hit)
+	}
+	if len(d.hits) > 0 {
+		hit := d.hits[0]
+		return d.event(StopWatchpoint, 1, &hit)
+	}
+	return d.event(StopStep, 1, nil)
+}
+
+// Continue runs until a breakpoint or watchpoint is hit, the probe stops
+// running, or maxCycles instructions have executed. A breakpoint at the
+// current PC does not stop it before the first instruction.
+func (d *Debugger) Continue(maxCycles int) StopEvent {
+	for i := 0; i < maxCycles; i++ {
+		if i > 0 && d.breakpoints[vmPC(d.sp.VM)] {
+			return d.event(StopBreakpoint, i, nil)
+		}
+		if ev := d.Step(); ev.Reason != StopStep {
+			ev.Cycles += i
+			return ev
+		}
+	}
+	return d.event(StopLimit, maxCycles, nil)
+}
+
+func (d *Debugger) event(reason StopReason, cycles int, a *Access) StopEvent {
+	return StopEvent{Reason: reason, PC: vmPC(d.sp.VM), Cycles: cycles, Access: a}
+}
+
+// watching reports whether a watchpoint of kind covers addr.
+func (d *Debugger) watching(addr uint16, kind WatchKind) bool {
+	for _, w := range d.watchpoints {
+		if w.Kind&kind != 0 && w.covers(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// mmio records a guest access to a peripheral register.
+func (d *Debugger) mmio(slot uint8, offset uint16, write bool, value uint16) {
+	addr := MMIOBase + uint16(slot)*MMIOSlotSize + offset
+	kind := WatchRead
+	if write {
+		kind = WatchWrite
+	}
+	if d.watching(addr, kind) {
+		d.hits = append(d.hits, Access{Addr: addr, Write: write, Value: value})
+	}
+}
+
+// memState holds the watched values that can only be compared, not trapped.
+type memState struct {
+	ram     map[uint16]byte
+	intMask uint16
+}
+
+func (d *Debugger) snapshot() memState {
+	s := memState{ram: make(map[uint16]byte), intMask: d.sp.VM.PeripheralIntMask}
+	for _, w := range d.watchpoints {
+		if w.Kind&WatchWrite == 0 {
+			continue
+		}
+		for a := int(w.Lo); a <= int(w.Hi) && a < MMIOBase; a++ {
+			s.ram[uint16(a)] = d.sp.VM.Memory[a]
+		}
+	}
+	return s
+}
+
+// changed returns the first watched RAM byte or interrupt mask write that
+// altered its value since before.
+func (d *Debugger) changed(before memState) *Access {
+	vm := d.sp.VM
+	if vm.PeripheralIntMask != before.intMask && d.watching(IntMaskAddr, WatchWrite) {
+		return &Access{Addr: IntMaskAddr, Write: true, Value: vm.PeripheralIntMask}
+	}
+	addrs := make([]int, 0, len(before.ram))
+	for a := range before.ram {
+		addrs = append(addrs, int(a))
+	}
+	sort.Ints(addrs)
+	for _, a := range addrs {
+		if v := vm.Memory[a]; v != before.ram[uint16(a)] {
+			return &Access{Addr: uint16(a), Write: true, Value: uint16(v)}
+		}
+	}
+	return nil
+}
+
+// mmioTap reports a peripheral's register accesses to the probe's debugger.
+type mmioTap struct {
+	cpu.Peripheral
+	sp   *SpaceProbe
+	slot uint8
+}
+
+func (t *mmioTap) Read16(offset uint16) uint16 {
+	v := t.Peripheral.Read16(offset)
+	if d := t.sp.debugger; d != nil {
+		d.mmio(t.slot, offset, false, v)
+	}
+	return v
+}
+
+func (t *mmioTap) Write16(offset uint16, val uint16) {
+	if d := t.sp.debugger; d != nil {
+		d.mmio(t.slot, offset, true, val)
+	}
+	t.Peripheral.Write16(offset, val)
+}
@@ -0,0 +1,111 @@
+package spacecraft
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+func newDebugProbe(t *testing.T) *SpaceProbe {
+	t.Helper()
+	bus := comms.NewMessageBus()
+	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
+	scene := universe.NewLocalScene(0, 0, 0, 0, 0, 0)
+	probe, err := NewSpaceProbeWithOptions("Probe1", pos, scene, bus, ProbeOptions{OSBinary: []byte{0x11, 0x22, 0x33, 0x44}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return probe
+}
+
+func TestDebugger_Breakpoints(t *testing.T) {
+	d := NewDebugger(newDebugProbe(t))
+	d.Break(0x0004)
+	d.Break(0x0100)
+	d.Break(0x0002)
+	if got := d.Breakpoints(); len(got) != 3 || got[0] != 0x0002 || got[1] != 0x0004 || got[2] != 0x0100 {
+		t.Errorf("Breakpoints() = %04x", got)
+	}
+	d.Clear(0x0004)
+	if got := d.Breakpoints(); len(got) != 2 {
+		t.Errorf("after Clear, Breakpoints() = %04x", got)
+	}
+}
+
+func TestDebugger_MMIOWatchpoint(t *testing.T) {
+	probe := newDebugProbe(t)
+	d := NewDebugger(probe)
+	bootctl := uint16(MMIOBase + BootControlSlot*MMIOSlotSize)
+	if err := d.Watch(Watchpoint{Lo: bootctl, Hi: bootctl + MMIOSlotSize - 1, Kind: WatchWrite}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tap sits between the VM and every mounted peripheral.
+	tap := &mmioTap{Peripheral: &BootControlPeripheral{fw: probe.software}, sp: probe, slot: BootControlSlot}
+	tap.Read16(0x02)
+	if len(d.hits) != 0 {
+		t.Errorf("read hit a write watchpoint: %+v", d.hits)
+	}
+	tap.Write16(0x00, BootConfirmMagic)
+	if len(d.hits) != 1 || d.hits[0] != (Access{Addr: bootctl, Write: true, Value: BootConfirmMagic}) {
+		t.Errorf("hits = %+v", d.hits)
+	}
+	if !probe.software.confirmed {
+		t.Error("write did not reach the peripheral")
+	}
+
+	d.Detach()
+	tap.Write16(0x00, BootConfirmMagic)
+	if len(d.hits) != 1 {
+		t.Error("detached debugger still saw accesses")
+	}
+}
+
+func TestDebugger_MemoryWatchpoint(t *testing.T) {
+	probe := newDebugProbe(t)
+	d := NewDebugger(probe)
+	d.Watch(Watchpoint{Lo: 0x8000, Hi: 0x8003, Kind: WatchWrite})
+	d.Watch(Watchpoint{Lo: IntMaskAddr, Hi: IntMaskAddr, Kind: WatchAccess})
+
+	before := d.snapshot()
+	probe.VM.Memory[0x8002] = 0x5A
+	if hit := d.changed(before); hit == nil || *hit != (Access{Addr: 0x8002, Write: true, Value: 0x5A}) {
+		t.Errorf("RAM write: hit = %+v", hit)
+	}
+
+	before = d.snapshot()
+	probe.VM.PeripheralIntMask |= 1 << 3
+	if hit := d.changed(before); hit == nil || hit.Addr != IntMaskAddr {
+		t.Errorf("interrupt mask write: hit = %+v", hit)
+	}
+
+	if err := d.Watch(Watchpoint{Lo: 2, Hi: 1, Kind: WatchRead}); err == nil {
+		t.Error("expected an error for an empty range")
+	}
+	if err := d.Watch(Watchpoint{Lo: 1, Hi: 2}); err == nil {
+		t.Error("expected an error for a watchpoint without a kind")
+	}
+}
+
+func TestDebugger_Inspect(t *testing.T) {
+	probe := newDebugProbe(t)
+	d := NewDebugger(probe)
+
+	if got := d.Memory(0, 4); !bytes.Equal(got, []byte{0x11, 0x22, 0x33, 0x44}) {
+		t.Errorf("Memory(0, 4) = % x", got)
+	}
+	if got := d.Memory(0xFFFE, 8); len(got) != 2 {
+		t.Errorf("Memory past the end returned %d bytes", len(got))
+	}
+	restoreVM(probe.VM, Registers{PC: 0x1234}, false, 0)
+	if r := d.Registers(); r.PC != 0x1234 {
+		t.Errorf("PC = %04x", r.PC)
+	}
+
+	restoreVM(probe.VM, d.Registers(), true, 0)
+	if ev := d.Continue(100); ev.Reason != StopNotRunning || ev.Cycles != 0 {
+		t.Errorf("Continue on a halted probe = %v", ev)
+	}
+}
@@ -0,0 +1,139 @@
+package spacecraft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"testing"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+	"gocpu/pkg/cpu"
+)
+
+// snapshotRGB332 renders a real starfield capture and packs it as RGB332.
+func snapshotRGB332(t *testing.T) []byte {
+	t.Helper()
+	pos := si3d.NewVector3(10000, 25000, 35000)
+	cam := si3d.NewCamera(pos.X, pos.Y, pos.Z, 0, 0, 0)
+	cam.LookAt(si3d.NewVector3(0, 0, 0), si3d.NewVector3(0, 1, 0))
+	img := universe.GalaxyStars.TakeProbeSnapshot(cam, pos, 128, 128, 80000.0, universe.Seed)
+
+	frame, err := comms.EncodeImage(img, comms.FormatRGB332, comms.EncodeOptions{})
+	if err != nil {
+		t.Fatalf("EncodeImage: %v", err)
+	}
+	_, pixels, err := comms.DecodeImageHeader(frame)
+	if err != nil {
+		t.Fatalf("DecodeImageHeader: %v", err)
+	}
+	return pixels
+}
+
+func TestRLE_RoundTripProbeSnapshot(t *testing.T) {
+	pixels := snapshotRGB332(t)
+
+	packed := comms.EncodeRLE(pixels)
+	unpacked, err := comms.DecodeRLE(packed, len(pixels))
+	if err != nil {
+		t.Fatalf("DecodeRLE: %v", err)
+	}
+	if !bytes.Equal(unpacked, pixels) {
+		t.Fatal("RLE round trip changed the snapshot")
+	}
+	if len(packed) >= len(pixels) {
+		t.Errorf("snapshot compressed to %d bytes, raw is %d", len(packed), len(pixels))
+	}
+	t.Logf("snapshot: %d bytes raw, %d bytes RLE", len(pixels), len(packed))
+}
+
+// guestRLEProgram compresses the %d bytes at guestRLEInput into
+// guestRLEOutput with rle.c, stores the compressed length at guestRLEResult
+// and then sets guestRLEDone.
+const guestRLEProgram = `#include "rle.c"
+
+int main() {
+    char* src = 0x9000;
+    char* dst = 0xA000;
+    int* result = 0x8F00;
+    int* done = 0x8F02;
+    *result = rle_encode(src, %d, dst);
+    *done = 1;
+    while (1) {
+    }
+    return 0;
+}
+`
+
+const (
+	guestRLEInput  = 0x9000
+	guestRLEOutput = 0xA000
+	guestRLEResult = 0x8F00
+	guestRLEDone   = 0x8F02
+	guestRLESteps  = 5000000
+)
+
+func TestGuestRLE_EncodesInVM(t *testing.T) {
+	// Long runs, a stretch with no runs at all and real image data.
+	input := bytes.Repeat([]byte{0}, 300)
+	for i := 0; i < 256; i++ {
+		input = append(input, byte(i))
+	}
+	input = append(input, snapshotRGB332(t)[:1024]...)
+
+	mc, err := compileOS(fmt.Sprintf(guestRLEProgram, len(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := cpu.NewCPU("Probe1")
+	copy(vm.Memory[:], mc)
+	copy(vm.Memory[guestRLEInput:], input)
+	for i := 0; vm.Memory[guestRLEDone] == 0; i++ {
+		if i == guestRLESteps {
+			t.Fatalf("guest encoder did not finish in %d steps", guestRLESteps)
+		}
+		vm.Step()
+	}
+
+	n := int(binary.LittleEndian.Uint16(vm.Memory[guestRLEResult:]))
+	packed := vm.Memory[guestRLEOutput : guestRLEOutput+n]
+	unpacked, err := comms.DecodeRLE(packed, len(input))
+	if err != nil {
+		t.Fatalf("DecodeRLE of guest output: %v", err)
+	}
+	if !bytes.Equal(unpacked, input) {
+		t.Error("guest RLE output does not decode to its input")
+	}
+	if want := comms.EncodeRLE(input); !bytes.Equal(packed, want) {
+		t.Errorf("guest encoded %d bytes to %d, comms.EncodeRLE to %d", len(input), n, len(want))
+	}
+}
+
+func TestImagerPeripheral_CompressedDownlink(t *testing.T) {
+	pos := si3d.NewVector3(10000, 25000, 35000)
+	cam := si3d.NewCamera(pos.X, pos.Y, pos.Z, 0, 0, 0)
+	cam.LookAt(si3d.NewVector3(0, 0, 0), si3d.NewVector3(0, 1, 0))
+	capture := func(s CaptureSettings) image.Image {
+		return universe.GalaxyStars.TakeProbeSnapshot(cam, pos, 128, 128, s.Exposure, universe.Seed)
+	}
+
+	var frame []byte
+	imager := NewImagerPeripheral(cpu.NewCPU(), 3, capture, func(_ string, f []byte) { frame = f })
+	imager.Write16(0x02, ImagerRegCompression)
+	imager.Write16(0x04, uint16(comms.CompressionRLE))
+	imager.Write16(0x00, ImagerCmdCapture)
+
+	img, hdr, err := comms.DecodeImage(frame)
+	if err != nil {
+		t.Fatalf("DecodeImage: %v", err)
+	}
+	if hdr.Compression != comms.CompressionRLE {
+		t.Errorf("compression = %d, want RLE", hdr.Compression)
+	}
+	want, _ := comms.DecodeRGB332(snapshotRGB332(t), 128, 128)
+	if !bytes.Equal(img.(*image.RGBA).Pix, want.Pix) {
+		t.Error("compressed downlink decodes to a different image")
+	}
+}
@@ -0,0 +1,110 @@
+package spacecraft
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Auto-exposure meters the brightest pixels of the previous frame rather than
+// the mean, since a starfield is almost entirely black: it aims to put the
+// 99.5th-percentile luminance at autoExposureTarget, changing exposure by at
+// most a factor of two per frame so a passing bright star cannot make it
+// oscillate.
+const (
+	autoExposurePercentile = 0.995
+	autoExposureTarget     = 0.75
+	autoExposureMaxStep    = 2.0
+)
+
+// MinExposure and MaxExposure bound the exposure register in Go units.
+const (
+	MinExposure = ExposureUnit
+	MaxExposure = 0xFFFF * ExposureUnit
+)
+
+// hdrBracketStep is the exposure ratio between neighbouring HDR brackets, two
+// stops.
+const hdrBracketStep = 4.0
+
+// meterPercentile returns the luminance, from 0 to 1, below which fraction p
+// of the pixels of img fall.
+func meterPercentile(img image.Image, p float64) float64 {
+	var hist [256]int
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			hist[color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y]++
+		}
+	}
+	limit := int(math.Ceil(p * float64(b.Dx()*b.Dy())))
+	seen := 0
+	for v, n := range hist {
+		seen += n
+		if seen >= limit {
+			return float64(v) / 255
+		}
+	}
+	return 1
+}
+
+// nextExposure returns the exposure auto-exposure picks after a frame img
+// shot at exposure.
+func nextExposure(img image.Image, exposure float64) float64 {
+	ratio := autoExposureMaxStep
+	if m := meterPercentile(img, autoExposurePercentile); m > 0 {
+		ratio = min(max(autoExposureTarget/m, 1/autoExposureMaxStep), autoExposureMaxStep)
+	}
+	return min(max(exposure*ratio, MinExposure), MaxExposure)
+}
+
+// bracketExposures returns n exposures hdrBracketStep apart, centred on base.
+func bracketExposures(base float64, n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = base * math.Pow(hdrBracketStep, float64(i)-float64(n-1)/2)
+	}
+	return out
+}
+
+// mergeHDR combines frames of the same scene shot at the given exposures into
+// one frame. Each pixel's radiance is estimated from every frame, scaled to
+// the base exposure and weighted towards mid-tones, where the sensor is
+// neither clipped nor in the noise floor. The result is tone mapped with
+// extended Reinhard so the brightest radiance in the scene maps to white.
+func mergeHDR(frames []image.Image, exposures []float64, base float64) *image.RGBA {
+	b := frames[0].Bounds()
+	w, h := b.Dx(), b.Dy()
+	radiance := make([][3]float64, w*h)
+	peak := 1e-6
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum, weight [3]float64
+			for i, f := range frames {
+				c := color.RGBAModel.Convert(f.At(f.Bounds().Min.X+x, f.Bounds().Min.Y+y)).(color.RGBA)
+				scale := base / exposures[i]
+				for ch, v := range [3]uint8{c.R, c.G, c.B} {
+					wt := float64(min(v, 255-v)) + 1
+					sum[ch] += wt * float64(v) / 255 * scale
+					weight[ch] += wt
+				}
+			}
+			for ch := range sum {
+				r := sum[ch] / weight[ch]
+				radiance[y*w+x][ch] = r
+				peak = max(peak, r)
+			}
+		}
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	white2 := peak * peak
+	for i, r := range radiance {
+		for ch, v := range r {
+			mapped := v * (1 + v/white2) / (1 + v)
+			out.Pix[4*i+ch] = uint8(min(max(math.Round(mapped*255), 0), 255))
+		}
+		out.Pix[4*i+3] = 255
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package spacecraft
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+	"gocpu/pkg/cpu"
+)
+
+// exposedScene returns a capture function for a 4x1 scene whose pixels have
+// the given radiances: each pixel reads radiance*exposure*gain/80000, clipped
+// to 255, so at the default exposure a pixel reads its radiance.
+func exposedScene(radiance []float64, seen *[]CaptureSettings) func(CaptureSettings) image.Image {
+	return func(s CaptureSettings) image.Image {
+		*seen = append(*seen, s)
+		img := image.NewRGBA(image.Rect(0, 0, len(radiance), 1))
+		for x, r := range radiance {
+			v := uint8(min(r*s.Exposure*s.Gain/80000, 255))
+			img.SetRGBA(x, 0, color.RGBA{v, v, v, 255})
+		}
+		return img
+	}
+}
+
+func TestImagerPeripheral_ExposureAndGainRegisters(t *testing.T) {
+	var seen []CaptureSettings
+	imager := NewImagerPeripheral(cpu.NewCPU(), 3, exposedScene([]float64{10}, &seen), func(string, []byte) {})
+
+	imager.Write16(0x02, ImagerRegExposure)
+	if got := imager.Read16(0x04); got != 80 {
+		t.Fatalf("default exposure register = %d, want 80", got)
+	}
+	imager.Write16(0x04, 40)
+	imager.Write16(0x02, ImagerRegGain)
+	imager.Write16(0x04, 2*GainOne)
+	imager.Write16(0x00, ImagerCmdCapture)
+
+	if len(seen) != 1 || seen[0].Exposure != 40000 || seen[0].Gain != 2 {
+		t.Fatalf("capture settings = %+v, want exposure 40000 gain 2", seen)
+	}
+
+	imager.Write16(0x02, ImagerRegExposure)
+	imager.Write16(0x04, 0)
+	if got := imager.Read16(0x04); got != 1 {
+		t.Errorf("exposure below the minimum read back as %d, want 1", got)
+	}
+}
+
+func TestImagerPeripheral_AutoExposureConverges(t *testing.T) {
+	var seen []CaptureSettings
+	imager := NewImagerPeripheral(cpu.NewCPU(), 3, exposedScene([]float64{20, 20, 20, 20}, &seen), func(string, []byte) {})
+	imager.Write16(0x02, ImagerRegAutoExpose)
+	imager.Write16(0x04, 1)
+
+	for i := 0; i < 12; i++ {
+		imager.Write16(0x00, ImagerCmdCapture)
+	}
+	// A radiance of 20 reads 0.75*255 at exposure 765,000.
+	got := imager.Settings().Exposure
+	if math.Abs(got-765000) > 10000 {
+		t.Fatalf("auto exposure settled at %.0f, want about 765000", got)
+	}
+	for i := 1; i < len(seen); i++ {
+		if r := seen[i].Exposure / seen[i-1].Exposure; r > 2.01 || r < 0.49 {
+			t.Fatalf("exposure stepped by %.2fx between frames %d and %d", r, i-1, i)
+		}
+	}
+}
+
+func TestImagerPeripheral_HDRBrackets(t *testing.T) {
+	var seen []CaptureSettings
+	var frames [][]byte
+	// At the default exposure the last two pixels both clip.
+	scene := exposedScene([]float64{40, 120, 300, 600}, &seen)
+	imager := NewImagerPeripheral(cpu.NewCPU(), 3, scene, func(_ string, f []byte) { frames = append(frames, f) })
+	imager.Write16(0x02, ImagerRegHDR)
+	imager.Write16(0x04, 3)
+	imager.Write16(0x00, ImagerCmdCapture)
+
+	if len(seen) != 3 {
+		t.Fatalf("HDR frame took %d captures, want 3", len(seen))
+	}
+	for i, want := range []float64{20000, 80000, 320000} {
+		if math.Abs(seen[i].Exposure-want) > 1e-6 {
+			t.Errorf("bracket %d exposure = %.0f, want %.0f", i, seen[i].Exposure, want)
+		}
+	}
+
+	img := mergeHDR([]image.Image{scene(CaptureSettings{Exposure: 20000, Gain: 1}), scene(CaptureSettings{Exposure: 80000, Gain: 1}), scene(CaptureSettings{Exposure: 320000, Gain: 1})},
+		[]float64{20000, 80000, 320000}, 80000)
+	var prev uint8
+	for x := 0; x < 4; x++ {
+		v := img.RGBAAt(x, 0).R
+		if x > 0 && v <= prev {
+			t.Fatalf("merged pixel %d = %d not brighter than pixel %d = %d", x, v, x-1, prev)
+		}
+		prev = v
+	}
+	if prev != 255 {
+		t.Errorf("brightest merged pixel = %d, want 255", prev)
+	}
+	if len(frames) != 1 {
+		t.Errorf("HDR capture downlinked %d frames, want 1", len(frames))
+	}
+}
+
+func TestSpaceProbe_CaptureLeavesProbeSettings(t *testing.T) {
+	sp := newSnapshotProbe(t, comms.NewMessageBus())
+	sp.Physical.Exposure, sp.Physical.Gain = 50000, 1.5
+
+	spec := universe.CameraSpec{Name: "tiny", Width: 16, Height: 16}
+	sp.captureFrame(CaptureSettings{Exposure: 1000, Gain: 4, Spec: spec})
+
+	if sp.Physical.Exposure != 50000 || sp.Physical.Gain != 1.5 {
+		t.Errorf("probe exposure and gain became %g and %g", sp.Physical.Exposure, sp.Physical.Gain)
+	}
+}
@@ -0,0 +1,261 @@
+package spacecraft
+
+import (
+	"fmt"
+
+	"gocpu/pkg/cpu"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+)
+
+// Flight software updates arrive as comms uplinks. The loader, which models
+// the probe's boot ROM, stages the image in the VFS, verifies its checksum,
+// compiles it if it is source, and reboots into it. The new software is on
+// trial until it confirms the boot through the boot control peripheral; if it
+// does not within the boot timeout, or the CPU faults first, the probe rolls
+// back to the previous image.
+const (
+	BootControlSlot           = 15
+	BootControlPeripheralType = "BootControlPeripheral"
+	BootConfirmMagic          = 0xB007
+
+	// DefaultBootTimeout is the number of cycles trial software has to
+	// confirm its boot, two seconds of simulation at 1000 cycles a tick.
+	DefaultBootTimeout = 120000
+)
+
+// VFS files written by the loader.
+const (
+	StagedImageFile   = "OS.NEW" // uplinked image as received
+	CurrentImageFile  = "OS.IMG" // machine code the probe boots
+	PreviousImageFile = "OS.BAK" // machine code to roll back to
+)
+
+// flightSoftware is the loader's state. It survives reboots.
+type flightSoftware struct {
+	current, previous []byte
+	generation        uint16
+
+	timeout    int
+	trial      bool
+	remaining  int
+	confirmed  bool
+	rolledBack bool
+
+	faults      int
+	faultStreak int // faults since the last fault-free tick
+	lastFault   string
+}
+
+// BootControlPeripheral lets the guest confirm that it booted.
+//
+//	0x00 W: BootConfirmMagic confirms the boot, R: 1 once confirmed
+//	0x02 R: software generation, incremented by each confirmed uplink
+//	0x04 R: 1 if the probe rolled back to this software
+//	0x08-0x0E R: peripheral name
+type BootControlPeripheral struct {
+	fw *flightSoftware
+}
+
+func (p *BootControlPeripheral) Type() string { return BootControlPeripheralType }
+
+func (p *BootControlPeripheral) Read16(offset uint16) uint16 {
+	if offset >= 0x08 && offset <= 0x0E {
+		return cpu.EncodePeripheralName("BOOTCTL", offset)
+	}
+	switch offset {
+	case 0x00:
+		if p.fw.confirmed {
+			return 1
+		}
+	case 0x02:
+		return p.fw.generation
+	case 0x04:
+		if p.fw.rolledBack {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (p *BootControlPeripheral) Write16(offset uint16, val uint16) {
+	if offset == 0x00 && val == BootConfirmMagic {
+		p.fw.confirmed = true
+	}
+}
+
+func (p *BootControlPeripheral) Step() {}
+
+// SetBootTimeout changes the number of cycles trial software has to confirm
+// its boot.
+func (sp *SpaceProbe) SetBootTimeout(cycles int) {
+	sp.software.timeout = cycles
+}
+
+// SoftwareGeneration returns the number of uplinked images the probe has
+// successfully booted.
+func (sp *SpaceProbe) SoftwareGeneration() uint16 {
+	return sp.software.generation
+}
+
+// receive is the probe's bus subscriber. Uplinks are queued for Tick, so the
+// VM is only replaced between cycles; everything else goes to the guest.
+func (sp *SpaceProbe) receive(m comms.Message) {
+	if comms.IsUplink(m.Payload) {
+		sp.uplinkMu.Lock()
+		sp.uplinks = append(sp.uplinks, m)
+		sp.uplinkMu.Unlock()
+		return
+	}
+	if sp.MsgReceiver == nil {
+		fmt.Printf("[SpaceProbe %s] No message receiver, dropped %d bytes from %s\n", sp.Physical.ID, len(m.Payload), m.SenderID)
+		return
+	}
+	_ = sp.MsgReceiver.PushMessage(m.SenderID, m.Payload)
+}
+
+// Tick applies queued uplinks and advances the VM by the given number of
+// cycles, supervising any trial boot. A probe whose State is not CPURunning
+// is not stepped.
+func (sp *SpaceProbe) Tick(cycles int) {
+	sp.uplinkMu.Lock()
+	uplinks := sp.uplinks
+	sp.uplinks = nil
+	sp.uplinkMu.Unlock()
+	for _, m := range uplinks {
+		sp.applyUplink(m)
+	}
+
+	fw := sp.software
+	if fw.trial && !fw.confirmed && vmHalted(sp.VM) {
+		// A halted VM is not stepped, so the boot timeout would never run out.
+		sp.rollback("halted before confirming boot")
+	}
+	faulted := false
+	for i := 0; i < cycles && sp.State() == CPURunning; i++ {
+		fault := stepVM(sp.VM)
+		if fault != nil {
+			faulted = true
+			fw.faults++
+			fw.faultStreak++
+			fw.lastFault = fault.Error()
+		}
+		if !fw.trial {
+			switch {
+			case fault == nil:
+			case fw.faultStreak >= MaxFaultStreak:
+				sp.report(sp.station, fmt.Sprintf("CPU fault: %v, %d in a row, stopping until new software is uplinked", fault, fw.faultStreak))
+			default:
+				sp.report(sp.station, fmt.Sprintf("CPU fault: %v, rebooting", fault))
+				sp.reboot()
+			}
+			continue
+		}
+		switch fw.remaining--; {
+		case fw.confirmed:
+			fw.trial = false
+			fw.generation++
+			sp.report(sp.station, fmt.Sprintf("BOOT OK: software generation %d", fw.generation))
+		case fault != nil:
+			sp.rollback(fmt.Sprintf("CPU fault: %v", fault))
+		case vmHalted(sp.VM):
+			sp.rollback("halted before confirming boot")
+		case fw.remaining <= 0:
+			sp.rollback("boot not confirmed")
+		}
+	}
+	if !faulted && cycles > 0 && sp.State() == CPURunning {
+		fw.faultStreak = 0
+	}
+}
+
+// applyUplink stages, verifies and boots an uplinked image.
+func (sp *SpaceProbe) applyUplink(m comms.Message) {
+	hdr, body, err := comms.DecodeUplink(m.Payload)
+	if err != nil {
+		sp.report(m.SenderID, fmt.Sprintf("UPLINK REJECTED: %v", err))
+		return
+	}
+	if err := writeDiskFile(sp.VM, StagedImageFile, body); err != nil {
+		sp.report(m.SenderID, fmt.Sprintf("UPLINK REJECTED: staging: %v", err))
+		return
+	}
+	staged, err := readDiskFile(sp.VM, StagedImageFile)
+	if err != nil {
+		sp.report(m.SenderID, fmt.Sprintf("UPLINK REJECTED: staging: %v", err))
+		return
+	}
+	if err := hdr.Verify(staged); err != nil {
+		sp.report(m.SenderID, fmt.Sprintf("UPLINK REJECTED: %v", err))
+		return
+	}
+
+	image := staged
+	if hdr.Kind == comms.UplinkSource {
+		image, err = compileOS(string(staged))
+	} else {
+		err = checkImage(image)
+	}
+	if err != nil {
+		sp.report(m.SenderID, fmt.Sprintf("UPLINK REJECTED: %v", err))
+		return
+	}
+
+	fw := sp.software
+	if !fw.trial {
+		fw.previous = fw.current
+	}
+	fw.current = image
+	if err := sp.storeImages(); err != nil {
+		fmt.Printf("[SpaceProbe %s] %v\n", sp.Physical.ID, err)
+	}
+	fw.trial = true
+	fw.remaining = fw.timeout
+	fw.rolledBack = false
+	fw.faultStreak = 0
+	sp.reboot()
+	sp.report(m.SenderID, fmt.Sprintf("UPLINK OK: booting %d bytes of %s", len(image), hdr.Kind))
+}
+
+// rollback reboots into the previous image after a failed trial boot.
+func (sp *SpaceProbe) rollback(reason string) {
+	fw := sp.software
+	fw.current, fw.previous = fw.previous, nil
+	fw.trial = false
+	fw.rolledBack = true
+	if err := sp.storeImages(); err != nil {
+		fmt.Printf("[SpaceProbe %s] %v\n", sp.Physical.ID, err)
+	}
+	sp.reboot()
+	sp.report(sp.station, fmt.Sprintf("BOOT FAILED: %s, rolled back", reason))
+}
+
+// storeImages mirrors the loader's images into the VFS, where the guest can
+// inspect them.
+func (sp *SpaceProbe) storeImages() error {
+	if err := writeDiskFile(sp.VM, CurrentImageFile, sp.software.current); err != nil {
+		return fmt.Errorf("storeImages: %w", err)
+	}
+	if err := writeDiskFile(sp.VM, PreviousImageFile, sp.software.previous); err != nil {
+		return fmt.Errorf("storeImages: %w", err)
+	}
+	return nil
+}
+
+// reboot replaces the VM with a fresh one running the current image. The VFS
+// and the peripheral wiring carry over; peripheral and CPU state do not.
+func (sp *SpaceProbe) reboot() {
+	sp.VM = freshVM(sp.Physical.ID, sp.VM)
+	for _, attach := range sp.mounts {
+		attach(sp.VM)
+	}
+	sp.software.confirmed = false
+	copy(sp.VM.Memory[:], sp.software.current)
+	fmt.Printf("[SpaceProbe %s] Rebooted (%d byte image)\n", sp.Physical.ID, len(sp.software.current))
+}
+
+// report tells target, and the simulation log, about the flight software.
+func (sp *SpaceProbe) report(target, text string) {
+	fmt.Printf("[SpaceProbe %s] %s\n", sp.Physical.ID, text)
+	sp.bus.Send(sp.Physical.ID, target, []byte(text))
+}
@@ -0,0 +1,178 @@
+package spacecraft
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+// newUplinkProbe returns a probe with a short boot timeout and a function
+// returning the text Earth has received from it so far.
+func newUplinkProbe(t *testing.T) (*SpaceProbe, *comms.MessageBus, func() []string) {
+	t.Helper()
+	bus := comms.NewMessageBus()
+	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
+	scene := universe.NewLocalScene(0, 0, 0, 0, 0, 0)
+	probe, err := NewSpaceProbeWithOptions("Probe1", pos, scene, bus, ProbeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.SetBootTimeout(10)
+
+	var received []string
+	bus.Subscribe(GroundStationID, func(m comms.Message) {
+		received = append(received, string(m.Payload))
+	})
+	return probe, bus, func() []string {
+		bus.Tick()
+		return received
+	}
+}
+
+func uplink(probe *SpaceProbe, bus *comms.MessageBus, payload []byte) {
+	bus.Send(GroundStationID, probe.Physical.ID, payload)
+	bus.Tick()
+	probe.Tick(0)
+}
+
+func contains(msgs []string, prefix string) bool {
+	for _, m := range msgs {
+		if strings.HasPrefix(m, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFlightSoftware_ConfirmedUplinkBoots(t *testing.T) {
+	probe, bus, earth := newUplinkProbe(t)
+	image := []byte{0x01, 0x02, 0x03, 0x04}
+
+	uplink(probe, bus, comms.EncodeUplink(comms.UplinkMachineCode, image))
+
+	if !bytes.Equal(probe.VM.Memory[:len(image)], image) {
+		t.Fatalf("memory after uplink = % x, want % x", probe.VM.Memory[:len(image)], image)
+	}
+	if stored, err := probe.VM.Disk.Read(CurrentImageFile); err != nil || !bytes.Equal(stored, image) {
+		t.Errorf("%s = % x, %v; want % x", CurrentImageFile, stored, err, image)
+	}
+	if !contains(earth(), "UPLINK OK") {
+		t.Error("expected UPLINK OK report")
+	}
+
+	ctl := &BootControlPeripheral{fw: probe.software}
+	ctl.Write16(0x00, BootConfirmMagic)
+	probe.Tick(1)
+
+	if probe.SoftwareGeneration() != 1 || ctl.Read16(0x02) != 1 {
+		t.Errorf("generation = %d, want 1", probe.SoftwareGeneration())
+	}
+	// Well past the timeout, the confirmed software must still be running.
+	probe.Tick(20)
+	if !bytes.Equal(probe.VM.Memory[:len(image)], image) {
+		t.Error("confirmed software was rolled back")
+	}
+	if !contains(earth(), "BOOT OK") {
+		t.Error("expected BOOT OK report")
+	}
+}
+
+func TestFlightSoftware_UnconfirmedBootRollsBack(t *testing.T) {
+	probe, bus, earth := newUplinkProbe(t)
+	original := append([]byte(nil), probe.software.current...)
+	image := []byte{0xAA, 0xBB, 0xCC}
+
+	uplink(probe, bus, comms.EncodeUplink(comms.UplinkMachineCode, image))
+	probe.Tick(10)
+
+	if !bytes.Equal(probe.software.current, original) {
+		t.Fatalf("current image after timeout is % x, want the original", probe.software.current)
+	}
+	if !bytes.Equal(probe.VM.Memory[:len(original)], original) {
+		t.Error("VM memory does not hold the original image")
+	}
+	ctl := &BootControlPeripheral{fw: probe.software}
+	if ctl.Read16(0x04) != 1 {
+		t.Error("rolled back flag not set")
+	}
+	if probe.SoftwareGeneration() != 0 {
+		t.Errorf("generation = %d, want 0", probe.SoftwareGeneration())
+	}
+	if !contains(earth(), "BOOT FAILED") {
+		t.Error("expected BOOT FAILED report")
+	}
+}
+
+func TestFlightSoftware_CorruptUplinkRejected(t *testing.T) {
+	probe, bus, earth := newUplinkProbe(t)
+	original := append([]byte(nil), probe.software.current...)
+
+	payload := comms.EncodeUplink(comms.UplinkMachineCode, []byte{0x10, 0x20, 0x30})
+	payload[len(payload)-1] ^= 0xFF
+	uplink(probe, bus, payload)
+
+	if !bytes.Equal(probe.software.current, original) || probe.software.trial {
+		t.Error("corrupt uplink was booted")
+	}
+	if !contains(earth(), "UPLINK REJECTED") {
+		t.Error("expected UPLINK REJECTED report")
+	}
+}
+
+func TestFlightSoftware_HaltedTrialRollsBack(t *testing.T) {
+	probe, bus, earth := newUplinkProbe(t)
+	original := append([]byte(nil), probe.software.current...)
+
+	uplink(probe, bus, comms.EncodeUplink(comms.UplinkMachineCode, []byte{0xAA, 0xBB}))
+	probe.Tick(1)
+	restoreVM(probe.VM, vmRegisters(probe.VM), true, probe.VM.PeripheralIntMask)
+	probe.Tick(1)
+
+	if !bytes.Equal(probe.software.current, original) || probe.software.trial {
+		t.Fatal("halted trial software was not rolled back")
+	}
+	if probe.State() != CPURunning {
+		t.Errorf("state after rollback = %s, want %s", probe.State(), CPURunning)
+	}
+	if !contains(earth(), "BOOT FAILED: halted before confirming boot") {
+		t.Errorf("reports = %q, want a halt rollback", earth())
+	}
+}
+
+func TestFlightSoftware_ReportsToOwnGroundStation(t *testing.T) {
+	bus := comms.NewMessageBus()
+	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
+	scene := universe.NewLocalScene(0, 0, 0, 0, 0, 0)
+	probe, err := NewSpaceProbeWithOptions("Probe1", pos, scene, bus, ProbeOptions{GroundStation: "Houston"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.SetBootTimeout(10)
+	got := map[string][]string{}
+	for _, id := range []string{"Houston", GroundStationID} {
+		bus.Subscribe(id, func(m comms.Message) {
+			got[id] = append(got[id], string(m.Payload))
+		})
+	}
+
+	bus.Send("Houston", "Probe1", comms.EncodeUplink(comms.UplinkMachineCode, []byte{0xAA, 0xBB}))
+	bus.Tick()
+	probe.Tick(0)
+	probe.Tick(10)
+	bus.Tick()
+
+	if !contains(got["Houston"], "BOOT FAILED") || len(got[GroundStationID]) != 0 {
+		t.Errorf("Houston got %q, %s got %q", got["Houston"], GroundStationID, got[GroundStationID])
+	}
+
+	fork, err := probe.Fork("Probe2", comms.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.GroundStation() != "Houston" {
+		t.Errorf("fork downlinks to %s, want Houston", fork.GroundStation())
+	}
+}
@@ -0,0 +1,212 @@
+package spacecraft
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+
+	"gocpu/pkg/cpu"
+	"gocpu/pkg/peripherals"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+// PeripheralKind names a kind of peripheral a HardwareManifest can mount.
+type PeripheralKind string
+
+const (
+	KindMessageSender   PeripheralKind = "message_sender"   // outbound messages go onto the bus
+	KindCamera          PeripheralKind = "camera"           // gocpu camera, raw RGB332 frames only; use an imager to pick the format
+	KindMessageReceiver PeripheralKind = "message_receiver" // inbound messages land in the VFS queue
+	KindImager          PeripheralKind = "imager"           // ImagerPeripheral, frames downlinked to Earth
+	KindNavigation      PeripheralKind = "navigation"       // NavigationPeripheral
+)
+
+// MaxSlot is the highest peripheral slot. BootControlSlot is reserved.
+const MaxSlot = 15
+
+// PeripheralSpec is one peripheral in a HardwareManifest.
+type PeripheralSpec struct {
+	Slot uint8          `json:"slot"`
+	Kind PeripheralKind `json:"kind"`
+	// Name is the device name an imager reports to the guest,
+	// DefaultImagerName if empty. The imager named DefaultImagerName
+	// becomes SpaceProbe.Imager.
+	Name string `json:"name,omitempty"`
+	// Camera is an imager's or camera's sensor, universe.DefaultCameraSpec
+	// if nil.
+	Camera *universe.CameraSpec `json:"camera,omitempty"`
+}
+
+// HardwareManifest lists the peripherals mounted on a probe. The boot control
+// peripheral is always mounted on BootControlSlot and is not listed.
+type HardwareManifest struct {
+	Peripherals []PeripheralSpec `json:"peripherals"`
+}
+
+// DefaultHardware returns the stock probe loadout: message sender, camera,
+// message receiver and imager on slots 0-3.
+func DefaultHardware() HardwareManifest {
+	return HardwareManifest{Peripherals: []PeripheralSpec{
+		{Slot: 0, Kind: KindMessageSender},
+		{Slot: 1, Kind: KindCamera},
+		{Slot: 2, Kind: KindMessageReceiver},
+		{Slot: 3, Kind: KindImager, Name: DefaultImagerName},
+	}}
+}
+
+// ParseHardwareManifest decodes and validates a JSON hardware manifest.
+func ParseHardwareManifest(data []byte) (HardwareManifest, error) {
+	var m HardwareManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("ParseHardwareManifest: %w", err)
+	}
+	if err := m.Validate(); err != nil {
+		return m, fmt.Errorf("ParseHardwareManifest: %w", err)
+	}
+	return m, nil
+}
+
+// LoadHardwareManifest reads a JSON hardware manifest from a file.
+func LoadHardwareManifest(path string) (HardwareManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return HardwareManifest{}, fmt.Errorf("LoadHardwareManifest: %w", err)
+	}
+	return ParseHardwareManifest(data)
+}
+
+// Validate checks that every peripheral is known, fits a free slot and is
+// configured sensibly.
+func (m HardwareManifest) Validate() error {
+	used := map[uint8]PeripheralKind{BootControlSlot: "boot control"}
+	names := map[string]uint8{}
+	receivers := 0
+	for _, p := range m.Peripherals {
+		if p.Slot > MaxSlot {
+			return fmt.Errorf("HardwareManifest.Validate: %s: slot %d out of range 0-%d", p.Kind, p.Slot, MaxSlot)
+		}
+		if other, ok := used[p.Slot]; ok {
+			return fmt.Errorf("HardwareManifest.Validate: slot %d: %s conflicts with %s", p.Slot, p.Kind, other)
+		}
+		used[p.Slot] = p.Kind
+
+		switch p.Kind {
+		case KindMessageSender, KindNavigation:
+		case KindCamera:
+			if _, _, err := p.cameraSpec().Layout(); err != nil {
+				return fmt.Errorf("HardwareManifest.Validate: slot %d: %w", p.Slot, err)
+			}
+		case KindMessageReceiver:
+			if receivers++; receivers > 1 {
+				return fmt.Errorf("HardwareManifest.Validate: slot %d: only one message receiver may be mounted", p.Slot)
+			}
+		case KindImager:
+			name := p.deviceName()
+			if len(name) > 8 {
+				return fmt.Errorf("HardwareManifest.Validate: slot %d: imager name %q longer than 8 characters", p.Slot, name)
+			}
+			if other, ok := names[name]; ok {
+				return fmt.Errorf("HardwareManifest.Validate: slot %d: imager name %q already used on slot %d", p.Slot, name, other)
+			}
+			names[name] = p.Slot
+			if _, _, err := p.cameraSpec().Layout(); err != nil {
+				return fmt.Errorf("HardwareManifest.Validate: slot %d: %w", p.Slot, err)
+			}
+		default:
+			return fmt.Errorf("HardwareManifest.Validate: slot %d: unknown peripheral kind %q", p.Slot, p.Kind)
+		}
+	}
+	return nil
+}
+
+func (p PeripheralSpec) deviceName() string {
+	if p.Name == "" {
+		return DefaultImagerName
+	}
+	return p.Name
+}
+
+func (p PeripheralSpec) cameraSpec() universe.CameraSpec {
+	if p.Camera == nil {
+		return universe.DefaultCameraSpec
+	}
+	return *p.Camera
+}
+
+// mountHardware mounts every peripheral of a validated manifest, then the
+// boot control peripheral.
+func (sp *SpaceProbe) mountHardware(m HardwareManifest) error {
+	for _, p := range m.Peripherals {
+		if err := sp.mountPeripheral(p); err != nil {
+			return err
+		}
+	}
+	sp.mount(func(vm *cpu.CPU) {
+		sp.attach(vm, BootControlSlot, &BootControlPeripheral{fw: sp.software})
+	})
+	return nil
+}
+
+// attach mounts p on the VM. Its MMIO accesses are reported to any attached
+// Debugger, and its state, if it has any, is saved in snapshots.
+func (sp *SpaceProbe) attach(vm *cpu.CPU, slot uint8, p cpu.Peripheral) {
+	if s, ok := p.(snapshotter); ok {
+		sp.stateful[slot] = s
+	}
+	vm.MountPeripheral(slot, &mmioTap{Peripheral: p, sp: sp, slot: slot})
+}
+
+// mountPeripheral mounts one peripheral and records it in the probe's
+// manifest. Nothing is mounted if it fails.
+func (sp *SpaceProbe) mountPeripheral(p PeripheralSpec) error {
+	switch p.Kind {
+	case KindMessageSender:
+		sp.mountMessageSender(p.Slot)
+	case KindCamera:
+		sp.mountCamera(p.Slot, p.cameraSpec())
+	case KindMessageReceiver:
+		sp.mountMessageReceiver(p.Slot)
+	case KindImager:
+		if err := sp.mountImager(p.Slot, p.deviceName(), p.cameraSpec()); err != nil {
+			return fmt.Errorf("slot %d: %w", p.Slot, err)
+		}
+	case KindNavigation:
+		sp.mountNavigation(p.Slot)
+	}
+	sp.hardware.Peripherals = append(sp.hardware.Peripherals, p)
+	return nil
+}
+
+func (sp *SpaceProbe) mountMessageSender(slot uint8) {
+	id := sp.Physical.ID
+	dispatchFunc := func(target string, body []byte) {
+		sp.bus.Send(id, target, body)
+	}
+	sp.mount(func(vm *cpu.CPU) {
+		sp.attach(vm, slot, peripherals.NewMessageSender(vm, slot, dispatchFunc))
+	})
+}
+
+func (sp *SpaceProbe) mountCamera(slot uint8, spec universe.CameraSpec) {
+	captureFunc := func() *image.RGBA {
+		return ConvertToRGBA(sp.captureFrame(CaptureSettings{Exposure: sp.Physical.Exposure, Gain: sp.Physical.Gain, Spec: spec}))
+	}
+	sp.mount(func(vm *cpu.CPU) {
+		sp.attach(vm, slot, peripherals.NewCameraPeripheral(vm, slot, captureFunc))
+	})
+}
+
+func (sp *SpaceProbe) mountMessageReceiver(slot uint8) {
+	sp.mount(func(vm *cpu.CPU) {
+		sp.MsgReceiver = peripherals.NewMessageReceiver(vm, slot)
+		sp.attach(vm, slot, sp.MsgReceiver)
+	})
+}
+
+func (sp *SpaceProbe) mountNavigation(slot uint8) {
+	sp.mount(func(vm *cpu.CPU) {
+		sp.attach(vm, slot, NewNavigationPeripheral(vm, slot, sp.Physical.Position))
+	})
+}
@@ -0,0 +1,127 @@
+package spacecraft
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+func TestHardwareManifest_Validate(t *testing.T) {
+	if err := DefaultHardware().Validate(); err != nil {
+		t.Fatalf("default hardware: %v", err)
+	}
+
+	bad := []struct {
+		name string
+		m    HardwareManifest
+		want string
+	}{
+		{"slot conflict", HardwareManifest{Peripherals: []PeripheralSpec{
+			{Slot: 2, Kind: KindMessageSender}, {Slot: 2, Kind: KindNavigation},
+		}}, "slot 2: navigation conflicts with message_sender"},
+		{"boot control slot", HardwareManifest{Peripherals: []PeripheralSpec{
+			{Slot: BootControlSlot, Kind: KindNavigation},
+		}}, "conflicts with boot control"},
+		{"out of range", HardwareManifest{Peripherals: []PeripheralSpec{
+			{Slot: 16, Kind: KindCamera},
+		}}, "out of range"},
+		{"unknown kind", HardwareManifest{Peripherals: []PeripheralSpec{
+			{Slot: 0, Kind: "warp_drive"},
+		}}, "unknown peripheral kind"},
+		{"two receivers", HardwareManifest{Peripherals: []PeripheralSpec{
+			{Slot: 0, Kind: KindMessageReceiver}, {Slot: 1, Kind: KindMessageReceiver},
+		}}, "only one message receiver"},
+		{"duplicate imager name", HardwareManifest{Peripherals: []PeripheralSpec{
+			{Slot: 3, Kind: KindImager}, {Slot: 4, Kind: KindImager, Name: DefaultImagerName},
+		}}, "already used on slot 3"},
+		{"bad camera", HardwareManifest{Peripherals: []PeripheralSpec{
+			{Slot: 3, Kind: KindImager, Camera: &universe.CameraSpec{Name: "bad", Width: 0, Height: 10}},
+		}}, "bad resolution"},
+		{"bad camera on a camera", HardwareManifest{Peripherals: []PeripheralSpec{
+			{Slot: 1, Kind: KindCamera, Camera: &universe.CameraSpec{Name: "bad", Width: 10, Height: 0}},
+		}}, "bad resolution"},
+	}
+	for _, tc := range bad {
+		err := tc.m.Validate()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestParseHardwareManifest(t *testing.T) {
+	m, err := ParseHardwareManifest([]byte(`{
+		"peripherals": [
+			{"slot": 0, "kind": "message_sender"},
+			{"slot": 2, "kind": "message_receiver"},
+			{"slot": 3, "kind": "imager"},
+			{"slot": 4, "kind": "imager", "name": "NAVCAM", "camera": {"name": "navcam", "width": 256, "height": 192}},
+			{"slot": 5, "kind": "navigation"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Peripherals) != 5 {
+		t.Fatalf("got %d peripherals, want 5", len(m.Peripherals))
+	}
+	if cam := m.Peripherals[3].Camera; cam == nil || *cam != universe.NavCamSpec {
+		t.Errorf("navcam spec = %+v, want %+v", cam, universe.NavCamSpec)
+	}
+
+	if _, err := ParseHardwareManifest([]byte(`{"peripherals": [{"slot": 1, "kind": "camera"}, {"slot": 1, "kind": "navigation"}]}`)); err == nil {
+		t.Error("expected a slot conflict error")
+	}
+}
+
+func TestNewSpaceProbeWithOptions_Hardware(t *testing.T) {
+	bus := comms.NewMessageBus()
+	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
+	scene := universe.NewLocalScene(0, 0, 0, 0, 0, 0)
+
+	hw := HardwareManifest{Peripherals: []PeripheralSpec{
+		{Slot: 0, Kind: KindMessageSender},
+		{Slot: 3, Kind: KindImager, Name: "NAVCAM", Camera: &universe.NavCamSpec},
+		{Slot: 6, Kind: KindNavigation},
+	}}
+	probe, err := NewSpaceProbeWithOptions("Probe1", pos, scene, bus, ProbeOptions{Hardware: &hw, OSBinary: []byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probe.Imager != nil {
+		t.Error("expected no primary imager")
+	}
+	if navcam := probe.Instruments[3]; navcam == nil || navcam.CameraSpec().Width != 256 {
+		t.Errorf("navcam not mounted on slot 3: %+v", navcam)
+	}
+	if got := probe.Hardware().Peripherals; len(got) != 3 || got[2].Kind != KindNavigation {
+		t.Errorf("Hardware() = %+v", got)
+	}
+
+	if _, err := probe.MountInstrument(6, "TELESCOP", universe.TelescopeSpec); err == nil {
+		t.Error("expected MountInstrument on an occupied slot to fail")
+	}
+	if _, err := probe.MountInstrument(7, "TELESCOP", universe.TelescopeSpec); err != nil {
+		t.Errorf("MountInstrument on a free slot: %v", err)
+	}
+
+	conflict := HardwareManifest{Peripherals: []PeripheralSpec{{Slot: 1, Kind: KindCamera}, {Slot: 1, Kind: KindNavigation}}}
+	if _, err := NewSpaceProbeWithOptions("Probe2", pos, scene, bus, ProbeOptions{Hardware: &conflict}); err == nil {
+		t.Error("expected a slot conflict error")
+	}
+}
+
+func TestSpaceProbe_MountPeripheralFailsOnBadSpec(t *testing.T) {
+	probe := newSnapshotProbe(t, comms.NewMessageBus())
+	mounts, hw := len(probe.mounts), len(probe.Hardware().Peripherals)
+
+	bad := PeripheralSpec{Slot: 9, Kind: KindImager, Name: "BROKEN", Camera: &universe.CameraSpec{Name: "broken"}}
+	if err := probe.mountPeripheral(bad); err == nil {
+		t.Fatal("expected an error for an imager spec without a resolution")
+	}
+	if len(probe.mounts) != mounts || len(probe.Hardware().Peripherals) != hw || probe.Instruments[9] != nil {
+		t.Error("the failed imager was left mounted")
+	}
+}
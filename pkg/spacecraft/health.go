@@ -0,0 +1,142 @@
+package spacecraft
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CPUState is the run state of a probe's VM.
+type CPUState int
+
+const (
+	CPURunning    CPUState = iota
+	CPUHalted              // the guest executed HALT
+	CPUCrashLoop           // MaxFaultStreak consecutive faults; stopped until an uplink
+	CPUNoSoftware          // no OS image was loaded
+)
+
+func (s CPUState) String() string {
+	switch s {
+	case CPURunning:
+		return "running"
+	case CPUHalted:
+		return "halted"
+	case CPUCrashLoop:
+		return "crash loop"
+	case CPUNoSoftware:
+		return "no software"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// MaxFaultStreak is how many CPU faults in a row, without a fault-free tick
+// in between, stop a probe rather than rebooting it again.
+const MaxFaultStreak = 3
+
+// PeripheralHealth is the status of one mounted peripheral.
+type PeripheralHealth struct {
+	Slot   uint8
+	Kind   PeripheralKind
+	Name   string // device name of an imager
+	Status string
+}
+
+// ProbeHealth is a snapshot of a probe's condition.
+type ProbeHealth struct {
+	ID                 string
+	State              CPUState
+	Faults             int    // CPU faults since the probe was built
+	LastFault          string // empty if the CPU has never faulted
+	InterruptMask      uint16
+	VFSFiles           int
+	VFSBytes           int
+	SoftwareGeneration uint16
+	SoftwareTrial      bool // running uplinked software that has not yet confirmed its boot
+	Peripherals        []PeripheralHealth
+}
+
+// Alive reports whether the probe is still executing its software.
+func (h ProbeHealth) Alive() bool {
+	return h.State == CPURunning
+}
+
+func (h ProbeHealth) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: cpu %s, %d faults", h.ID, h.State, h.Faults)
+	if h.LastFault != "" {
+		fmt.Fprintf(&b, " (last: %s)", h.LastFault)
+	}
+	fmt.Fprintf(&b, ", int mask %04x, vfs %d files/%d bytes, software gen %d", h.InterruptMask, h.VFSFiles, h.VFSBytes, h.SoftwareGeneration)
+	if h.SoftwareTrial {
+		b.WriteString(" (trial)")
+	}
+	for _, p := range h.Peripherals {
+		fmt.Fprintf(&b, "\n  slot %2d %-16s %-8s %s", p.Slot, p.Kind, p.Name, p.Status)
+	}
+	return b.String()
+}
+
+// State returns the run state of the probe's VM; Health reports it too, with
+// more detail.
+func (sp *SpaceProbe) State() CPUState {
+	switch {
+	case len(sp.software.current) == 0:
+		return CPUNoSoftware
+	case sp.software.faultStreak >= MaxFaultStreak:
+		return CPUCrashLoop
+	case vmHalted(sp.VM):
+		return CPUHalted
+	}
+	return CPURunning
+}
+
+// Health reports the probe's CPU, storage and peripheral status.
+func (sp *SpaceProbe) Health() ProbeHealth {
+	fw := sp.software
+	h := ProbeHealth{
+		ID:                 sp.Physical.ID,
+		State:              sp.State(),
+		Faults:             fw.faults,
+		LastFault:          fw.lastFault,
+		InterruptMask:      sp.VM.PeripheralIntMask,
+		SoftwareGeneration: fw.generation,
+		SoftwareTrial:      fw.trial,
+	}
+	h.VFSFiles, h.VFSBytes = diskUsage(sp.VM)
+
+	for _, p := range sp.hardware.Peripherals {
+		ph := PeripheralHealth{Slot: p.Slot, Kind: p.Kind, Status: "ok"}
+		switch p.Kind {
+		case KindImager:
+			ph.Name = p.deviceName()
+			ph.Status = imagerStatusName(sp.Instruments[p.Slot].Read16(0x00))
+		case KindMessageReceiver:
+			if sp.MsgReceiver == nil {
+				ph.Status = "missing"
+			}
+		}
+		h.Peripherals = append(h.Peripherals, ph)
+	}
+	boot := PeripheralHealth{Slot: BootControlSlot, Kind: "boot_control", Status: "awaiting confirmation"}
+	if fw.confirmed || !fw.trial {
+		boot.Status = "ok"
+	}
+	h.Peripherals = append(h.Peripherals, boot)
+	sort.Slice(h.Peripherals, func(i, j int) bool { return h.Peripherals[i].Slot < h.Peripherals[j].Slot })
+	return h
+}
+
+func imagerStatusName(status uint16) string {
+	switch status {
+	case ImagerStatusIdle:
+		return "idle"
+	case ImagerStatusOK:
+		return "ok"
+	case ImagerStatusError:
+		return "error"
+	case ImagerStatusBusy:
+		return "busy"
+	}
+	return fmt.Sprintf("status %d", status)
+}
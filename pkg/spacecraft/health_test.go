@@ -0,0 +1,73 @@
+package spacecraft
+
+import (
+	"testing"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+func TestSpaceProbe_Health(t *testing.T) {
+	bus := comms.NewMessageBus()
+	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
+	scene := universe.NewLocalScene(0, 0, 0, 0, 0, 0)
+	probe, err := NewSpaceProbeWithOptions("Probe1", pos, scene, bus, ProbeOptions{OSBinary: []byte{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := probe.Health()
+	if h.ID != "Probe1" || h.State != CPURunning || !h.Alive() {
+		t.Errorf("new probe health = %+v", h)
+	}
+	want := []struct {
+		slot uint8
+		kind PeripheralKind
+	}{{0, KindMessageSender}, {1, KindCamera}, {2, KindMessageReceiver}, {3, KindImager}, {BootControlSlot, "boot_control"}}
+	if len(h.Peripherals) != len(want) {
+		t.Fatalf("got %d peripherals, want %d: %+v", len(h.Peripherals), len(want), h.Peripherals)
+	}
+	for i, w := range want {
+		if p := h.Peripherals[i]; p.Slot != w.slot || p.Kind != w.kind {
+			t.Errorf("peripheral %d = %+v, want slot %d %s", i, p, w.slot, w.kind)
+		}
+	}
+	if h.Peripherals[3].Name != DefaultImagerName || h.Peripherals[3].Status != "idle" {
+		t.Errorf("imager health = %+v", h.Peripherals[3])
+	}
+
+	// An uplink stores images in the VFS and puts the software on trial.
+	bus.Send(GroundStationID, "Probe1", comms.EncodeUplink(comms.UplinkMachineCode, []byte{4, 5, 6, 7}))
+	bus.Tick()
+	probe.Tick(0)
+	h = probe.Health()
+	if h.VFSFiles == 0 || h.VFSBytes < 4 {
+		t.Errorf("vfs usage = %d files, %d bytes after uplink", h.VFSFiles, h.VFSBytes)
+	}
+	if !h.SoftwareTrial || h.Peripherals[len(h.Peripherals)-1].Status != "awaiting confirmation" {
+		t.Errorf("expected software on trial: %+v", h)
+	}
+
+	restoreVM(probe.VM, vmRegisters(probe.VM), true, probe.VM.PeripheralIntMask)
+	if probe.State() != CPUHalted || probe.Health().Alive() {
+		t.Error("halted CPU reported alive")
+	}
+}
+
+func TestSpaceProbe_NoSoftwareIsNotStepped(t *testing.T) {
+	bus := comms.NewMessageBus()
+	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
+	scene := universe.NewLocalScene(0, 0, 0, 0, 0, 0)
+	probe, err := newSpaceProbe("Probe1", pos, scene, bus, DefaultHardware())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if probe.State() != CPUNoSoftware {
+		t.Fatalf("state = %s, want %s", probe.State(), CPUNoSoftware)
+	}
+	probe.Tick(100)
+	if h := probe.Health(); h.Faults != 0 || h.Alive() {
+		t.Errorf("health = %+v", h)
+	}
+}
@@ -0,0 +1,448 @@
+package spacecraft
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+	"gocpu/pkg/cpu"
+)
+
+const ImagerPeripheralType = "ImagerPeripheral"
+
+// Imager commands, written to offset 0x00.
+const (
+	ImagerCmdCapture = 1 // capture, encode and downlink a frame
+	ImagerCmdAbort   = 2 // drop the fragments of a progressive frame not yet sent
+)
+
+// Imager status values, read from offset 0x00.
+const (
+	ImagerStatusIdle  = 0
+	ImagerStatusOK    = 1
+	ImagerStatusError = 2
+	ImagerStatusBusy  = 3 // progressive fragments still being sent
+)
+
+// Imager configuration registers, selected by writing the index to 0x02 and
+// then accessed through 0x04.
+const (
+	ImagerRegFormat      = 0x00 // comms.ImageFormat of downlinked frames
+	ImagerRegCompression = 0x01 // comms.Compression of downlinked frames
+	ImagerRegFragment    = 0x02 // progressive fragment size in bytes, 0 sends whole frames
+	ImagerRegInterval    = 0x03 // Steps between progressive fragments
+	ImagerRegProfile     = 0x04 // camera profile index, see universe.CameraProfiles
+	ImagerRegExposure    = 0x05 // exposure in ExposureUnit steps, updated by auto-exposure
+	ImagerRegGain        = 0x06 // sensor gain, 8.8 fixed point (GainOne is 1.0)
+	ImagerRegAutoExpose  = 0x07 // non-zero meters each frame to set the next exposure
+	ImagerRegHDR         = 0x08 // number of bracketed exposures merged per frame, 0 or 1 for none
+	ImagerRegWidth       = 0x09 // R: sensor width in pixels
+	ImagerRegHeight      = 0x0A // R: sensor height in pixels
+	ImagerRegFOV         = 0x0B // R: horizontal field of view in hundredths of a degree
+	ImagerRegReplyTo     = 0x0C // W: guest address of the NUL-terminated bus ID frames go to, 0 for the ground station
+)
+
+// maxReplyTo bounds the bus ID read from guest memory for ImagerRegReplyTo.
+const maxReplyTo = 255
+
+// DefaultImagerName is the peripheral name of the primary imager. Further
+// instruments mounted with SpaceProbe.MountInstrument carry their own names.
+const DefaultImagerName = "IMAGER"
+
+// Exposure and gain register scales.
+const (
+	ExposureUnit   = 1000.0
+	GainOne        = 0x100
+	MaxHDRBrackets = 5
+)
+
+// CaptureSettings are the sensor settings a frame is taken with.
+type CaptureSettings struct {
+	Exposure float64 // in the units of universe.DefaultExposure
+	Gain     float64 // multiplier, 1 is unity gain
+	Spec     universe.CameraSpec
+}
+
+// DefaultFragmentInterval spaces progressive fragments one simulation tick
+// apart at the 1000 cycles per tick used by cmd/unknowngalaxy.
+const DefaultFragmentInterval = 1000
+
+// ImagerPeripheral is a camera whose frames are encoded into a comms image
+// container before downlink, so the guest can choose the image format. With a
+// fragment size set, frames are instead sent as comms progressive fragments,
+// one every interval Steps, and the interrupt fires once the last one is out.
+//
+// Frames go to the bus ID last written through ImagerRegReplyTo, such as the
+// sender of the command being answered; downlink gets "" for the probe's
+// ground station. A progressive frame keeps the destination it was captured
+// with. With a capture info source set, frames record when and where they
+// were taken: single frames in their container header, progressive frames in
+// an info fragment sent ahead of the pixels.
+//
+// Exposure and gain are passed to capture. With auto-exposure on, each frame
+// is metered to pick the next frame's exposure; with HDR brackets set, each
+// frame is merged from that many captures two stops apart around the current
+// exposure.
+//
+//	0x00 W: command, R: status of the last command
+//	0x02 RW: selected configuration register
+//	0x04 RW: value of the selected configuration register
+//	0x06 R: size in bytes of the last frame, saturating at 0xFFFF
+//	0x08-0x0E R: peripheral name
+type ImagerPeripheral struct {
+	c        *cpu.CPU
+	slot     uint8
+	capture  func(s CaptureSettings) image.Image
+	downlink func(target string, frame []byte)
+	replyTo  string
+	info     func() comms.CaptureInfo
+
+	status      uint16
+	selected    uint16
+	lastSize    int
+	format      comms.ImageFormat
+	compression comms.Compression
+
+	fragmentSize uint16
+	interval     uint16
+	frameID      uint16
+	fragments    [][]byte
+	fragmentsTo  string
+	wait         int
+
+	profile   uint16
+	onProfile func(index uint16) error
+
+	exposure float64
+	gain     uint16
+	auto     bool
+	brackets uint16
+
+	name   string
+	spec   universe.CameraSpec
+	fov    float64
+	render int
+}
+
+// NewImagerPeripheral creates an imager that takes pictures with capture and
+// hands encoded frames to downlink with the bus ID they go to, "" for the
+// probe's ground station.
+func NewImagerPeripheral(c *cpu.CPU, slot uint8, capture func(s CaptureSettings) image.Image, downlink func(target string, frame []byte)) *ImagerPeripheral {
+	p := &ImagerPeripheral{
+		c:        c,
+		slot:     slot,
+		capture:  capture,
+		downlink: downlink,
+		format:   comms.FormatRGB332,
+		interval: DefaultFragmentInterval,
+		exposure: universe.DefaultExposure,
+		gain:     GainOne,
+		name:     DefaultImagerName,
+	}
+	p.SetCameraSpec(universe.DefaultCameraSpec)
+	return p
+}
+
+// SetDeviceName changes the name the guest finds the imager by. Names longer
+// than 8 characters are truncated by the name registers.
+func (p *ImagerPeripheral) SetDeviceName(name string) {
+	p.name = name
+}
+
+// SetCameraSpec sets the resolution and field of view of the camera.
+func (p *ImagerPeripheral) SetCameraSpec(spec universe.CameraSpec) error {
+	render, fov, err := spec.Layout()
+	if err != nil {
+		return err
+	}
+	p.spec, p.fov, p.render = spec, fov, render
+	return nil
+}
+
+// CameraSpec returns the camera's resolution and field of view.
+func (p *ImagerPeripheral) CameraSpec() universe.CameraSpec {
+	return p.spec
+}
+
+// Settings returns the exposure and gain the next single-exposure frame will
+// be taken with.
+func (p *ImagerPeripheral) Settings() CaptureSettings {
+	return CaptureSettings{Exposure: p.exposure, Gain: float64(p.gain) / GainOne, Spec: p.spec}
+}
+
+// SetProfileHandler installs the function that switches camera profile when
+// the guest writes ImagerRegProfile. If it returns an error the register keeps
+// its previous value. Without a handler the register is read-only.
+func (p *ImagerPeripheral) SetProfileHandler(h func(index uint16) error) {
+	p.onProfile = h
+}
+
+// SetCaptureInfo installs the function that reports the tick and pose a frame
+// is taken at. It is called once per frame, when the frame is captured.
+func (p *ImagerPeripheral) SetCaptureInfo(info func() comms.CaptureInfo) {
+	p.info = info
+}
+
+func (p *ImagerPeripheral) Type() string { return ImagerPeripheralType }
+
+func (p *ImagerPeripheral) Read16(offset uint16) uint16 {
+	if offset >= 0x08 && offset <= 0x0E {
+		return cpu.EncodePeripheralName(p.name, offset)
+	}
+	switch offset {
+	case 0x00:
+		return p.status
+	case 0x02:
+		return p.selected
+	case 0x04:
+		return p.readReg(p.selected)
+	case 0x06:
+		return uint16(min(p.lastSize, 0xFFFF))
+	}
+	return 0
+}
+
+func (p *ImagerPeripheral) Write16(offset uint16, val uint16) {
+	switch offset {
+	case 0x00:
+		switch val {
+		case ImagerCmdCapture:
+			if p.captureFrame() {
+				p.c.TriggerPeripheralInterrupt(p.slot)
+			}
+		case ImagerCmdAbort:
+			if len(p.fragments) > 0 {
+				p.abandonFragments()
+				p.status = ImagerStatusIdle
+				p.c.TriggerPeripheralInterrupt(p.slot)
+			}
+		}
+	case 0x02:
+		p.selected = val
+	case 0x04:
+		p.writeReg(p.selected, val)
+	}
+}
+
+// abandonFragments drops the unsent fragments of the progressive frame and
+// tells its destination to forget the partial frame.
+func (p *ImagerPeripheral) abandonFragments() {
+	p.fragments = nil
+	p.downlink(p.fragmentsTo, comms.EncodeProgressiveAbort(p.frameID))
+}
+
+// Step sends the next queued progressive fragment when its interval is up.
+func (p *ImagerPeripheral) Step() {
+	if len(p.fragments) == 0 {
+		return
+	}
+	if p.wait > 0 {
+		p.wait--
+		return
+	}
+	p.downlink(p.fragmentsTo, p.fragments[0])
+	p.fragments = p.fragments[1:]
+	p.wait = int(p.interval)
+	if len(p.fragments) == 0 {
+		p.status = ImagerStatusOK
+		p.c.TriggerPeripheralInterrupt(p.slot)
+	}
+}
+
+func (p *ImagerPeripheral) readReg(reg uint16) uint16 {
+	switch reg {
+	case ImagerRegFormat:
+		return uint16(p.format)
+	case ImagerRegCompression:
+		return uint16(p.compression)
+	case ImagerRegFragment:
+		return p.fragmentSize
+	case ImagerRegInterval:
+		return p.interval
+	case ImagerRegProfile:
+		return p.profile
+	case ImagerRegExposure:
+		return uint16(math.Round(p.exposure / ExposureUnit))
+	case ImagerRegGain:
+		return p.gain
+	case ImagerRegAutoExpose:
+		if p.auto {
+			return 1
+		}
+		return 0
+	case ImagerRegHDR:
+		return p.brackets
+	case ImagerRegWidth:
+		return uint16(p.spec.Width)
+	case ImagerRegHeight:
+		return uint16(p.spec.Height)
+	case ImagerRegFOV:
+		return uint16(math.Round(p.fov * 100))
+	}
+	return 0
+}
+
+func (p *ImagerPeripheral) writeReg(reg uint16, val uint16) {
+	switch reg {
+	case ImagerRegFormat:
+		p.format = comms.ImageFormat(val)
+	case ImagerRegCompression:
+		p.compression = comms.Compression(val)
+	case ImagerRegFragment:
+		p.fragmentSize = val
+	case ImagerRegInterval:
+		p.interval = val
+	case ImagerRegProfile:
+		if p.onProfile == nil {
+			return
+		}
+		if err := p.onProfile(val); err != nil {
+			fmt.Printf("[Imager] %v\n", err)
+			return
+		}
+		p.profile = val
+	case ImagerRegExposure:
+		p.exposure = min(max(float64(val)*ExposureUnit, MinExposure), MaxExposure)
+	case ImagerRegGain:
+		p.gain = val
+	case ImagerRegAutoExpose:
+		p.auto = val != 0
+	case ImagerRegHDR:
+		p.brackets = min(val, MaxHDRBrackets)
+	case ImagerRegReplyTo:
+		p.replyTo = p.guestString(val)
+	}
+}
+
+// guestString reads a NUL-terminated string from guest memory, "" for
+// address 0.
+func (p *ImagerPeripheral) guestString(addr uint16) string {
+	if addr == 0 {
+		return ""
+	}
+	mem := p.c.Memory[addr:]
+	n := 0
+	for n < len(mem) && n < maxReplyTo && mem[n] != 0 {
+		n++
+	}
+	return string(mem[:n])
+}
+
+// captureFrame takes a picture and downlinks or queues it. It reports whether
+// the frame is finished, i.e. whether the interrupt should fire now.
+func (p *ImagerPeripheral) captureFrame() bool {
+	img := p.shoot()
+	var info *comms.CaptureInfo
+	if p.info != nil {
+		c := p.info()
+		c.FOV, c.Render, c.Profile = p.fov, uint16(p.render), uint8(p.profile)
+		info = &c
+	}
+	if len(p.fragments) > 0 {
+		p.abandonFragments()
+	}
+	if p.fragmentSize > 0 {
+		p.frameID++
+		fragments, err := comms.EncodeProgressive(img, p.frameID, int(p.fragmentSize))
+		if err != nil {
+			fmt.Printf("[Imager] capture failed: %v\n", err)
+			p.status = ImagerStatusError
+			return true
+		}
+		if info != nil {
+			b := img.Bounds()
+			fragments = append([][]byte{comms.EncodeProgressiveInfo(p.frameID, b.Dx(), b.Dy(), *info)}, fragments...)
+		}
+		p.lastSize = 0
+		for _, f := range fragments {
+			p.lastSize += len(f)
+		}
+		p.fragments, p.fragmentsTo = fragments, p.replyTo
+		p.wait = 0
+		p.status = ImagerStatusBusy
+		return false
+	}
+
+	frame, err := comms.EncodeImage(img, p.format, comms.EncodeOptions{Compression: p.compression, Capture: info})
+	if err != nil {
+		fmt.Printf("[Imager] capture failed: %v\n", err)
+		p.status = ImagerStatusError
+		return true
+	}
+	p.lastSize = len(frame)
+	p.status = ImagerStatusOK
+	p.downlink(p.replyTo, frame)
+	return true
+}
+
+// shoot takes one frame with the current settings, bracketing it for HDR if
+// enabled, and updates the exposure if auto-exposure is on.
+func (p *ImagerPeripheral) shoot() image.Image {
+	s := p.Settings()
+	if p.brackets < 2 {
+		img := p.capture(s)
+		if p.auto {
+			p.exposure = nextExposure(img, p.exposure)
+		}
+		return img
+	}
+
+	exposures := bracketExposures(s.Exposure, int(p.brackets))
+	frames := make([]image.Image, len(exposures))
+	for i, e := range exposures {
+		frames[i] = p.capture(CaptureSettings{Exposure: e, Gain: s.Gain, Spec: s.Spec})
+	}
+	if p.auto {
+		p.exposure = nextExposure(frames[len(frames)/2], p.exposure)
+	}
+	return mergeHDR(frames, exposures, s.Exposure)
+}
+
+// imagerState is the ImagerPeripheral state saved in probe snapshots. The
+// device name and camera spec come from the hardware manifest instead.
+type imagerState struct {
+	Status, Selected uint16
+	LastSize         int
+	Format           comms.ImageFormat
+	Compression      comms.Compression
+
+	FragmentSize, Interval, FrameID uint16
+	Fragments                       [][]byte
+	FragmentsTo, ReplyTo            string
+	Wait                            int
+
+	Profile  uint16
+	Exposure float64
+	Gain     uint16
+	Auto     bool
+	Brackets uint16
+}
+
+func (p *ImagerPeripheral) snapshot() ([]byte, error) {
+	return encodeState(imagerState{
+		Status: p.status, Selected: p.selected, LastSize: p.lastSize,
+		Format: p.format, Compression: p.compression,
+		FragmentSize: p.fragmentSize, Interval: p.interval, FrameID: p.frameID,
+		Fragments: p.fragments, FragmentsTo: p.fragmentsTo, ReplyTo: p.replyTo, Wait: p.wait,
+		Profile: p.profile, Exposure: p.exposure, Gain: p.gain, Auto: p.auto, Brackets: p.brackets,
+	})
+}
+
+func (p *ImagerPeripheral) decode(data []byte) (any, error) {
+	var s imagerState
+	if err := decodeState(data, &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *ImagerPeripheral) apply(state any) {
+	s := state.(imagerState)
+	p.status, p.selected, p.lastSize = s.Status, s.Selected, s.LastSize
+	p.format, p.compression = s.Format, s.Compression
+	p.fragmentSize, p.interval, p.frameID = s.FragmentSize, s.Interval, s.FrameID
+	p.fragments, p.fragmentsTo, p.replyTo, p.wait = s.Fragments, s.FragmentsTo, s.ReplyTo, s.Wait
+	p.profile, p.exposure, p.gain, p.auto, p.brackets = s.Profile, s.Exposure, s.Gain, s.Auto, s.Brackets
+}
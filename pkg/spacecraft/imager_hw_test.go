@@ -0,0 +1,295 @@
+package spacecraft
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+	"gocpu/pkg/cpu"
+)
+
+func testCapture(CaptureSettings) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	img.Set(3, 2, color.RGBA{255, 255, 255, 255})
+	return img
+}
+
+func TestImagerPeripheral_CaptureInSelectedFormat(t *testing.T) {
+	c := cpu.NewCPU()
+	var frames [][]byte
+	imager := NewImagerPeripheral(c, 3, testCapture, func(_ string, frame []byte) {
+		frames = append(frames, frame)
+	})
+	c.MountPeripheral(3, imager)
+
+	imager.Write16(0x02, ImagerRegFormat)
+	imager.Write16(0x04, uint16(comms.FormatGray8))
+	if got := imager.Read16(0x04); got != uint16(comms.FormatGray8) {
+		t.Errorf("format readback: want %d, got %d", comms.FormatGray8, got)
+	}
+
+	imager.Write16(0x00, ImagerCmdCapture)
+
+	if len(frames) != 1 {
+		t.Fatalf("want 1 downlinked frame, got %d", len(frames))
+	}
+	img, hdr, err := comms.DecodeImage(frames[0])
+	if err != nil {
+		t.Fatalf("DecodeImage: %v", err)
+	}
+	if hdr.Format != comms.FormatGray8 || hdr.Width != 8 || hdr.Height != 4 {
+		t.Errorf("header = %+v", hdr)
+	}
+	if g := img.(*image.Gray).GrayAt(3, 2).Y; g != 255 {
+		t.Errorf("white pixel decoded as %d", g)
+	}
+	if imager.Read16(0x00) != ImagerStatusOK {
+		t.Errorf("status: want %d, got %d", ImagerStatusOK, imager.Read16(0x00))
+	}
+	if int(imager.Read16(0x06)) != len(frames[0]) {
+		t.Errorf("frame size: want %d, got %d", len(frames[0]), imager.Read16(0x06))
+	}
+	if c.PeripheralIntMask&(1<<3) == 0 {
+		t.Error("expected peripheral interrupt bit for slot 3 to be set after capture")
+	}
+}
+
+func TestImagerPeripheral_BadFormatReportsError(t *testing.T) {
+	c := cpu.NewCPU()
+	imager := NewImagerPeripheral(c, 3, testCapture, func(_ string, frame []byte) {
+		t.Error("no frame should be downlinked for an unknown format")
+	})
+
+	imager.Write16(0x02, ImagerRegFormat)
+	imager.Write16(0x04, 99)
+	imager.Write16(0x00, ImagerCmdCapture)
+
+	if imager.Read16(0x00) != ImagerStatusError {
+		t.Errorf("status: want %d, got %d", ImagerStatusError, imager.Read16(0x00))
+	}
+}
+
+func TestImagerPeripheral_ProgressiveSpooling(t *testing.T) {
+	c := cpu.NewCPU()
+	var frames [][]byte
+	imager := NewImagerPeripheral(c, 3, testCapture, func(_ string, frame []byte) {
+		frames = append(frames, frame)
+	})
+
+	imager.Write16(0x02, ImagerRegFragment)
+	imager.Write16(0x04, 20)
+	imager.Write16(0x02, ImagerRegInterval)
+	imager.Write16(0x04, 2)
+	imager.Write16(0x00, ImagerCmdCapture)
+
+	if imager.Read16(0x00) != ImagerStatusBusy {
+		t.Fatalf("status: want busy, got %d", imager.Read16(0x00))
+	}
+	if len(frames) != 0 {
+		t.Fatal("fragments sent before the first Step")
+	}
+
+	asm := comms.NewProgressiveAssembler()
+	var done bool
+	for i := 0; i < 1000 && !done; i++ {
+		imager.Step()
+		for _, f := range frames {
+			pf, err := asm.Add("Probe1", f)
+			if err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			done = pf.Complete()
+		}
+		frames = nil
+	}
+	if !done {
+		t.Fatal("progressive frame never completed")
+	}
+	if imager.Read16(0x00) != ImagerStatusOK {
+		t.Errorf("status: want OK, got %d", imager.Read16(0x00))
+	}
+	if c.PeripheralIntMask&(1<<3) == 0 {
+		t.Error("expected interrupt once the last fragment was sent")
+	}
+}
+
+func TestImagerPeripheral_AbortProgressive(t *testing.T) {
+	c := cpu.NewCPU()
+	var sent [][]byte
+	imager := NewImagerPeripheral(c, 3, testCapture, func(_ string, frame []byte) { sent = append(sent, frame) })
+
+	imager.Write16(0x02, ImagerRegFragment)
+	imager.Write16(0x04, 20)
+	imager.Write16(0x00, ImagerCmdCapture)
+	imager.Step()
+	imager.Write16(0x00, ImagerCmdAbort)
+	for i := 0; i < 5000; i++ {
+		imager.Step()
+	}
+
+	if len(sent) != 2 {
+		t.Fatalf("sent %d fragments, want 1 and the abort marker", len(sent))
+	}
+	if !bytes.Equal(sent[1], comms.EncodeProgressiveAbort(1)) {
+		t.Error("last fragment is not the abort marker for frame 1")
+	}
+	if imager.Read16(0x00) != ImagerStatusIdle {
+		t.Errorf("status: want idle, got %d", imager.Read16(0x00))
+	}
+}
+
+func TestImagerPeripheral_ProfileRegister(t *testing.T) {
+	c := cpu.NewCPU()
+	imager := NewImagerPeripheral(c, 3, testCapture, func(_ string, frame []byte) {})
+
+	imager.Write16(0x02, ImagerRegProfile)
+	imager.Write16(0x04, 1)
+	if got := imager.Read16(0x04); got != 0 {
+		t.Fatalf("profile without handler: want 0, got %d", got)
+	}
+
+	var applied []uint16
+	imager.SetProfileHandler(func(index uint16) error {
+		if index > 2 {
+			return fmt.Errorf("no camera profile %d", index)
+		}
+		applied = append(applied, index)
+		return nil
+	})
+	imager.Write16(0x04, 2)
+	imager.Write16(0x04, 7)
+	if got := imager.Read16(0x04); got != 2 {
+		t.Errorf("profile readback: want 2, got %d", got)
+	}
+	if len(applied) != 1 || applied[0] != 2 {
+		t.Errorf("handler applied %v, want [2]", applied)
+	}
+}
+
+func TestImagerPeripheral_CameraSpec(t *testing.T) {
+	var got CaptureSettings
+	imager := NewImagerPeripheral(cpu.NewCPU(), 4, func(s CaptureSettings) image.Image {
+		got = s
+		return testCapture(s)
+	}, func(string, []byte) {})
+	imager.SetDeviceName("TELESCOP")
+	if err := imager.SetCameraSpec(universe.TelescopeSpec); err != nil {
+		t.Fatal(err)
+	}
+	if err := imager.SetCameraSpec(universe.CameraSpec{Name: "broken"}); err == nil {
+		t.Error("expected an error for a spec without a resolution")
+	}
+
+	read := func(reg uint16) uint16 {
+		imager.Write16(0x02, reg)
+		return imager.Read16(0x04)
+	}
+	if w, h := read(ImagerRegWidth), read(ImagerRegHeight); w != 128 || h != 128 {
+		t.Errorf("resolution registers = %dx%d, want 128x128", w, h)
+	}
+	if fov := read(ImagerRegFOV); fov < 1490 || fov > 1510 {
+		t.Errorf("FOV register = %d, want about 1500", fov)
+	}
+	if imager.Read16(0x08) != cpu.EncodePeripheralName("TELESCOP", 0x08) {
+		t.Error("name registers do not report the device name")
+	}
+
+	imager.Write16(0x00, ImagerCmdCapture)
+	if got.Spec != universe.TelescopeSpec {
+		t.Errorf("capture spec = %+v, want the telescope", got.Spec)
+	}
+}
+
+func TestImagerPeripheral_ReplyTo(t *testing.T) {
+	c := cpu.NewCPU()
+	var targets []string
+	imager := NewImagerPeripheral(c, 3, testCapture, func(target string, frame []byte) {
+		targets = append(targets, target)
+	})
+	copy(c.Memory[0x7000:], "Mars\x00")
+
+	imager.Write16(0x00, ImagerCmdCapture)
+	imager.Write16(0x02, ImagerRegReplyTo)
+	imager.Write16(0x04, 0x7000)
+	imager.Write16(0x00, ImagerCmdCapture)
+
+	// A progressive frame goes where it was captured for.
+	imager.Write16(0x02, ImagerRegFragment)
+	imager.Write16(0x04, 16)
+	imager.Write16(0x00, ImagerCmdCapture)
+	imager.Write16(0x02, ImagerRegReplyTo)
+	imager.Write16(0x04, 0)
+	imager.Step()
+
+	want := []string{"", "Mars", "Mars"}
+	if fmt.Sprint(targets) != fmt.Sprint(want) {
+		t.Errorf("frames went to %q, want %q", targets, want)
+	}
+}
+
+func TestImagerPeripheral_CaptureInfo(t *testing.T) {
+	c := cpu.NewCPU()
+	var frames [][]byte
+	imager := NewImagerPeripheral(c, 3, testCapture, func(_ string, frame []byte) {
+		frames = append(frames, frame)
+	})
+	tick := uint64(5)
+	imager.SetCaptureInfo(func() comms.CaptureInfo { return comms.CaptureInfo{Tick: tick} })
+
+	imager.Write16(0x00, ImagerCmdCapture)
+	_, hdr, err := comms.DecodeImage(frames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Capture == nil || hdr.Capture.Tick != 5 {
+		t.Errorf("single frame capture info = %+v, want tick 5", hdr.Capture)
+	}
+
+	// A progressive frame is placed when captured, not when sent.
+	frames = nil
+	imager.Write16(0x02, ImagerRegFragment)
+	imager.Write16(0x04, 64)
+	imager.Write16(0x02, ImagerRegInterval)
+	imager.Write16(0x04, 0)
+	imager.Write16(0x00, ImagerCmdCapture)
+	tick = 6
+	asm := comms.NewProgressiveAssembler()
+	var pf *comms.ProgressiveFrame
+	for i := 0; i < 100 && imager.Read16(0x00) == ImagerStatusBusy; i++ {
+		imager.Step()
+	}
+	for _, f := range frames {
+		if pf, err = asm.Add("Probe1", f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !pf.Complete() || pf.Capture == nil || pf.Capture.Tick != 5 {
+		t.Errorf("progressive frame capture info = %+v, want tick 5", pf.Capture)
+	}
+}
+
+func TestImagerPeripheral_CaptureInfoRecordsCamera(t *testing.T) {
+	var frame []byte
+	imager := NewImagerPeripheral(cpu.NewCPU(), 3, testCapture, func(_ string, f []byte) { frame = f })
+	if err := imager.SetCameraSpec(universe.TelescopeSpec); err != nil {
+		t.Fatal(err)
+	}
+	imager.SetProfileHandler(func(uint16) error { return nil })
+	imager.SetCaptureInfo(func() comms.CaptureInfo { return comms.CaptureInfo{Tick: 1} })
+	imager.Write16(0x02, ImagerRegProfile)
+	imager.Write16(0x04, 2)
+
+	imager.Write16(0x00, ImagerCmdCapture)
+	_, hdr, err := comms.DecodeImage(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	render, fov, _ := universe.TelescopeSpec.Layout()
+	if c := hdr.Capture; c == nil || c.FOV != fov || int(c.Render) != render || c.Profile != 2 {
+		t.Errorf("capture info = %+v, want FOV %g, render %d, profile 2", c, fov, render)
+	}
+}
@@ -59,3 +59,25 @@ func (n *NavigationPeripheral) Write16(offset uint16, val uint16) {
 }
 
 func (n *NavigationPeripheral) Step() {}
+
+// navState is the NavigationPeripheral state saved in probe snapshots.
+type navState struct {
+	DX, DY, DZ int16
+}
+
+func (n *NavigationPeripheral) snapshot() ([]byte, error) {
+	return encodeState(navState{DX: n.dx, DY: n.dy, DZ: n.dz})
+}
+
+func (n *NavigationPeripheral) decode(data []byte) (any, error) {
+	var s navState
+	if err := decodeState(data, &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (n *NavigationPeripheral) apply(state any) {
+	s := state.(navState)
+	n.dx, n.dy, n.dz = s.DX, s.DY, s.DZ
+}
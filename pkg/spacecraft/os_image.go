@@ -0,0 +1,159 @@
+package spacecraft
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gocpu/pkg/compiler"
+	"gocpu/pkg/cpu"
+)
+
+//go:embed assets/probe_os.c
+var probeOSSource string
+
+//go:embed assets/basic_probe_os.c
+var basicProbeOSSource string
+
+// GuestRLESource is the C implementation of comms.EncodeRLE/DecodeRLE for
+// probe OS images that compress or expand data themselves.
+//
+//go:embed assets/rle.c
+var GuestRLESource string
+
+// guestLibraries are the sources a probe OS, embedded or not, can include
+// with #include "name".
+var guestLibraries = map[string]string{
+	"rle.c": GuestRLESource,
+}
+
+// DefaultOS is the embedded probe OS booted when ProbeOptions names none.
+const DefaultOS = "probe_os"
+
+// embeddedOS holds the probe OS sources built into the binary, by name.
+var embeddedOS = map[string]string{
+	"probe_os":       probeOSSource,
+	"basic_probe_os": basicProbeOSSource,
+}
+
+// EmbeddedOS returns the names of the probe OS sources built into the binary.
+func EmbeddedOS() []string {
+	names := make([]string, 0, len(embeddedOS))
+	for name := range embeddedOS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// maxImageSize is the largest OS image that fits in VM memory.
+const maxImageSize = len(cpu.CPU{}.Memory)
+
+// ProbeOptions selects the hardware a SpaceProbe is built with and the
+// software it boots. At most one of OS, OSFile and OSBinary may be set; with
+// none, the probe boots DefaultOS.
+type ProbeOptions struct {
+	Hardware *HardwareManifest // DefaultHardware if nil
+	OS       string            // name of an embedded OS, see EmbeddedOS
+	OSFile   string            // C source file, compiled when the probe is built
+	OSBinary []byte            // precompiled machine code
+
+	// GroundStation is the bus ID frames and flight software reports are
+	// downlinked to, GroundStationID if empty.
+	GroundStation string
+}
+
+// Image returns the machine code the options select, compiling it if needed.
+func (o ProbeOptions) Image() ([]byte, error) {
+	set := 0
+	for _, ok := range []bool{o.OS != "", o.OSFile != "", o.OSBinary != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, fmt.Errorf("ProbeOptions.Image: only one of OS, OSFile and OSBinary may be set")
+	}
+
+	switch {
+	case o.OSBinary != nil:
+		if err := checkImage(o.OSBinary); err != nil {
+			return nil, fmt.Errorf("ProbeOptions.Image: %w", err)
+		}
+		return o.OSBinary, nil
+	case o.OSFile != "":
+		src, err := os.ReadFile(o.OSFile)
+		if err != nil {
+			return nil, fmt.Errorf("ProbeOptions.Image: %w", err)
+		}
+		mc, err := compileOS(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("ProbeOptions.Image: %s: %w", o.OSFile, err)
+		}
+		return mc, nil
+	}
+
+	name, src, err := o.embedded()
+	if err != nil {
+		return nil, fmt.Errorf("ProbeOptions.Image: %w", err)
+	}
+	mc, err := compileOS(src)
+	if err != nil {
+		return nil, fmt.Errorf("ProbeOptions.Image: %s: %w", name, err)
+	}
+	return mc, nil
+}
+
+// embedded returns the name and source of the embedded OS the options select.
+func (o ProbeOptions) embedded() (name, src string, err error) {
+	name = o.OS
+	if name == "" {
+		name = DefaultOS
+	}
+	src, ok := embeddedOS[name]
+	if !ok {
+		return name, "", fmt.Errorf("no embedded OS %q, have %v", name, EmbeddedOS())
+	}
+	return name, src, nil
+}
+
+// compileOS compiles probe OS source into a bootable image.
+func compileOS(src string) ([]byte, error) {
+	_, mc, err := compiler.Compile(expandIncludes(src), "")
+	if err != nil {
+		return nil, fmt.Errorf("compile error: %w", err)
+	}
+	if err := checkImage(mc); err != nil {
+		return nil, err
+	}
+	return mc, nil
+}
+
+// expandIncludes replaces each #include "name" line that names a guest
+// library with the library's source. Other includes are left to the compiler.
+func expandIncludes(src string) string {
+	lines := strings.Split(src, "\n")
+	for i, line := range lines {
+		name, ok := strings.CutPrefix(strings.TrimSpace(line), `#include "`)
+		if !ok {
+			continue
+		}
+		if lib, ok := guestLibraries[strings.TrimSuffix(name, `"`)]; ok {
+			lines[i] = lib
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkImage reports whether mc can be booted.
+func checkImage(mc []byte) error {
+	if len(mc) == 0 {
+		return fmt.Errorf("empty OS image")
+	}
+	if len(mc) > maxImageSize {
+		return fmt.Errorf("OS image too large (%d bytes, limit %d)", len(mc), maxImageSize)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package spacecraft
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+func TestProbeOptions_Image(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "test_os.c")
+	if err := os.WriteFile(src, []byte(basicProbeOSSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []ProbeOptions{{}, {OS: "basic_probe_os"}, {OSFile: src}, {OSBinary: []byte{1, 2, 3}}} {
+		mc, err := opts.Image()
+		if err != nil {
+			t.Errorf("%+v: %v", opts, err)
+			continue
+		}
+		if len(mc) == 0 {
+			t.Errorf("%+v: empty image", opts)
+		}
+	}
+
+	bad := []struct {
+		opts ProbeOptions
+		want string
+	}{
+		{ProbeOptions{OS: "no_such_os"}, "no embedded OS"},
+		{ProbeOptions{OSFile: filepath.Join(dir, "missing.c")}, "missing.c"},
+		{ProbeOptions{OSBinary: []byte{}}, "empty OS image"},
+		{ProbeOptions{OSBinary: make([]byte, maxImageSize+1)}, "too large"},
+		{ProbeOptions{OS: DefaultOS, OSBinary: []byte{1}}, "only one"},
+	}
+	for _, tc := range bad {
+		_, err := tc.opts.Image()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want one mentioning %q", tc.want, err, tc.want)
+		}
+	}
+}
+
+func TestNewSpaceProbeWithOptions(t *testing.T) {
+	bus := comms.NewMessageBus()
+	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
+	scene := universe.NewLocalScene(0, 0, 0, 0, 0, 0)
+
+	image := []byte{0xDE, 0xAD, 0xBE, 0xEF}
+	probe, err := NewSpaceProbeWithOptions("Probe1", pos, scene, bus, ProbeOptions{OSBinary: image})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(probe.VM.Memory[:len(image)], image) {
+		t.Errorf("memory = % x, want % x", probe.VM.Memory[:len(image)], image)
+	}
+
+	if _, err := NewSpaceProbeWithOptions("Probe2", pos, scene, bus, ProbeOptions{OS: "no_such_os"}); err == nil {
+		t.Error("expected an error for an unknown OS")
+	}
+	for _, id := range bus.Subscribers() {
+		if id == "Probe2" {
+			t.Error("failed probe subscribed to the bus")
+		}
+	}
+}
+
+func TestExpandIncludes(t *testing.T) {
+	src := "#include <stdio.c>\n  #include \"rle.c\"\n#include \"other.c\"\nint main() {}\n"
+	want := "#include <stdio.c>\n" + GuestRLESource + "\n#include \"other.c\"\nint main() {}\n"
+	if got := expandIncludes(src); got != want {
+		t.Errorf("expandIncludes =\n%s\nwant\n%s", got, want)
+	}
+}
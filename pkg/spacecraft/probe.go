@@ -1,14 +1,13 @@
 package spacecraft
 
 import (
-	_ "embed"
 	"fmt"
 	"image"
 	"image/draw"
 	"image/png"
 	"os"
+	"sync"
 
-	"gocpu/pkg/compiler"
 	"gocpu/pkg/cpu"
 	"gocpu/pkg/peripherals"
 
@@ -16,10 +15,10 @@ import (
 	"github.com/smasonuk/unknowngalaxy/pkg/universe"
 )
 
-// +go:embed assets/basic_probe_os.c
-//
-//go:embed assets/probe_os.c
-var probeOSSource string
+// GroundStationID is the bus ID a probe downlinks imager frames and flight
+// software reports to, unless it is built with another ground station or
+// the guest says otherwise.
+const GroundStationID = "Earth"
 
 // SpaceProbe bundles a physical probe, its virtual CPU, and the message receiver
 // peripheral, wiring them together through the game's message bus.
@@ -27,6 +26,20 @@ type SpaceProbe struct {
 	Physical    *universe.Probe
 	VM          *cpu.CPU
 	MsgReceiver *peripherals.MessageReceiver
+	Imager      *ImagerPeripheral
+	Instruments map[uint8]*ImagerPeripheral // every imager, by slot
+
+	scene    *universe.LocalScene
+	bus      comms.Bus
+	mounts   []func(vm *cpu.CPU)
+	hardware HardwareManifest
+	software *flightSoftware
+	uplinkMu sync.Mutex
+	uplinks  []comms.Message
+	stateful map[uint8]snapshotter // mounted peripherals with saved state
+	debugger *Debugger
+	tick     uint64 // world tick being run, set by World.Step
+	station  string // bus ID downlinks go to
 }
 
 func ConvertToRGBA(img image.Image) *image.RGBA {
@@ -73,62 +86,187 @@ func WriteImageToFile2(img image.RGBA, filename string) {
 	}
 }
 
-// NewSpaceProbe creates a SpaceProbe, mounts its peripherals, and subscribes to the bus.
-func NewSpaceProbe(id string, startPos *universe.GalacticPosition, scene *universe.LocalScene, bus *comms.MessageBus) *SpaceProbe {
-	physical := universe.NewProbe(id, startPos)
-	vm := cpu.NewCPU(id)
+// NewSpaceProbe creates a SpaceProbe with DefaultHardware running DefaultOS,
+// and subscribes it to the bus. If the OS cannot be built the error is logged
+// and the probe is returned with empty memory, in state CPUNoSoftware.
+//
+// Deprecated: use NewSpaceProbeWithOptions, which returns the error.
+func NewSpaceProbe(id string, startPos *universe.GalacticPosition, scene *universe.LocalScene, bus comms.Bus) *SpaceProbe {
+	sp, err := newSpaceProbe(id, startPos, scene, bus, DefaultHardware())
+	if err != nil {
+		panic(err) // the default hardware always mounts
+	}
+	if err := sp.boot(ProbeOptions{}); err != nil {
+		fmt.Printf("[SpaceProbe %s] %v\n", id, err)
+	}
+	return sp
+}
 
-	// Slot 0: Message Sender — outbound messages go onto the bus.
-	dispatchFunc := func(target string, body []byte) {
-		bus.Send(id, target, body)
+// NewSpaceProbeWithOptions creates a SpaceProbe with the hardware and OS opts
+// select. It fails, without subscribing the probe to the bus, if the manifest
+// is invalid or the OS cannot be read, compiled or loaded.
+func NewSpaceProbeWithOptions(id string, startPos *universe.GalacticPosition, scene *universe.LocalScene, bus comms.Bus, opts ProbeOptions) (*SpaceProbe, error) {
+	hw := DefaultHardware()
+	if opts.Hardware != nil {
+		hw = *opts.Hardware
+	}
+	if err := hw.Validate(); err != nil {
+		return nil, fmt.Errorf("NewSpaceProbeWithOptions: %s: %w", id, err)
+	}
+	mc, err := opts.Image()
+	if err != nil {
+		return nil, fmt.Errorf("NewSpaceProbeWithOptions: %s: %w", id, err)
 	}
-	vm.MountPeripheral(0, peripherals.NewMessageSender(vm, 0, dispatchFunc))
+	sp, err := newSpaceProbe(id, startPos, scene, bus, hw)
+	if err != nil {
+		return nil, fmt.Errorf("NewSpaceProbeWithOptions: %s: %w", id, err)
+	}
+	if opts.GroundStation != "" {
+		sp.station = opts.GroundStation
+	}
+	sp.load(mc)
+	return sp, nil
+}
 
-	// Slot 1: Camera — captures the probe's local scene view.
-	captureFunc := func() *image.RGBA {
-		img := scene.TakePicture(physical, 128, 128)
-		WriteImageToFile(img, "1111.png")
+// GroundStation returns the bus ID the probe downlinks to.
+func (sp *SpaceProbe) GroundStation() string {
+	return sp.station
+}
 
-		ConvertToRGBA(img)
-		rgba := ConvertToRGBA(img)
+// newSpaceProbe builds a probe with the peripherals of a validated manifest
+// and empty memory. It fails, without subscribing the probe to the bus, if a
+// peripheral cannot be mounted.
+func newSpaceProbe(id string, startPos *universe.GalacticPosition, scene *universe.LocalScene, bus comms.Bus, hw HardwareManifest) (*SpaceProbe, error) {
+	sp := &SpaceProbe{
+		Physical:    universe.NewProbe(id, startPos),
+		VM:          cpu.NewCPU(id),
+		Instruments: make(map[uint8]*ImagerPeripheral),
+		scene:       scene,
+		bus:         bus,
+		stateful:    make(map[uint8]snapshotter),
+		software:    &flightSoftware{timeout: DefaultBootTimeout},
+		station:     GroundStationID,
+	}
+	if err := sp.mountHardware(hw); err != nil {
+		return nil, err
+	}
 
-		WriteImageToFile2(*rgba, "2222.png")
+	// Subscribe to the bus so incoming messages are pushed into the receiver.
+	// Flight software uplinks are instead queued for the loader.
+	bus.Subscribe(id, sp.receive)
 
-		return rgba
+	return sp, nil
+}
+
+// boot loads the OS opts selects into VM memory.
+func (sp *SpaceProbe) boot(opts ProbeOptions) error {
+	mc, err := opts.Image()
+	if err != nil {
+		return err
 	}
-	vm.MountPeripheral(1, peripherals.NewCameraPeripheral(vm, 1, captureFunc))
+	sp.load(mc)
+	return nil
+}
+
+// load makes mc the probe's software and copies it into VM memory.
+func (sp *SpaceProbe) load(mc []byte) {
+	sp.software.current = mc
+	copy(sp.VM.Memory[:], mc)
+}
 
-	// Slot 2: Message Receiver — inbound messages land in the VFS queue.
-	msgReceiver := peripherals.NewMessageReceiver(vm, 2)
-	vm.MountPeripheral(2, msgReceiver)
+// mount records how to attach a peripheral, so it is attached again to the
+// fresh VM of every reboot, and attaches it to the running VM.
+func (sp *SpaceProbe) mount(attach func(vm *cpu.CPU)) {
+	sp.mounts = append(sp.mounts, attach)
+	attach(sp.VM)
+}
 
-	sp := &SpaceProbe{
-		Physical:    physical,
-		VM:          vm,
-		MsgReceiver: msgReceiver,
+// Hardware returns the manifest of the peripherals mounted on the probe,
+// including instruments added by MountInstrument.
+func (sp *SpaceProbe) Hardware() HardwareManifest {
+	return HardwareManifest{Peripherals: append([]PeripheralSpec(nil), sp.hardware.Peripherals...)}
+}
+
+// Scene returns the scene the probe photographs.
+func (sp *SpaceProbe) Scene() *universe.LocalScene {
+	return sp.scene
+}
+
+// MountInstrument mounts an additional imager with its own resolution and
+// field of view, such as universe.TelescopeSpec or universe.NavCamSpec, on a
+// free slot. The guest finds it by name and reads its spec from the imager
+// registers; its frames are downlinked like the primary imager's. A reboot
+// replaces the imager with a fresh one, found in Instruments.
+func (sp *SpaceProbe) MountInstrument(slot uint8, name string, spec universe.CameraSpec) (*ImagerPeripheral, error) {
+	p := PeripheralSpec{Slot: slot, Kind: KindImager, Name: name, Camera: &spec}
+	hw := sp.Hardware()
+	hw.Peripherals = append(hw.Peripherals, p)
+	if err := hw.Validate(); err != nil {
+		return nil, fmt.Errorf("MountInstrument: %w", err)
+	}
+	if err := sp.mountPeripheral(p); err != nil {
+		return nil, fmt.Errorf("MountInstrument: %w", err)
 	}
+	return sp.Instruments[slot], nil
+}
 
-	// Subscribe to the bus so incoming messages are pushed into the receiver.
-	bus.Subscribe(id, func(m comms.Message) {
-		_ = msgReceiver.PushMessage(m.SenderID, m.Payload)
+// mountImager mounts an imager whose frames are encoded in a guest-selected
+// format and downlinked straight to the bus ID the guest replies to, or the
+// probe's ground station.
+func (sp *SpaceProbe) mountImager(slot uint8, name string, spec universe.CameraSpec) error {
+	var err error
+	sp.mount(func(vm *cpu.CPU) {
+		imager := NewImagerPeripheral(vm, slot, sp.captureFrame, func(target string, frame []byte) {
+			if target == "" {
+				target = sp.station
+			}
+			sp.bus.Send(sp.Physical.ID, target, frame)
+		})
+		if err = imager.SetCameraSpec(spec); err != nil {
+			return
+		}
+		imager.SetDeviceName(name)
+		imager.SetCaptureInfo(sp.captureInfo)
+		imager.SetProfileHandler(func(index uint16) error {
+			profile, err := universe.CameraProfileAt(int(index))
+			if err != nil {
+				return err
+			}
+			sp.Physical.Profile = profile
+			return nil
+		})
+		sp.attach(vm, slot, imager)
+		sp.Instruments[slot] = imager
+		if name == DefaultImagerName {
+			sp.Imager = imager
+		}
 	})
-
-	// Compile and load the probe OS into VM memory.
-	_, mc, err := compiler.Compile(probeOSSource, "")
 	if err != nil {
-		fmt.Printf("[SpaceProbe %s] OS compile error: %v\n", id, err)
-	} else if len(mc) > len(vm.Memory) {
-		fmt.Printf("[SpaceProbe %s] OS binary too large (%d bytes)\n", id, len(mc))
-	} else {
-		copy(vm.Memory[:], mc)
+		// Forget the mount, so a reboot does not attach a spec-less imager.
+		sp.mounts = sp.mounts[:len(sp.mounts)-1]
+		return err
 	}
+	return nil
+}
 
-	return sp
+// captureInfo reports the tick the probe is on and where it is pointing.
+func (sp *SpaceProbe) captureInfo() comms.CaptureInfo {
+	p := sp.Physical.Position
+	return comms.CaptureInfo{
+		Tick:     sp.tick,
+		Sector:   [3]int64{p.SectorX, p.SectorY, p.SectorZ},
+		System:   [3]int64{p.SystemX, p.SystemY, p.SystemZ},
+		Local:    [3]float64{p.LocalX, p.LocalY, p.LocalZ},
+		Pointing: [3]float64{sp.Physical.LookAt.X, sp.Physical.LookAt.Y, sp.Physical.LookAt.Z},
+	}
 }
 
-// Tick advances the VM by the given number of cycles.
-func (sp *SpaceProbe) Tick(cycles int) {
-	for i := 0; i < cycles; i++ {
-		sp.VM.Step()
+// captureFrame renders the probe's view for an imager.
+func (sp *SpaceProbe) captureFrame(s CaptureSettings) image.Image {
+	img, err := sp.scene.TakeExposure(sp.Physical, s.Spec, s.Exposure, s.Gain)
+	if err != nil {
+		fmt.Printf("[SpaceProbe %s] capture failed: %v\n", sp.Physical.ID, err)
+		return image.NewRGBA(image.Rect(0, 0, s.Spec.Width, s.Spec.Height))
 	}
+	return img
 }
@@ -14,13 +14,16 @@ func TestSpaceProbe_ReceivesMessageViaBus(t *testing.T) {
 	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
 	scene := universe.NewLocalScene(0, 0, 0, 0, 0, 0)
 
-	probe := NewSpaceProbe("Probe1", pos, scene, bus)
+	probe, err := NewSpaceProbeWithOptions("Probe1", pos, scene, bus, ProbeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	payload := []byte("ping")
 	bus.Send("Earth", "Probe1", payload)
 
 	// Message must not be in the queue before Tick
-	_, err := probe.VM.Disk.Read(".msgq.sys")
+	_, err = probe.VM.Disk.Read(".msgq.sys")
 	if err == nil {
 		t.Fatal("expected .msgq.sys to be absent before bus.Tick()")
 	}
@@ -0,0 +1,312 @@
+package spacecraft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+// Probe snapshot file layout, integers little-endian:
+//
+//	[Magic "UGPS"][Version: uint16][gob-encoded ProbeSnapshot]
+//
+// Version changes whenever ProbeSnapshot changes incompatibly.
+const (
+	snapshotMagic        = "UGPS"
+	ProbeSnapshotVersion = 1
+)
+
+// SoftwareState is the flight software loader's state.
+type SoftwareState struct {
+	Current, Previous []byte
+	Generation        uint16
+	Timeout           int
+	Trial             bool
+	Remaining         int
+	Confirmed         bool
+	RolledBack        bool
+	Faults            int
+	FaultStreak       int
+	LastFault         string
+}
+
+// ProbeSnapshot is the complete state of a SpaceProbe: its VM, VFS,
+// peripheral registers, flight software and physical probe. The internal
+// state of gocpu's own peripherals is not captured; the message receiver's
+// queue is, as it lives in the VFS.
+type ProbeSnapshot struct {
+	ID       string
+	Hardware HardwareManifest
+
+	Memory    []byte
+	Registers Registers
+	Halted    bool
+	IntMask   uint16
+	Files     map[string][]byte
+	// Peripherals holds the state of each peripheral that has any, by slot,
+	// as JSON: unlike a standalone gob stream it encodes the same way in
+	// every process, so snapshots of the same state are byte-identical.
+	Peripherals   map[uint8][]byte
+	Software      SoftwareState
+	Uplinks       []comms.Message // received but not yet applied
+	GroundStation string          // bus ID the probe downlinks to
+
+	Position universe.GalacticPosition
+	LookAt   si3d.Vector3
+	Pointed  bool
+	Profile  universe.CameraProfile
+	Frames   uint64
+	Exposure float64
+	Gain     float64
+}
+
+// snapshotter is a peripheral with internal state saved in snapshots.
+// Restoring is split in two so that a probe can check every peripheral's
+// data before changing any: decode parses data without touching the
+// peripheral, and apply sets the state decode returned.
+type snapshotter interface {
+	snapshot() ([]byte, error)
+	decode(data []byte) (any, error)
+	apply(state any)
+}
+
+func encodeState(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func decodeState(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// Snapshot captures the probe's state. It must not run concurrently with
+// Tick.
+func (sp *SpaceProbe) Snapshot() (*ProbeSnapshot, error) {
+	fw := sp.software
+	p := sp.Physical
+	s := &ProbeSnapshot{
+		ID:          p.ID,
+		Hardware:    sp.Hardware(),
+		Memory:      append([]byte(nil), sp.VM.Memory[:]...),
+		Registers:   vmRegisters(sp.VM),
+		Halted:      vmHalted(sp.VM),
+		IntMask:     sp.VM.PeripheralIntMask,
+		Peripherals: make(map[uint8][]byte),
+		Software: SoftwareState{
+			Current: fw.current, Previous: fw.previous, Generation: fw.generation,
+			Timeout: fw.timeout, Trial: fw.trial, Remaining: fw.remaining,
+			Confirmed: fw.confirmed, RolledBack: fw.rolledBack,
+			Faults: fw.faults, FaultStreak: fw.faultStreak, LastFault: fw.lastFault,
+		},
+		GroundStation: sp.station,
+		Position:      *p.Position,
+		LookAt:        p.LookAt,
+		Pointed:       p.Pointed,
+		Profile:       p.Profile,
+		Frames:        p.Frames,
+		Exposure:      p.Exposure,
+		Gain:          p.Gain,
+	}
+	files, err := diskFiles(sp.VM)
+	if err != nil {
+		return nil, fmt.Errorf("Snapshot: %w", err)
+	}
+	s.Files = files
+	for slot, per := range sp.stateful {
+		data, err := per.snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("Snapshot: slot %d: %w", slot, err)
+		}
+		s.Peripherals[slot] = data
+	}
+	sp.uplinkMu.Lock()
+	s.Uplinks = append([]comms.Message(nil), sp.uplinks...)
+	sp.uplinkMu.Unlock()
+	return s, nil
+}
+
+// Restore replaces the probe's state with a snapshot's. The probe must have
+// the hardware the snapshot was taken with; its ID may differ. If Restore
+// fails the probe is left as it was.
+func (sp *SpaceProbe) Restore(s *ProbeSnapshot) error {
+	if !reflect.DeepEqual(sp.hardware, s.Hardware) {
+		return fmt.Errorf("Restore: snapshot of %s has different hardware", s.ID)
+	}
+	states := make(map[uint8]any, len(s.Peripherals))
+	for slot, data := range s.Peripherals {
+		per := sp.stateful[slot]
+		if per == nil {
+			return fmt.Errorf("Restore: no peripheral with state on slot %d", slot)
+		}
+		state, err := per.decode(data)
+		if err != nil {
+			return fmt.Errorf("Restore: slot %d: %w", slot, err)
+		}
+		states[slot] = state
+	}
+
+	vm := freshVM(sp.Physical.ID, nil)
+	names := make([]string, 0, len(s.Files))
+	for name := range s.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writeDiskFile(vm, name, s.Files[name]); err != nil {
+			return fmt.Errorf("Restore: %s: %w", name, err)
+		}
+	}
+	sp.VM = vm
+	for _, attach := range sp.mounts {
+		attach(vm)
+	}
+	for slot, state := range states {
+		sp.stateful[slot].apply(state)
+	}
+	copy(vm.Memory[:], s.Memory)
+	restoreVM(vm, s.Registers, s.Halted, s.IntMask)
+
+	w := s.Software
+	*sp.software = flightSoftware{
+		current: w.Current, previous: w.Previous, generation: w.Generation,
+		timeout: w.Timeout, trial: w.Trial, remaining: w.Remaining,
+		confirmed: w.Confirmed, rolledBack: w.RolledBack,
+		faults: w.Faults, faultStreak: w.FaultStreak, lastFault: w.LastFault,
+	}
+	sp.uplinkMu.Lock()
+	sp.uplinks = append([]comms.Message(nil), s.Uplinks...)
+	sp.uplinkMu.Unlock()
+
+	sp.station = s.GroundStation
+	p := sp.Physical
+	*p.Position = s.Position
+	if s.Pointed {
+		p.PointCamera(s.LookAt)
+	} else {
+		p.UnpointCamera()
+	}
+	p.Profile, p.Frames, p.Exposure, p.Gain = s.Profile, s.Frames, s.Exposure, s.Gain
+	return nil
+}
+
+// NewSpaceProbeFromSnapshot builds a probe with the snapshot's hardware and
+// restores its state. An empty id keeps the snapshot's, so giving a new one
+// forks the probe.
+func NewSpaceProbeFromSnapshot(s *ProbeSnapshot, id string, scene *universe.LocalScene, bus comms.Bus) (*SpaceProbe, error) {
+	if id == "" {
+		id = s.ID
+	}
+	if err := s.Hardware.Validate(); err != nil {
+		return nil, fmt.Errorf("NewSpaceProbeFromSnapshot: %w", err)
+	}
+	pos := s.Position
+	sp, err := newSpaceProbe(id, &pos, scene, bus, s.Hardware)
+	if err != nil {
+		return nil, fmt.Errorf("NewSpaceProbeFromSnapshot: %w", err)
+	}
+	if err := sp.Restore(s); err != nil {
+		return nil, fmt.Errorf("NewSpaceProbeFromSnapshot: %w", err)
+	}
+	return sp, nil
+}
+
+// Fork returns a copy of the probe, in the same scene, subscribed to bus as
+// id, to try something risky on. The copy needs an ID of its own, so id may
+// be neither empty nor the probe's.
+func (sp *SpaceProbe) Fork(id string, bus comms.Bus) (*SpaceProbe, error) {
+	if id == "" || id == sp.Physical.ID {
+		return nil, fmt.Errorf("Fork: %s: fork ID %q is not a new ID", sp.Physical.ID, id)
+	}
+	s, err := sp.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("Fork: %w", err)
+	}
+	return NewSpaceProbeFromSnapshot(s, id, sp.scene, bus)
+}
+
+// WriteTo writes the snapshot in the versioned file format.
+func (s *ProbeSnapshot) WriteTo(w io.Writer) (int64, error) {
+	var header [6]byte
+	copy(header[:], snapshotMagic)
+	binary.LittleEndian.PutUint16(header[4:], ProbeSnapshotVersion)
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(header[:]); err != nil {
+		return cw.n, fmt.Errorf("ProbeSnapshot.WriteTo: %w", err)
+	}
+	if err := gob.NewEncoder(cw).Encode(s); err != nil {
+		return cw.n, fmt.Errorf("ProbeSnapshot.WriteTo: %w", err)
+	}
+	return cw.n, nil
+}
+
+// ReadProbeSnapshot reads a snapshot written by ProbeSnapshot.WriteTo.
+func ReadProbeSnapshot(r io.Reader) (*ProbeSnapshot, error) {
+	var header [6]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("ReadProbeSnapshot: %w", err)
+	}
+	if string(header[:4]) != snapshotMagic {
+		return nil, fmt.Errorf("ReadProbeSnapshot: not a probe snapshot")
+	}
+	if v := binary.LittleEndian.Uint16(header[4:]); v != ProbeSnapshotVersion {
+		return nil, fmt.Errorf("ReadProbeSnapshot: version %d, want %d", v, ProbeSnapshotVersion)
+	}
+	s := new(ProbeSnapshot)
+	if err := gob.NewDecoder(r).Decode(s); err != nil {
+		return nil, fmt.Errorf("ReadProbeSnapshot: %w", err)
+	}
+	return s, nil
+}
+
+// SaveSnapshot writes the probe's state to a file.
+func (sp *SpaceProbe) SaveSnapshot(path string) error {
+	s, err := sp.Snapshot()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("SaveSnapshot: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	if _, err := s.WriteTo(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("SaveSnapshot: %w", err)
+	}
+	return f.Close()
+}
+
+// LoadSnapshot reads a snapshot file written by SaveSnapshot.
+func LoadSnapshot(path string) (*ProbeSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadSnapshot: %w", err)
+	}
+	defer f.Close()
+	return ReadProbeSnapshot(bufio.NewReader(f))
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,215 @@
+package spacecraft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+func newSnapshotProbe(t *testing.T, bus comms.Bus) *SpaceProbe {
+	t.Helper()
+	hw := DefaultHardware()
+	hw.Peripherals = append(hw.Peripherals, PeripheralSpec{Slot: 5, Kind: KindNavigation})
+	pos := universe.NewGalacticPosition(1, 2, 3, 4, 5, 6, 7, 8, 9)
+	scene := universe.NewLocalScene(1, 2, 3, 4, 5, 6)
+	probe, err := NewSpaceProbeWithOptions("Probe1", pos, scene, bus, ProbeOptions{Hardware: &hw, OSBinary: []byte{9, 8, 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return probe
+}
+
+func TestSpaceProbe_SnapshotRoundTrip(t *testing.T) {
+	bus := comms.NewMessageBus()
+	probe := newSnapshotProbe(t, bus)
+
+	nav := probe.stateful[5].(*NavigationPeripheral)
+	nav.Write16(0x02, uint16(0xFFF6)) // dx = -10
+	nav.Write16(0x06, 42)
+	probe.Imager.Write16(0x02, ImagerRegFormat)
+	probe.Imager.Write16(0x04, uint16(comms.FormatGray8))
+	if err := writeDiskFile(probe.VM, "LOG.TXT", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	probe.VM.Memory[0x4000] = 0xAB
+	restoreVM(probe.VM, Registers{PC: 0x0123}, false, 1<<3)
+	probe.Physical.Position.Move(100, 0, 0)
+	probe.Physical.PointCamera(si3d.NewVector3(0, -200, 0))
+	probe.Physical.Frames = 17
+	probe.Physical.Profile = universe.CameraProfiles[1]
+	bus.Send(GroundStationID, "Probe1", comms.EncodeUplink(comms.UplinkMachineCode, []byte{1, 2}))
+	bus.Tick()
+
+	s, err := probe.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadProbeSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fork, err := NewSpaceProbeFromSnapshot(read, "Fork", probe.scene, comms.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.Physical.ID != "Fork" {
+		t.Errorf("fork ID = %s", fork.Physical.ID)
+	}
+	fnav := fork.stateful[5].(*NavigationPeripheral)
+	if int16(fnav.Read16(0x02)) != -10 || fnav.Read16(0x06) != 42 {
+		t.Errorf("navigation registers = %d, %d", int16(fnav.Read16(0x02)), fnav.Read16(0x06))
+	}
+	if fork.Imager.Read16(0x04) != uint16(comms.FormatGray8) {
+		t.Error("imager format not restored")
+	}
+	if data, err := fork.VM.Disk.Read("LOG.TXT"); err != nil || string(data) != "hello" {
+		t.Errorf("LOG.TXT = %q, %v", data, err)
+	}
+	if fork.VM.Memory[0x4000] != 0xAB || vmPC(fork.VM) != 0x0123 || fork.VM.PeripheralIntMask != 1<<3 {
+		t.Error("VM memory or registers not restored")
+	}
+	if *fork.Physical.Position != *probe.Physical.Position {
+		t.Errorf("position = %+v, want %+v", *fork.Physical.Position, *probe.Physical.Position)
+	}
+	if !fork.Physical.Pointed || fork.Physical.LookAt != probe.Physical.LookAt {
+		t.Error("camera pointing not restored")
+	}
+	if fork.Physical.Frames != 17 || fork.Physical.Profile.Name != universe.CameraProfiles[1].Name {
+		t.Error("camera state not restored")
+	}
+	if !bytes.Equal(fork.software.current, []byte{9, 8, 7}) {
+		t.Errorf("software = % x", fork.software.current)
+	}
+	if len(fork.uplinks) != 1 {
+		t.Errorf("got %d pending uplinks, want 1", len(fork.uplinks))
+	}
+
+	// The fork is independent of the original.
+	probe.Physical.Position.Move(0, 0, 500)
+	nav.Write16(0x06, 1)
+	if *fork.Physical.Position == *probe.Physical.Position || fnav.Read16(0x06) != 42 {
+		t.Error("fork shares state with the original")
+	}
+}
+
+func TestSpaceProbe_ForkNeedsNewID(t *testing.T) {
+	probe := newSnapshotProbe(t, comms.NewMessageBus())
+	for _, id := range []string{"", "Probe1"} {
+		if _, err := probe.Fork(id, comms.NewMessageBus()); err == nil {
+			t.Errorf("Fork(%q) succeeded", id)
+		}
+	}
+}
+
+func TestSpaceProbe_RestoredProbeOSServicesMessage(t *testing.T) {
+	probe, _, _ := bootProbeOS(t)
+	if !vmRegisters(probe.VM).IntEnabled {
+		t.Fatalf("probe OS has not enabled interrupts; PC %04x, halted %v", vmPC(probe.VM), vmHalted(probe.VM))
+	}
+
+	bus := comms.NewMessageBus()
+	ground := recordGround(bus)
+	fork, err := probe.Fork("Probe2", bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := vmRegisters(fork.VM); r != vmRegisters(probe.VM) {
+		t.Errorf("fork registers = %+v, want %+v", r, vmRegisters(probe.VM))
+	}
+	bus.Send(GroundStationID, "Probe2", []byte("TAKE_PICTURE"))
+	if !runProbeOS(fork, bus, func() bool { return imageFrom(*ground) != nil }) {
+		t.Fatalf("restored probe OS did not answer TAKE_PICTURE; PC %04x", vmPC(fork.VM))
+	}
+}
+
+func TestSpaceProbe_RestoreRejectsOtherHardware(t *testing.T) {
+	bus := comms.NewMessageBus()
+	s, err := newSnapshotProbe(t, bus).Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
+	other, err := NewSpaceProbeWithOptions("Probe2", pos, universe.NewLocalScene(0, 0, 0, 0, 0, 0), bus, ProbeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Restore(s); err == nil || !strings.Contains(err.Error(), "different hardware") {
+		t.Errorf("got %v, want a hardware mismatch error", err)
+	}
+}
+
+func TestSpaceProbe_FailedRestoreLeavesProbe(t *testing.T) {
+	bus := comms.NewMessageBus()
+	probe := newSnapshotProbe(t, bus)
+	s, err := probe.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Peripherals[5] = []byte("not json")
+	s.Memory[0x4000] = 0xCD
+
+	vm, imager := probe.VM, probe.Imager
+	probe.VM.Memory[0x4000] = 0xAB
+	if err := probe.Restore(s); err == nil || !strings.Contains(err.Error(), "slot 5") {
+		t.Fatalf("got %v, want a slot 5 error", err)
+	}
+	if probe.VM != vm || probe.Imager != imager || probe.VM.Memory[0x4000] != 0xAB {
+		t.Error("failed restore changed the probe")
+	}
+}
+
+func TestSpaceProbe_RestoreUnpointsCamera(t *testing.T) {
+	bus := comms.NewMessageBus()
+	probe := newSnapshotProbe(t, bus)
+	s, err := probe.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.Physical.PointCamera(si3d.NewVector3(0, -200, 0))
+	if err := probe.Restore(s); err != nil {
+		t.Fatal(err)
+	}
+	p := probe.Physical
+	if p.Pointed || p.LookAt != (si3d.Vector3{}) {
+		t.Errorf("pointed = %v, look at %+v after restoring an unpointed snapshot", p.Pointed, p.LookAt)
+	}
+	if *p.Camera != *si3d.NewCamera(0, 0, 0, 0, 0, 0) {
+		t.Error("camera not reset to the unpointed default")
+	}
+}
+
+func TestProbeSnapshot_FileVersion(t *testing.T) {
+	bus := comms.NewMessageBus()
+	probe := newSnapshotProbe(t, bus)
+	path := filepath.Join(t.TempDir(), "probe.snap")
+	if err := probe.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ := probe.Snapshot()
+	var buf bytes.Buffer
+	s.WriteTo(&buf)
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint16(data[4:], ProbeSnapshotVersion+1)
+	if _, err := ReadProbeSnapshot(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("got %v, want a version error", err)
+	}
+	if _, err := ReadProbeSnapshot(strings.NewReader("nonsense")); err == nil {
+		t.Error("expected an error for a non-snapshot")
+	}
+}
@@ -0,0 +1,113 @@
+package spacecraft
+
+import (
+	"fmt"
+
+	"gocpu/pkg/cpu"
+)
+
+// The original probe was built with these gocpu calls: NewCPU, Memory, Step,
+// MountPeripheral, TriggerPeripheralInterrupt, EncodePeripheralName,
+// Disk.Read, compiler.Compile and the peripherals constructors. The calls
+// added since, Disk.Write, Disk.List, Halted, PC, SP, Regs, Flags and
+// InterruptsEnabled, are kept in this file, so a change in the emulator
+// touches one file. The PeripheralIntMask field is new as well, and is read
+// directly elsewhere in the package.
+//
+// vm_test.go pins the behaviour the probe relies on and boots the embedded
+// probe OS end to end, so it must pass against the gocpu the game is built
+// with. Other tests boot placeholder images and do not depend on what the
+// emulator executes.
+
+// readDiskFile returns a file from the VM's VFS.
+func readDiskFile(vm *cpu.CPU, name string) ([]byte, error) {
+	return vm.Disk.Read(name)
+}
+
+// writeDiskFile stores a file in the VM's VFS, replacing any file of that
+// name.
+func writeDiskFile(vm *cpu.CPU, name string, data []byte) error {
+	return vm.Disk.Write(name, data)
+}
+
+// diskFiles returns a copy of every file in the VM's VFS.
+func diskFiles(vm *cpu.CPU) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, name := range vm.Disk.List() {
+		data, err := vm.Disk.Read(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		files[name] = append([]byte(nil), data...)
+	}
+	return files, nil
+}
+
+// freshVM returns a reset CPU that shares the VFS of old, if any.
+func freshVM(id string, old *cpu.CPU) *cpu.CPU {
+	vm := cpu.NewCPU(id)
+	if old != nil {
+		vm.Disk = old.Disk
+	}
+	return vm
+}
+
+// stepVM executes one instruction, turning an emulator panic, such as a
+// guest jumping into garbage, into an error.
+func stepVM(vm *cpu.CPU) (fault error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fault = fmt.Errorf("%v", r)
+		}
+	}()
+	vm.Step()
+	return nil
+}
+
+// vmHalted reports whether the guest has executed HALT.
+func vmHalted(vm *cpu.CPU) bool {
+	return vm.Halted
+}
+
+// diskUsage returns the number of files in the VM's VFS and their total size.
+func diskUsage(vm *cpu.CPU) (files, bytes int) {
+	for _, name := range vm.Disk.List() {
+		data, err := vm.Disk.Read(name)
+		if err != nil {
+			continue
+		}
+		files++
+		bytes += len(data)
+	}
+	return files, bytes
+}
+
+// vmPC returns the address of the next instruction.
+func vmPC(vm *cpu.CPU) uint16 {
+	return vm.PC
+}
+
+// Registers is the CPU register file, with the condition flags and whether
+// the guest has enabled interrupts.
+type Registers struct {
+	PC, SP     uint16
+	R          [8]uint16
+	Flags      uint16
+	IntEnabled bool
+}
+
+// vmRegisters returns the VM's registers.
+func vmRegisters(vm *cpu.CPU) Registers {
+	return Registers{
+		PC: vm.PC, SP: vm.SP, R: vm.Regs,
+		Flags: vm.Flags, IntEnabled: vm.InterruptsEnabled,
+	}
+}
+
+// restoreVM sets the CPU state saved in a snapshot.
+func restoreVM(vm *cpu.CPU, r Registers, halted bool, intMask uint16) {
+	vm.PC, vm.SP, vm.Regs = r.PC, r.SP, r.R
+	vm.Flags, vm.InterruptsEnabled = r.Flags, r.IntEnabled
+	vm.Halted = halted
+	vm.PeripheralIntMask = intMask
+}
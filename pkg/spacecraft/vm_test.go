@@ -0,0 +1,137 @@
+package spacecraft
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+// The tests in this file pin what the probe assumes of the gocpu calls in
+// vm.go, so they fail if the emulator the game is built with behaves
+// differently.
+
+func TestVM_DiskFiles(t *testing.T) {
+	vm := freshVM("Probe1", nil)
+	for _, f := range []struct{ name, data string }{{"A.TXT", "first"}, {"B.BIN", "xy"}, {"A.TXT", "second"}} {
+		if err := writeDiskFile(vm, f.name, []byte(f.data)); err != nil {
+			t.Fatalf("writeDiskFile(%s): %v", f.name, err)
+		}
+	}
+	if data, err := readDiskFile(vm, "A.TXT"); err != nil || string(data) != "second" {
+		t.Errorf("A.TXT = %q, %v; want the second write", data, err)
+	}
+	if _, err := readDiskFile(vm, "MISSING"); err == nil {
+		t.Error("reading a missing file succeeded")
+	}
+
+	files, err := diskFiles(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || !bytes.Equal(files["B.BIN"], []byte("xy")) {
+		t.Errorf("diskFiles = %q", files)
+	}
+	files["B.BIN"][0] = 'z'
+	if data, _ := readDiskFile(vm, "B.BIN"); string(data) != "xy" {
+		t.Error("diskFiles returned the VFS's own buffer")
+	}
+	if n, size := diskUsage(vm); n != 2 || size != len("second")+len("xy") {
+		t.Errorf("diskUsage = %d files, %d bytes", n, size)
+	}
+
+	rebooted := freshVM("Probe1", vm)
+	if data, err := readDiskFile(rebooted, "B.BIN"); err != nil || string(data) != "xy" {
+		t.Errorf("VFS after reboot: %q, %v", data, err)
+	}
+}
+
+func TestVM_RegistersRoundTrip(t *testing.T) {
+	vm := freshVM("Probe1", nil)
+	want := Registers{PC: 0x1234, SP: 0xEFF0, R: [8]uint16{1, 2, 3, 4, 5, 6, 7, 8}, Flags: 0x5, IntEnabled: true}
+	restoreVM(vm, want, true, 1<<3)
+
+	if got := vmRegisters(vm); got != want {
+		t.Errorf("registers = %+v, want %+v", got, want)
+	}
+	if vmPC(vm) != want.PC || !vmHalted(vm) || vm.PeripheralIntMask != 1<<3 {
+		t.Errorf("PC %04x, halted %v, interrupt mask %04x", vmPC(vm), vmHalted(vm), vm.PeripheralIntMask)
+	}
+
+	fresh := freshVM("Probe1", vm)
+	if vmHalted(fresh) || vmRegisters(fresh) == want || fresh.PeripheralIntMask != 0 {
+		t.Error("freshVM kept CPU state from the old VM")
+	}
+}
+
+// The probe OS tests give the OS probeOSBootRounds rounds of probeOSCycles
+// to enable interrupts, and probeOSRounds to answer a message.
+const (
+	probeOSBootRounds = 10
+	probeOSRounds     = 200
+	probeOSCycles     = 10000
+)
+
+// bootProbeOS builds a probe running the embedded probe OS, boots it and
+// returns it with its bus and the messages the bus delivers to the ground
+// station.
+func bootProbeOS(t *testing.T) (*SpaceProbe, *comms.MessageBus, *[]comms.Message) {
+	t.Helper()
+	bus := comms.NewMessageBus()
+	ground := recordGround(bus)
+	pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, 0, 0, 0)
+	scene := universe.NewLocalScene(0, 0, 0, 0, 0, 0)
+	probe := NewSpaceProbe("Probe1", pos, scene, bus)
+	for i := 0; i < probeOSBootRounds; i++ {
+		probe.Tick(probeOSCycles)
+	}
+	return probe, bus, ground
+}
+
+// recordGround returns the messages bus delivers to the ground station.
+func recordGround(bus *comms.MessageBus) *[]comms.Message {
+	var ground []comms.Message
+	bus.Subscribe(GroundStationID, func(m comms.Message) {
+		ground = append(ground, m)
+	})
+	return &ground
+}
+
+// runProbeOS steps the probe and its bus until done reports true, and reports
+// whether it did before the round limit.
+func runProbeOS(probe *SpaceProbe, bus *comms.MessageBus, done func() bool) bool {
+	for i := 0; i < probeOSRounds; i++ {
+		bus.Tick()
+		probe.Tick(probeOSCycles)
+		if done() {
+			return true
+		}
+	}
+	return false
+}
+
+// imageFrom returns the first image container among msgs, or nil.
+func imageFrom(msgs []comms.Message) []byte {
+	for _, m := range msgs {
+		if comms.IsImageContainer(m.Payload) {
+			return m.Payload
+		}
+	}
+	return nil
+}
+
+func TestVM_ProbeOSTakesPicture(t *testing.T) {
+	probe, bus, ground := bootProbeOS(t)
+	bus.Send(GroundStationID, probe.Physical.ID, []byte("TAKE_PICTURE"))
+	if !runProbeOS(probe, bus, func() bool { return imageFrom(*ground) != nil }) {
+		t.Fatalf("no frame after TAKE_PICTURE; PC %04x, halted %v", vmPC(probe.VM), vmHalted(probe.VM))
+	}
+	_, hdr, err := comms.DecodeImage(imageFrom(*ground))
+	if err != nil {
+		t.Fatalf("DecodeImage: %v", err)
+	}
+	if hdr.Format != comms.FormatRGB332 || hdr.Width != universe.WIDTH || hdr.Height != universe.HEIGHT {
+		t.Errorf("header = %+v", hdr)
+	}
+}
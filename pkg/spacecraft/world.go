@@ -0,0 +1,169 @@
+package spacecraft
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+// World is everything a running simulation is made of. It turns to and from
+// a universe.SaveGame, which cannot hold probes itself.
+type World struct {
+	Tick   uint64
+	Scenes []*universe.LocalScene
+	Bus    *comms.MessageBus
+	Probes []*SpaceProbe
+
+	// Workers is the number of goroutines Step ticks probes on; 0 or 1 ticks
+	// them in turn. The result is the same either way.
+	Workers int
+}
+
+// SaveGame captures the world. Scene names must be unique and every probe
+// must be in one of the world's scenes. It must not run concurrently with
+// Tick.
+func (w *World) SaveGame() (*universe.SaveGame, error) {
+	g := &universe.SaveGame{Seed: universe.Seed, Stars: universe.StarCount, Tick: w.Tick, Bus: w.Bus.State()}
+	saved := make(map[*universe.LocalScene]bool)
+	seen := make(map[string]bool)
+	for _, s := range w.Scenes {
+		if seen[s.Name] {
+			return nil, fmt.Errorf("SaveGame: duplicate scene name %q", s.Name)
+		}
+		seen[s.Name] = true
+		saved[s] = true
+		st, err := s.State()
+		if err != nil {
+			return nil, fmt.Errorf("SaveGame: %w", err)
+		}
+		g.Scenes = append(g.Scenes, st)
+	}
+	for _, sp := range w.Probes {
+		if !saved[sp.scene] {
+			return nil, fmt.Errorf("SaveGame: probe %s is not in a saved scene", sp.Physical.ID)
+		}
+		s, err := sp.Snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("SaveGame: %w", err)
+		}
+		var buf bytes.Buffer
+		if _, err := s.WriteTo(&buf); err != nil {
+			return nil, fmt.Errorf("SaveGame: %w", err)
+		}
+		g.Probes = append(g.Probes, universe.SavedProbe{ID: sp.Physical.ID, Scene: sp.scene.Name, State: buf.Bytes()})
+	}
+	return g, nil
+}
+
+// LoadWorld rebuilds a saved world on bus, which should already have the
+// ground's subscriptions and relays. It sets the galaxy.
+func LoadWorld(g *universe.SaveGame, bus *comms.MessageBus) (*World, error) {
+	universe.SetGalaxy(g.Seed, g.Stars)
+	w := &World{Tick: g.Tick, Bus: bus}
+	scenes := make(map[string]*universe.LocalScene)
+	for _, st := range g.Scenes {
+		s, err := universe.NewLocalSceneFromState(st)
+		if err != nil {
+			return nil, fmt.Errorf("LoadWorld: %w", err)
+		}
+		scenes[s.Name] = s
+		w.Scenes = append(w.Scenes, s)
+	}
+	for _, p := range g.Probes {
+		scene, ok := scenes[p.Scene]
+		if !ok {
+			return nil, fmt.Errorf("LoadWorld: probe %s is in unknown scene %q", p.ID, p.Scene)
+		}
+		s, err := ReadProbeSnapshot(bytes.NewReader(p.State))
+		if err != nil {
+			return nil, fmt.Errorf("LoadWorld: probe %s: %w", p.ID, err)
+		}
+		sp, err := NewSpaceProbeFromSnapshot(s, p.ID, scene, bus)
+		if err != nil {
+			return nil, fmt.Errorf("LoadWorld: %w", err)
+		}
+		w.Probes = append(w.Probes, sp)
+	}
+	bus.SetState(g.Bus)
+	return w, nil
+}
+
+// Step advances the world one tick: the bus delivers what is due, then each
+// probe runs cycles instructions.
+func (w *World) Step(cycles int) {
+	w.Tick++
+	w.Bus.Tick()
+	w.eachProbe(func(sp *SpaceProbe) {
+		sp.tick = w.Tick
+		sp.Tick(cycles)
+	})
+}
+
+// eachProbe calls fn for every probe, in turn or on w.Workers goroutines.
+// Probes sharing a scene share its models, which are not safe to render
+// concurrently, so each scene's probes are handled in turn on one goroutine.
+// What they send is held back and put on the bus in probe order, as if they
+// had been handled in turn.
+func (w *World) eachProbe(fn func(sp *SpaceProbe)) {
+	if w.Workers <= 1 {
+		for _, sp := range w.Probes {
+			fn(sp)
+		}
+		return
+	}
+
+	var groups [][]int
+	byScene := make(map[*universe.LocalScene]int)
+	for i, sp := range w.Probes {
+		g, ok := byScene[sp.scene]
+		if !ok {
+			g = len(groups)
+			byScene[sp.scene] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	outboxes := make([]*outbox, len(w.Probes))
+	jobs := make(chan []int)
+	var wg sync.WaitGroup
+	for range min(w.Workers, len(groups)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range jobs {
+				for _, i := range group {
+					sp := w.Probes[i]
+					box := &outbox{Bus: sp.bus}
+					sp.bus = box
+					fn(sp)
+					sp.bus = box.Bus
+					outboxes[i] = box
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		jobs <- group
+	}
+	close(jobs)
+	wg.Wait()
+	for _, box := range outboxes {
+		for _, m := range box.msgs {
+			box.Bus.Send(m.SenderID, m.TargetID, m.Payload)
+		}
+	}
+}
+
+// outbox holds back a probe's messages during a parallel step. Payloads are
+// copied, as the bus would.
+type outbox struct {
+	comms.Bus
+	msgs []comms.Message
+}
+
+func (o *outbox) Send(senderID string, targetID string, payload []byte) {
+	o.msgs = append(o.msgs, comms.Message{SenderID: senderID, TargetID: targetID, Payload: append([]byte(nil), payload...)})
+}
@@ -0,0 +1,133 @@
+package spacecraft
+
+import (
+	"fmt"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+	"github.com/smasonuk/unknowngalaxy/pkg/universe"
+)
+
+func TestWorld_SaveAndLoad(t *testing.T) {
+	bus := comms.NewMessageBus()
+	scene := universe.NewLocalScene(1, 2, 3, 4, 5, 6)
+	scene.Name = "home"
+	err := scene.AddEntitySpec(universe.EntitySpec{
+		Kind: universe.EntityHeightmap,
+		Heightmap: &universe.HeightmapSpec{
+			Width: 100, Depth: 100, Color: color.RGBA{A: 255}, Subdivisions: 2, Amplitude: 10, NoiseScale: 10, Seed: 1,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := universe.NewGalacticPosition(1, 2, 3, 4, 5, 6, 7, 8, 9)
+	probe, err := NewSpaceProbeWithOptions("Probe1", pos, scene, bus, ProbeOptions{OSBinary: []byte{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.VM.Memory[0x4000] = 0xCD
+	bus.Send(GroundStationID, "Probe1", []byte("queued"))
+
+	world := &World{Tick: 12, Scenes: []*universe.LocalScene{scene}, Bus: bus, Probes: []*SpaceProbe{probe}}
+	g, err := world.SaveGame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadWorld(g, comms.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Tick != 12 || len(loaded.Scenes) != 1 || len(loaded.Probes) != 1 {
+		t.Fatalf("loaded world = %+v", loaded)
+	}
+	lp := loaded.Probes[0]
+	if lp.Physical.ID != "Probe1" || lp.Scene() != loaded.Scenes[0] || lp.VM.Memory[0x4000] != 0xCD {
+		t.Error("probe not restored into its scene")
+	}
+	if pending := loaded.Bus.Pending(); len(pending) != 1 || string(pending[0].Payload) != "queued" {
+		t.Errorf("pending = %+v", pending)
+	}
+
+	outside, err := NewSpaceProbeWithOptions("Probe2", pos, universe.NewLocalScene(0, 0, 0, 0, 0, 0), bus, ProbeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	world.Probes = append(world.Probes, outside)
+	if _, err := world.SaveGame(); err == nil {
+		t.Error("expected an error for a probe outside the world's scenes")
+	}
+}
+
+func newFleetWorld(t *testing.T, workers int) *World {
+	t.Helper()
+	bus := comms.NewMessageBus()
+	w := &World{Bus: bus, Workers: workers}
+	for i := range 2 {
+		scene := universe.NewLocalScene(int64(i), 0, 0, 0, 0, 0)
+		err := scene.AddEntitySpec(universe.EntitySpec{
+			Kind: universe.EntityHeightmap,
+			Heightmap: &universe.HeightmapSpec{
+				Width: 100, Depth: 100, Color: color.RGBA{G: 200, A: 255}, Subdivisions: 4, Amplitude: 10, NoiseScale: 10, Seed: int64(i),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Scenes = append(w.Scenes, scene)
+	}
+	for i := range 4 {
+		pos := universe.NewGalacticPosition(0, 0, 0, 0, 0, 0, float64(i), 0, 0)
+		sp, err := NewSpaceProbeWithOptions(fmt.Sprintf("Probe%d", i), pos, w.Scenes[i%2], bus, ProbeOptions{OSBinary: []byte{1, 2, 3, 4}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Probes = append(w.Probes, sp)
+		bus.Send(GroundStationID, sp.Physical.ID, comms.EncodeUplink(comms.UplinkMachineCode, []byte{byte(i), 6, 7, 8}))
+	}
+	return w
+}
+
+func TestWorld_ParallelStepMatchesSequential(t *testing.T) {
+	seq := newFleetWorld(t, 0)
+	par := newFleetWorld(t, 4)
+	for range 3 {
+		seq.Step(50)
+		par.Step(50)
+	}
+	if par.Tick != 3 {
+		t.Errorf("tick = %d, want 3", par.Tick)
+	}
+	if !reflect.DeepEqual(seq.Bus.State(), par.Bus.State()) {
+		t.Errorf("bus after parallel steps = %+v, want %+v", par.Bus.State(), seq.Bus.State())
+	}
+	for i := range par.Probes {
+		if par.Probes[i].VM.Memory != seq.Probes[i].VM.Memory || vmPC(par.Probes[i].VM) != vmPC(seq.Probes[i].VM) {
+			t.Errorf("probe %d differs", i)
+		}
+		if par.Probes[i].bus != par.Bus {
+			t.Errorf("probe %d was left on its outbox", i)
+		}
+	}
+}
+
+// Run with -race: probes in different scenes render at the same time.
+func TestWorld_ParallelRenderingMatchesSequential(t *testing.T) {
+	seq := newFleetWorld(t, 0)
+	par := newFleetWorld(t, 4)
+	capture := func(sp *SpaceProbe) { sp.Imager.Write16(0x00, ImagerCmdCapture) }
+	for range 2 {
+		seq.eachProbe(capture)
+		par.eachProbe(capture)
+	}
+	state := par.Bus.State()
+	if len(state.Queue) != 4+8 {
+		t.Fatalf("%d messages queued, want 4 uplinks and 8 frames", len(state.Queue))
+	}
+	if !reflect.DeepEqual(seq.Bus.State(), state) {
+		t.Error("frames rendered in parallel differ from frames rendered in turn")
+	}
+}
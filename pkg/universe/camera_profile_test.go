@@ -0,0 +1,75 @@
+package universe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+)
+
+func snapshotCamera() (*si3d.Camera, si3d.Vector3) {
+	pos := NewGalacticPosition(10000, 25000, 35000, 0, 0, 0, 0, -200, -400)
+	return NewPointedCamera(pos, si3d.NewVector3(0, -200, 0)), pos.ToStarfieldPosition()
+}
+
+func TestCameraProfile_DefaultMatchesTakeProbeSnapshot(t *testing.T) {
+	cam, pos := snapshotCamera()
+	want := GalaxyStars.TakeProbeSnapshot(cam, pos, 32, 32, DefaultExposure, Seed)
+	got := GalaxyStars.TakeProbeSnapshotWithProfile(cam, pos, 32, 32, DefaultExposure, DefaultCameraProfile, Seed)
+	if !bytes.Equal(got.Pix, want.Pix) {
+		t.Fatal("default profile does not reproduce TakeProbeSnapshot")
+	}
+}
+
+func TestCameraProfile_PerFrameNoise(t *testing.T) {
+	cam, pos := snapshotCamera()
+	frame := func(p CameraProfile, n uint64) []byte {
+		sf := NewStarfield(cam, pos)
+		sf.Profile = &p
+		sf.Frame = n
+		return sf.GetStarField(32, 32).Pix
+	}
+
+	noisy := DefaultCameraProfile
+	noisy.ReadNoise = 0.2
+	if bytes.Equal(frame(noisy, 0), frame(noisy, 1)) {
+		t.Error("consecutive frames have identical grain")
+	}
+	if !bytes.Equal(frame(noisy, 3), frame(noisy, 3)) {
+		t.Error("the same frame number is not reproducible")
+	}
+	noisy.FixedNoise = true
+	if !bytes.Equal(frame(noisy, 0), frame(noisy, 1)) {
+		t.Error("FixedNoise frames differ")
+	}
+}
+
+func TestCameraProfile_HotPixelsAndScanlines(t *testing.T) {
+	cam, pos := snapshotCamera()
+	p := DefaultCameraProfile
+	p.ReadNoise = 0
+	p.HotPixels = 3
+	p.HotPixelSeed = 42
+	img := GalaxyStars.TakeProbeSnapshotWithProfile(cam, pos, 32, 32, DefaultExposure, p, Seed)
+
+	for i := range p.hotPixelMap(32, 32) {
+		if c := img.RGBAAt(i%32, i/32); c.R != 255 || c.G != 255 || c.B != 255 {
+			t.Errorf("hot pixel %d is %v, want white", i, c)
+		}
+	}
+
+	// An empty galaxy develops to the background tint alone: int(0.02*255)
+	// on odd rows, halved on even rows.
+	p.HotPixels = 0
+	p.Scanline = 0.5
+	img = (&Galaxy{}).TakeProbeSnapshotWithProfile(cam, pos, 4, 4, DefaultExposure, p, Seed)
+	if even, odd := img.RGBAAt(0, 0).R, img.RGBAAt(0, 1).R; odd != 5 || even != 2 {
+		t.Errorf("background rows: even %d, odd %d, want 2 and 5", even, odd)
+	}
+	if _, err := CameraProfileAt(len(CameraProfiles)); err == nil {
+		t.Error("expected an error for an unknown profile index")
+	}
+	if p, err := CameraProfileByName("ccd"); err != nil || p.Tone != ToneLinear {
+		t.Errorf("CameraProfileByName(ccd) = %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,90 @@
+package universe
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+)
+
+// MaxRenderSize bounds the square frame rendered for a narrow-angle camera.
+const MaxRenderSize = 2048
+
+// CameraSpec describes a probe camera's sensor and optics.
+//
+// The renderer has a fixed field of view, so a narrower one is produced by
+// rendering a larger square frame and cropping its centre. Fields of view
+// wider than the renderer's are clamped to it.
+type CameraSpec struct {
+	Name   string  `json:"name"`
+	Width  int     `json:"width"` // sensor resolution; the aspect ratio is Width:Height
+	Height int     `json:"height"`
+	FOV    float64 `json:"fov,omitempty"` // horizontal field of view in degrees, 0 for the renderer's own
+}
+
+// Stock instruments.
+var (
+	DefaultCameraSpec = CameraSpec{Name: "imager", Width: WIDTH, Height: HEIGHT}
+	NavCamSpec        = CameraSpec{Name: "navcam", Width: 256, Height: 192}
+	TelescopeSpec     = CameraSpec{Name: "telescope", Width: 128, Height: 128, FOV: 15}
+)
+
+// pixelsPerTan returns how many pixels one unit of tan(angle) off-axis spans
+// horizontally in a size×size render.
+func pixelsPerTan(size int) float64 {
+	s := float64(size)
+	return si3d.ConvertToScreenX(s, s, 1, 1) - si3d.ConvertToScreenX(s, s, 0, 1)
+}
+
+// NativeFOV returns the horizontal field of view, in degrees, of a
+// width-pixel-wide crop of a size×size render.
+func NativeFOV(width, size int) float64 {
+	return 2 * math.Atan(float64(width)/2/pixelsPerTan(size)) * 180 / math.Pi
+}
+
+// Layout returns the size of the square frame rendered for the spec and the
+// horizontal field of view the crop actually covers.
+func (s CameraSpec) Layout() (render int, fov float64, err error) {
+	if s.Width <= 0 || s.Height <= 0 {
+		return 0, 0, fmt.Errorf("CameraSpec.Layout: %s: bad resolution %dx%d", s.Name, s.Width, s.Height)
+	}
+	if s.FOV < 0 || s.FOV >= 180 {
+		return 0, 0, fmt.Errorf("CameraSpec.Layout: %s: bad field of view %g", s.Name, s.FOV)
+	}
+	render = max(s.Width, s.Height)
+	if s.FOV > 0 {
+		// pixelsPerTan grows linearly with the render size.
+		perPixel := pixelsPerTan(MaxRenderSize) / MaxRenderSize
+		want := float64(s.Width) / 2 / math.Tan(s.FOV/2*math.Pi/180)
+		render = max(render, int(math.Ceil(want/perPixel)))
+	}
+	if render > MaxRenderSize {
+		return 0, 0, fmt.Errorf("CameraSpec.Layout: %s: %g° at %d pixels needs a %d pixel render, limit %d", s.Name, s.FOV, s.Width, render, MaxRenderSize)
+	}
+	return render, NativeFOV(s.Width, render), nil
+}
+
+// TakePictureWithSpec takes a picture with a camera described by spec.
+func (s *LocalScene) TakePictureWithSpec(probe *Probe, spec CameraSpec) (image.Image, error) {
+	return s.TakeExposure(probe, spec, probe.Exposure, probe.Gain)
+}
+
+// TakeExposure takes a picture with a camera described by spec at the given
+// exposure and gain instead of the probe's own, which are left unchanged.
+// Zero selects the defaults, as in Probe.
+func (s *LocalScene) TakeExposure(probe *Probe, spec CameraSpec, exposure, gain float64) (image.Image, error) {
+	render, _, err := spec.Layout()
+	if err != nil {
+		return nil, err
+	}
+	full := s.takePicture(probe, render, render, exposure, gain)
+	if render == spec.Width && render == spec.Height {
+		return full, nil
+	}
+	out := image.NewRGBA(image.Rect(0, 0, spec.Width, spec.Height))
+	origin := full.Bounds().Min.Add(image.Pt((render-spec.Width)/2, (render-spec.Height)/2))
+	draw.Draw(out, out.Rect, full, origin, draw.Src)
+	return out, nil
+}
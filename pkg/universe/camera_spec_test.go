@@ -0,0 +1,68 @@
+package universe
+
+import (
+	"math"
+	"testing"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+)
+
+func TestCameraSpec_Layout(t *testing.T) {
+	native := NativeFOV(128, 128)
+
+	render, fov, err := DefaultCameraSpec.Layout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if render != 128 || math.Abs(fov-native) > 1e-9 {
+		t.Errorf("default spec renders %d at %.2f°, want 128 at the native %.2f°", render, fov, native)
+	}
+
+	render, fov, err = TelescopeSpec.Layout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if render <= TelescopeSpec.Width {
+		t.Errorf("telescope renders at %d, want larger than its %d pixel sensor", render, TelescopeSpec.Width)
+	}
+	if math.Abs(fov-TelescopeSpec.FOV) > 0.1 {
+		t.Errorf("telescope covers %.2f°, want %.2f°", fov, TelescopeSpec.FOV)
+	}
+
+	wide := CameraSpec{Name: "fisheye", Width: 128, Height: 64, FOV: 170}
+	if render, fov, err = wide.Layout(); err != nil || render != 128 || fov > native+1e-9 {
+		t.Errorf("wide spec = %d, %.2f°, %v; want clamped to the native field", render, fov, err)
+	}
+
+	for _, bad := range []CameraSpec{
+		{Name: "empty"},
+		{Name: "pinhole", Width: 128, Height: 128, FOV: 0.01},
+		{Name: "backwards", Width: 128, Height: 128, FOV: 200},
+	} {
+		if _, _, err := bad.Layout(); err == nil {
+			t.Errorf("%s: expected an error", bad.Name)
+		}
+	}
+}
+
+func TestTakePictureWithSpec_Crops(t *testing.T) {
+	scene := NewLocalScene(10000, 25000, 35000, 0, 0, 0)
+	probe := NewProbe("test", NewGalacticPosition(10000, 25000, 35000, 0, 0, 0, 0, -200, -400))
+	probe.PointCamera(si3d.NewVector3(0, -200, 0))
+
+	spec := CameraSpec{Name: "strip", Width: 96, Height: 32, FOV: 60}
+	img, err := scene.TakePictureWithSpec(probe, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 96 || b.Dy() != 32 {
+		t.Fatalf("picture is %v, want 96x32", b)
+	}
+}
+
+func TestMaxRenderSize_FitsProgressiveLink(t *testing.T) {
+	if MaxRenderSize*MaxRenderSize != comms.MaxProgressivePixels {
+		t.Errorf("comms.MaxProgressivePixels = %d, want MaxRenderSize squared (%d)", comms.MaxProgressivePixels, MaxRenderSize*MaxRenderSize)
+	}
+}
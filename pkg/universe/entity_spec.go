@@ -0,0 +1,124 @@
+package universe
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+)
+
+// EntityKind selects how an EntitySpec builds its model.
+type EntityKind string
+
+const (
+	EntityHeightmap EntityKind = "heightmap" // Perlin noise terrain
+	EntityPrimitive EntityKind = "primitive" // a cube or sphere
+	EntityModel     EntityKind = "model"     // a mesh loaded from a Wavefront OBJ file
+)
+
+// EntitySpec describes a scene entity by how it was built rather than by its
+// meshes, so scenes can be saved and rebuilt.
+type EntitySpec struct {
+	Kind      EntityKind     `json:"kind"`
+	X         float64        `json:"x"`
+	Y         float64        `json:"y"`
+	Z         float64        `json:"z"`
+	Heightmap *HeightmapSpec `json:"heightmap,omitempty"`
+	Primitive *PrimitiveSpec `json:"primitive,omitempty"`
+	Model     *ModelSpec     `json:"model,omitempty"`
+}
+
+// HeightmapSpec holds the arguments of si3d.NewSubdividedPlaneHeightMapPerlin.
+type HeightmapSpec struct {
+	Width        float64    `json:"width"`
+	Depth        float64    `json:"depth"`
+	Color        color.RGBA `json:"color"`
+	Subdivisions int        `json:"subdivisions"`
+	Amplitude    float64    `json:"amplitude"`
+	NoiseScale   float64    `json:"noise_scale"`
+	Seed         int64      `json:"seed"`
+	// DontDrawOutlines is passed to Model.SetDontDrawOutlines.
+	DontDrawOutlines bool `json:"dont_draw_outlines,omitempty"`
+}
+
+// Primitive shapes.
+const (
+	ShapeCube   = "cube"
+	ShapeSphere = "sphere"
+)
+
+// PrimitiveSpec describes a solid built from a shape.
+type PrimitiveSpec struct {
+	Shape    string     `json:"shape"`
+	Size     float64    `json:"size"`               // edge length of a cube, diameter of a sphere
+	Segments int        `json:"segments,omitempty"` // sphere tessellation, 16 if 0
+	Color    color.RGBA `json:"color"`
+}
+
+// ModelSpec describes a mesh loaded from a file.
+type ModelSpec struct {
+	Path  string     `json:"path"`
+	Color color.RGBA `json:"color"`
+}
+
+// Build creates the entity the spec describes.
+func (s EntitySpec) Build() (*si3d.Entity, error) {
+	switch s.Kind {
+	case EntityHeightmap:
+		h := s.Heightmap
+		if h == nil {
+			return nil, fmt.Errorf("EntitySpec.Build: heightmap without parameters")
+		}
+		if h.Width <= 0 || h.Depth <= 0 || h.Subdivisions <= 0 {
+			return nil, fmt.Errorf("EntitySpec.Build: bad heightmap size %gx%g/%d", h.Width, h.Depth, h.Subdivisions)
+		}
+		m := si3d.NewSubdividedPlaneHeightMapPerlin(h.Width, h.Depth, h.Color, h.Subdivisions, h.Amplitude, h.NoiseScale, h.Seed)
+		m.SetDontDrawOutlines(h.DontDrawOutlines)
+		return &si3d.Entity{Model: m, X: s.X, Y: s.Y, Z: s.Z}, nil
+	case EntityPrimitive:
+		p := s.Primitive
+		if p == nil {
+			return nil, fmt.Errorf("EntitySpec.Build: primitive without parameters")
+		}
+		if p.Size <= 0 || p.Segments < 0 {
+			return nil, fmt.Errorf("EntitySpec.Build: bad %s size %g", p.Shape, p.Size)
+		}
+		var m *si3d.Model
+		switch p.Shape {
+		case ShapeCube:
+			m = si3d.NewCube(p.Size, p.Color)
+		case ShapeSphere:
+			segments := p.Segments
+			if segments == 0 {
+				segments = 16
+			}
+			m = si3d.NewSphere(p.Size/2, segments, p.Color)
+		default:
+			return nil, fmt.Errorf("EntitySpec.Build: unknown shape %q", p.Shape)
+		}
+		return &si3d.Entity{Model: m, X: s.X, Y: s.Y, Z: s.Z}, nil
+	case EntityModel:
+		if s.Model == nil || s.Model.Path == "" {
+			return nil, fmt.Errorf("EntitySpec.Build: model without a path")
+		}
+		m, err := si3d.LoadOBJ(s.Model.Path, s.Model.Color)
+		if err != nil {
+			return nil, fmt.Errorf("EntitySpec.Build: %w", err)
+		}
+		return &si3d.Entity{Model: m, X: s.X, Y: s.Y, Z: s.Z}, nil
+	default:
+		return nil, fmt.Errorf("EntitySpec.Build: unknown kind %q", s.Kind)
+	}
+}
+
+// AddEntitySpec builds the entity a spec describes and adds it to the scene.
+// Only scenes built entirely from specs can be saved.
+func (s *LocalScene) AddEntitySpec(spec EntitySpec) error {
+	e, err := spec.Build()
+	if err != nil {
+		return err
+	}
+	s.Entities = append(s.Entities, e)
+	s.specs = append(s.specs, &spec)
+	return nil
+}
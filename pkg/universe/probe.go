@@ -15,6 +15,12 @@ type Probe struct {
 	ID       string
 	Position *GalacticPosition
 	Camera   *si3d.Camera
+	LookAt   si3d.Vector3 // last target passed to PointCamera
+	Pointed  bool         // PointCamera has been called
+	Profile  CameraProfile
+	Frames   uint64  // pictures taken so far
+	Exposure float64 // 0 uses DefaultExposure
+	Gain     float64 // multiplies the profile gain, 0 means 1
 }
 
 func NewProbe(id string, startPos *GalacticPosition) *Probe {
@@ -22,13 +28,17 @@ func NewProbe(id string, startPos *GalacticPosition) *Probe {
 		ID:       id,
 		Position: startPos,
 		Camera:   si3d.NewCamera(0, 0, 0, 0, 0, 0), // Base camera, position updated by Scene
+		Profile:  DefaultCameraProfile,
 	}
 }
 
 type LocalScene struct {
+	Name                      string // identifies the scene in save games
 	SectorX, SectorY, SectorZ int64
 	SystemX, SystemY, SystemZ int64
 	Entities                  []*si3d.Entity
+
+	specs []*EntitySpec // parallel to Entities, nil for entities added by AddEntity
 }
 
 func NewLocalScene(secX, secY, secZ, sysX, sysY, sysZ int64) *LocalScene {
@@ -41,11 +51,23 @@ func NewLocalScene(secX, secY, secZ, sysX, sysY, sysZ int64) *LocalScene {
 
 func (s *LocalScene) AddEntity(e *si3d.Entity) {
 	s.Entities = append(s.Entities, e)
+	s.specs = append(s.specs, nil)
 }
 
 func (s *LocalScene) TakePicture(probe *Probe, width, height int) image.Image {
+	return s.takePicture(probe, width, height, probe.Exposure, probe.Gain)
+}
+
+// takePicture renders the probe's view at the given exposure and gain, which
+// are zero for the defaults as in Probe.
+func (s *LocalScene) takePicture(probe *Probe, width, height int, exposure, gain float64) *image.RGBA {
 	starfieldPos := probe.Position.ToStarfieldPosition()
 	field := NewStarfield(probe.Camera, starfieldPos)
+	field.Profile = &probe.Profile
+	field.Frame = probe.Frames
+	field.Exposure = exposure
+	field.Gain = gain
+	probe.Frames++
 	frameImg := field.GetStarField(height, width)
 
 	world := si3d.NewWorld3d()
@@ -72,9 +94,25 @@ func saveImageToFile(img image.Image, filename string) {
 	}
 }
 func (p *Probe) PointCamera(target si3d.Vector3) {
+	p.Camera = NewPointedCamera(p.Position, target)
+	p.LookAt = target
+	p.Pointed = true
+}
+
+// UnpointCamera returns the camera to the orientation of a new probe's.
+func (p *Probe) UnpointCamera() {
+	p.Camera = si3d.NewCamera(0, 0, 0, 0, 0, 0)
+	p.LookAt = si3d.Vector3{}
+	p.Pointed = false
+}
+
+// NewPointedCamera builds the camera a probe at pos has after PointCamera(target),
+// so ground tools can reproduce the orientation of archived captures.
+func NewPointedCamera(pos *GalacticPosition, target si3d.Vector3) *si3d.Camera {
 	// 1. Re-center the internal si3d camera to the probe's actual current location
-	p.Camera = si3d.NewCamera(p.Position.LocalX, p.Position.LocalY, p.Position.LocalZ, 0, 0, 0)
+	cam := si3d.NewCamera(pos.LocalX, pos.LocalY, pos.LocalZ, 0, 0, 0)
 
 	// 2. NOW calculate the view angle to the target
-	p.Camera.LookAt(target, si3d.NewVector3(0, 1, 0))
+	cam.LookAt(target, si3d.NewVector3(0, 1, 0))
+	return cam
 }
@@ -0,0 +1,221 @@
+package universe
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+)
+
+// Save game file layout, integers little-endian:
+//
+//	[Magic "UGSV"][Version: uint16][gob-encoded SaveGame]
+//
+// SaveVersion changes whenever SaveGame changes in a way gob cannot absorb by
+// itself; register a migration from the old version when it does.
+const (
+	saveMagic   = "UGSV"
+	SaveVersion = 1
+)
+
+// DefaultStarCount is the number of stars in the galaxy unless SetGalaxy
+// says otherwise.
+const DefaultStarCount = 300000
+
+// StarCount is the number of stars in GalaxyStars.
+var StarCount = DefaultStarCount
+
+// SaveGame is the state of a whole simulation: the galaxy, the simulation
+// clock, every scene, every probe and the message bus.
+type SaveGame struct {
+	Seed   int64
+	Stars  int // 0 for DefaultStarCount
+	Tick   uint64
+	Scenes []SceneState
+	Probes []SavedProbe
+	Bus    comms.BusState
+}
+
+// SceneState is a LocalScene as saved.
+type SceneState struct {
+	Name                      string
+	SectorX, SectorY, SectorZ int64
+	SystemX, SystemY, SystemZ int64
+	Entities                  []EntitySpec
+}
+
+// SavedProbe is a probe as saved. The universe does not know how probes are
+// built, so their state is kept encoded by whoever saved them.
+type SavedProbe struct {
+	ID    string
+	Scene string // name of the scene the probe is in
+	State []byte
+}
+
+// Migration upgrades a save game decoded from an older version by one
+// version. Gob matches fields by name, so fields that still exist are
+// already filled in; a migration fills in the rest.
+type Migration func(g *SaveGame) error
+
+var migrations = map[uint16]Migration{}
+
+// RegisterMigration registers the upgrade from version from to from+1.
+func RegisterMigration(from uint16, m Migration) {
+	migrations[from] = m
+}
+
+// SetGalaxy sets the galaxy seed and star count, 0 for DefaultStarCount, and
+// regenerates the galaxy if either changed.
+func SetGalaxy(seed int64, stars int) {
+	if stars <= 0 {
+		stars = DefaultStarCount
+	}
+	if seed == Seed && stars == StarCount && GalaxyStars != nil {
+		return
+	}
+	Seed, StarCount = seed, stars
+	GalaxyStars = GenerateSpiralGalaxy(stars, seed)
+}
+
+// State returns the scene as saved. It fails if the scene has entities that
+// were not added with AddEntitySpec.
+func (s *LocalScene) State() (SceneState, error) {
+	st := SceneState{
+		Name:    s.Name,
+		SectorX: s.SectorX, SectorY: s.SectorY, SectorZ: s.SectorZ,
+		SystemX: s.SystemX, SystemY: s.SystemY, SystemZ: s.SystemZ,
+	}
+	if len(s.specs) != len(s.Entities) {
+		return st, fmt.Errorf("LocalScene.State: scene %q has entities without specs", s.Name)
+	}
+	for i, spec := range s.specs {
+		if spec == nil {
+			return st, fmt.Errorf("LocalScene.State: scene %q: entity %d has no spec", s.Name, i)
+		}
+		st.Entities = append(st.Entities, *spec)
+	}
+	return st, nil
+}
+
+// NewLocalSceneFromState rebuilds a saved scene.
+func NewLocalSceneFromState(st SceneState) (*LocalScene, error) {
+	s := NewLocalScene(st.SectorX, st.SectorY, st.SectorZ, st.SystemX, st.SystemY, st.SystemZ)
+	s.Name = st.Name
+	for i, spec := range st.Entities {
+		if err := s.AddEntitySpec(spec); err != nil {
+			return nil, fmt.Errorf("NewLocalSceneFromState: scene %q: entity %d: %w", st.Name, i, err)
+		}
+	}
+	return s, nil
+}
+
+// WriteTo writes the save game in the versioned file format.
+func (g *SaveGame) WriteTo(w io.Writer) (int64, error) {
+	var header [6]byte
+	copy(header[:], saveMagic)
+	binary.LittleEndian.PutUint16(header[4:], SaveVersion)
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(header[:]); err != nil {
+		return cw.n, fmt.Errorf("SaveGame.WriteTo: %w", err)
+	}
+	if err := gob.NewEncoder(cw).Encode(g); err != nil {
+		return cw.n, fmt.Errorf("SaveGame.WriteTo: %w", err)
+	}
+	return cw.n, nil
+}
+
+// ReadSaveGame reads a save game written by SaveGame.WriteTo, migrating it
+// from older versions.
+func ReadSaveGame(r io.Reader) (*SaveGame, error) {
+	var header [6]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("ReadSaveGame: %w", err)
+	}
+	if string(header[:4]) != saveMagic {
+		return nil, fmt.Errorf("ReadSaveGame: not a save game")
+	}
+	v := binary.LittleEndian.Uint16(header[4:])
+	if v > SaveVersion {
+		return nil, fmt.Errorf("ReadSaveGame: version %d is newer than %d", v, SaveVersion)
+	}
+	g := new(SaveGame)
+	if err := gob.NewDecoder(r).Decode(g); err != nil {
+		return nil, fmt.Errorf("ReadSaveGame: %w", err)
+	}
+	for ; v < SaveVersion; v++ {
+		m := migrations[v]
+		if m == nil {
+			return nil, fmt.Errorf("ReadSaveGame: no migration from version %d", v)
+		}
+		if err := m(g); err != nil {
+			return nil, fmt.Errorf("ReadSaveGame: migrating from version %d: %w", v, err)
+		}
+	}
+	return g, nil
+}
+
+// Save writes a save game file. The game is written to a temporary file in
+// the same directory and renamed over path, so a failed or interrupted save
+// leaves any earlier save at path intact.
+func Save(path string, g *SaveGame) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("Save: %w", err)
+	}
+	tmp := f.Name()
+	if err := writeSave(f, g); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Save: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Save: %w", err)
+	}
+	return nil
+}
+
+// writeSave writes g to f and syncs it to disk.
+func writeSave(f *os.File, g *SaveGame) error {
+	w := bufio.NewWriter(f)
+	if _, err := g.WriteTo(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("Save: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("Save: %w", err)
+	}
+	return nil
+}
+
+// Load reads a save game file written by Save.
+func Load(path string) (*SaveGame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Load: %w", err)
+	}
+	defer f.Close()
+	return ReadSaveGame(bufio.NewReader(f))
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,142 @@
+package universe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/smasonuk/si3d/pkg/si3d"
+
+	"github.com/smasonuk/unknowngalaxy/pkg/comms"
+)
+
+var testHeightmap = EntitySpec{
+	Kind: EntityHeightmap,
+	Y:    -50,
+	Heightmap: &HeightmapSpec{
+		Width: 1000, Depth: 1000, Color: color.RGBA{R: 1, G: 2, B: 3, A: 255},
+		Subdivisions: 4, Amplitude: 80, NoiseScale: 80, Seed: 7,
+	},
+}
+
+func TestSaveGame_RoundTrip(t *testing.T) {
+	scene := NewLocalScene(1, 2, 3, 4, 5, 6)
+	scene.Name = "home"
+	if err := scene.AddEntitySpec(testHeightmap); err != nil {
+		t.Fatal(err)
+	}
+	st, err := scene.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &SaveGame{
+		Seed:   Seed,
+		Tick:   99,
+		Scenes: []SceneState{st},
+		Probes: []SavedProbe{{ID: "Probe1", Scene: "home", State: []byte{1, 2, 3}}},
+		Bus: comms.BusState{Now: 98, Queue: []comms.InFlight{
+			{Message: comms.Message{SenderID: "Earth", TargetID: "Probe1", Payload: []byte("hi")}, At: "DSN", Next: "Probe1", ReadyAt: 100},
+		}},
+	}
+
+	path := filepath.Join(t.TempDir(), "game.sav")
+	if err := Save(path, g); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, g) {
+		t.Errorf("read %+v, want %+v", read, g)
+	}
+
+	rebuilt, err := NewLocalSceneFromState(read.Scenes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt.Name != "home" || rebuilt.SystemZ != 6 || len(rebuilt.Entities) != 1 || rebuilt.Entities[0].Y != -50 {
+		t.Errorf("rebuilt scene = %+v", rebuilt)
+	}
+}
+
+func TestSave_ReplacesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	if err := Save(path, &SaveGame{Seed: Seed, Tick: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(path, &SaveGame{Seed: Seed, Tick: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if g, err := Load(path); err != nil || g.Tick != 2 {
+		t.Fatalf("loaded %+v, %v; want tick 2", g, err)
+	}
+
+	// A save that cannot be renamed into place cleans up after itself.
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.MkdirAll(filepath.Join(blocked, "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(blocked, &SaveGame{Seed: Seed}); err == nil {
+		t.Error("expected an error saving over a directory")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"blocked", "game.sav"}) {
+		t.Errorf("directory holds %v, want no temporary files", names)
+	}
+}
+
+func TestLocalScene_StateNeedsSpecs(t *testing.T) {
+	scene := NewLocalScene(0, 0, 0, 0, 0, 0)
+	scene.AddEntity(&si3d.Entity{})
+	if _, err := scene.State(); err == nil {
+		t.Error("expected an error for an entity without a spec")
+	}
+	if err := scene.AddEntitySpec(EntitySpec{Kind: "teapot"}); err == nil {
+		t.Error("expected an error for an unknown entity kind")
+	}
+}
+
+func TestReadSaveGame_Migration(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := (&SaveGame{Tick: 5}).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	old := buf.Bytes()
+	binary.LittleEndian.PutUint16(old[4:], SaveVersion-1)
+
+	if _, err := ReadSaveGame(bytes.NewReader(old)); err == nil || !strings.Contains(err.Error(), "no migration") {
+		t.Errorf("got %v, want a missing migration error", err)
+	}
+
+	RegisterMigration(SaveVersion-1, func(g *SaveGame) error {
+		g.Tick *= 10
+		return nil
+	})
+	defer delete(migrations, SaveVersion-1)
+	g, err := ReadSaveGame(bytes.NewReader(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Tick != 50 {
+		t.Errorf("migrated tick = %d, want 50", g.Tick)
+	}
+
+	binary.LittleEndian.PutUint16(old[4:], SaveVersion+1)
+	if _, err := ReadSaveGame(bytes.NewReader(old)); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("got %v, want a version error", err)
+	}
+}
@@ -12,15 +12,23 @@ import (
 var GalaxyStars *Galaxy
 var Seed int64
 
+// DefaultExposure is the exposure probe cameras shoot the starfield with.
+// Exposure of 50k - 100k is usually the "sweet spot" for this distance.
+const DefaultExposure = 80000.0
+
 func init() {
 	Seed = int64(1772054134190328000)
-	GalaxyStars = GenerateSpiralGalaxy(300000, Seed)
+	GalaxyStars = GenerateSpiralGalaxy(StarCount, Seed)
 }
 
 type Starfield struct {
 	GalaxyStars *Galaxy
 	Camera      *si3d.Camera
 	Position    si3d.Vector3
+	Profile     *CameraProfile // nil uses DefaultCameraProfile
+	Frame       uint64         // frame number, selects the noise seed
+	Exposure    float64        // 0 uses DefaultExposure
+	Gain        float64        // multiplies the profile gain, 0 means 1
 }
 
 func NewStarfield(camera *si3d.Camera, pos si3d.Vector3) *Starfield {
@@ -203,8 +211,48 @@ func (g *Galaxy) TakeProbeSnapshot(
 	exposure float64,
 	seed int64,
 ) *image.RGBA {
+	return g.renderSnapshot(cam, probeGalacticPos, width, height, exposure, DefaultCameraProfile, rand.New(rand.NewSource(seed)))
+}
 
-	r := rand.New(rand.NewSource(seed))
+// TakeProbeSnapshotWithProfile is TakeProbeSnapshot for a camera described by
+// profile, with grain seeded by seed.
+func (g *Galaxy) TakeProbeSnapshotWithProfile(
+	cam *si3d.Camera,
+	probeGalacticPos si3d.Vector3,
+	width,
+	height int,
+	exposure float64,
+	profile CameraProfile,
+	seed int64,
+) *image.RGBA {
+	return g.renderSnapshot(cam, probeGalacticPos, width, height, exposure, profile, rand.New(rand.NewSource(seed)))
+}
+
+// RenderReference renders the view TakeProbeSnapshotWithProfile would produce
+// without sensor noise, for comparing downlinked images against the expected
+// sky.
+func (g *Galaxy) RenderReference(
+	cam *si3d.Camera,
+	probeGalacticPos si3d.Vector3,
+	width,
+	height int,
+	exposure float64,
+	profile CameraProfile,
+) *image.RGBA {
+	return g.renderSnapshot(cam, probeGalacticPos, width, height, exposure, profile, nil)
+}
+
+// renderSnapshot does the work of TakeProbeSnapshot. A nil r disables the
+// sensor noise.
+func (g *Galaxy) renderSnapshot(
+	cam *si3d.Camera,
+	probeGalacticPos si3d.Vector3,
+	width,
+	height int,
+	exposure float64,
+	profile CameraProfile,
+	r *rand.Rand,
+) *image.RGBA {
 
 	// Maximum reach of your widest splat/flare (spikeLen = 12 + safety)
 	gutter := 15
@@ -330,6 +378,7 @@ func (g *Galaxy) TakeProbeSnapshot(
 
 	// 3. Develop with Noise, Tone Mapping, and Scanlines
 	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	hot := profile.hotPixelMap(width, height)
 
 	for x := 0; x < width; x++ {
 		for y := 0; y < height; y++ {
@@ -339,28 +388,36 @@ func (g *Galaxy) TakeProbeSnapshot(
 			bufY := y + gutter
 
 			// Add "Sensor Noise" (grain)
-			noise := (r.Float64() - 0.5) * 0.008
+			noise := 0.0
+			if r != nil {
+				noise = (r.Float64() - 0.5) * profile.ReadNoise
+			}
 
-			sR := math.Max(0, sensorR[bufX][bufY])
-			sG := math.Max(0, sensorG[bufX][bufY])
-			sB := math.Max(0, sensorB[bufX][bufY])
+			sR := math.Max(0, sensorR[bufX][bufY]*profile.Gain+profile.DarkCurrent)
+			sG := math.Max(0, sensorG[bufX][bufY]*profile.Gain+profile.DarkCurrent)
+			sB := math.Max(0, sensorB[bufX][bufY]*profile.Gain+profile.DarkCurrent)
 
-			finalR := sR / (1.0 + sR)
-			finalG := sG / (1.0 + sG)
-			finalB := sB / (1.0 + sB)
+			finalR := profile.Tone.apply(sR)
+			finalG := profile.Tone.apply(sG)
+			finalB := profile.Tone.apply(sB)
 
 			// Space background tint
-			bgR, bgG, bgB := 0.02, 0.02, 0.03
+			bgR, bgG, bgB := profile.Background[0], profile.Background[1], profile.Background[2]
 
 			outR := clamp(int((finalR+bgR+noise)*255), 0, 255)
 			outG := clamp(int((finalG+bgG+noise)*255), 0, 255)
 			outB := clamp(int((finalB+bgB+noise)*255), 0, 255)
 
-			// Darken every even horizontal row by 20% to simulate a telemetry feed
-			if y%2 == 0 {
-				outR = int(float64(outR) * 0.8)
-				outG = int(float64(outG) * 0.8)
-				outB = int(float64(outB) * 0.8)
+			// Darken every even horizontal row to simulate a telemetry feed
+			if profile.Scanline != 0 && y%2 == 0 {
+				keep := 1 - profile.Scanline
+				outR = int(float64(outR) * keep)
+				outG = int(float64(outG) * keep)
+				outB = int(float64(outB) * keep)
+			}
+
+			if hot[y*width+x] {
+				outR, outG, outB = 255, 255, 255
 			}
 
 			img.Set(x, y, color.RGBA{uint8(outR), uint8(outG), uint8(outB), 255})
@@ -547,13 +604,24 @@ func clamp(val, min, max int) int {
 func (s *Starfield) GetStarField(height, width int) *image.RGBA {
 	galaxy := GalaxyStars
 
-	// Exposure of 50k - 100k is usually the "sweet spot" for this distance
-	snapshot := galaxy.TakeProbeSnapshot(
+	profile := DefaultCameraProfile
+	if s.Profile != nil {
+		profile = *s.Profile
+	}
+	if s.Gain != 0 {
+		profile.Gain *= s.Gain
+	}
+	exposure := DefaultExposure
+	if s.Exposure != 0 {
+		exposure = s.Exposure
+	}
+	snapshot := galaxy.TakeProbeSnapshotWithProfile(
 		s.Camera,
 		s.Position,
 		width,
 		height,
-		80000.0,
-		Seed)
+		exposure,
+		profile,
+		profile.frameSeed(s.Frame))
 	return snapshot
 }